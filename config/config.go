@@ -2,17 +2,63 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+const (
+	TransportVKTeams = "vkteams"
+	TransportMemory  = "memory"
+
+	// адрес проверок работоспособности, если HEALTH_ADDR не задан
+	DefaultHealthAddr = ":8081"
+)
+
 type Config struct {
-	BotToken string
-	DatabaseURL string
+	BotToken     string
+	DatabaseURL  string
 	DatabaseName string
-	Debug bool
+	Debug        bool
+	// канал доставки сообщений: vkteams или memory (сообщения только сохраняются в памяти)
+	Transport string
+	// chat id пользователей, которые получают роль admin при старте
+	AdminChatIDs []string
+	// рассылки на эти сегменты отправляются только после подтверждения вторым человеком
+	ProtectedSegments []string
+	// кто подтверждает рассылки; если не задано - все администраторы
+	ApproverChatIDs []string
+	// параллельность и лимиты отправки рассылок
+	NotifierWorkers   int
+	NotifierRate      float64
+	NotifierBurst     int
+	NotifierChatRate  float64
+	NotifierChatBurst int
+	// повторы отправки при временных ошибках
+	NotifierMaxAttempts    int
+	NotifierRetryBaseDelay time.Duration
+	NotifierRetryMaxDelay  time.Duration
+	// на сколько экземпляр бота забирает рассылку для отправки
+	SchedulerLease time.Duration
+	// адрес HTTP API для внутренних сервисов, пустой - API выключен
+	APIAddr string
+	// токены доступа к HTTP API
+	APITokens []string
+	// адрес, на котором отдаются метрики Prometheus, пустой - метрики выключены
+	MetricsAddr string
+	// адрес проверок /healthz и /readyz для оркестратора, они работают всегда
+	HealthAddr string
+	// уровень журнала (debug, info, warn, error) и формат (text или json)
+	LogLevel  string
+	LogFormat string
+	// скрывать в журнале chat id, имена пользователей и тексты сообщений
+	LogRedact bool
 }
 
 func LoadConfig() (*Config, error) {
@@ -21,17 +67,129 @@ func LoadConfig() (*Config, error) {
 		log.Println("No .env file found")
 	}
 
+	env := &envParser{}
 	cfg := &Config{
-		BotToken: os.Getenv("BOT_TOKEN"),
-		DatabaseURL: os.Getenv("DATABASE_URL"),
+		BotToken:     os.Getenv("BOT_TOKEN"),
+		DatabaseURL:  os.Getenv("DATABASE_URL"),
 		DatabaseName: os.Getenv("DATABASE_NAME"),
-		Debug: os.Getenv("DEBUG") == "true",
+		Debug:        os.Getenv("DEBUG") == "true",
+		Transport:    os.Getenv("TRANSPORT"),
+		AdminChatIDs: splitList(os.Getenv("ADMIN_CHAT_IDS")),
+
+		ProtectedSegments: splitList(os.Getenv("PROTECTED_SEGMENTS")),
+		ApproverChatIDs:   splitList(os.Getenv("APPROVER_CHAT_IDS")),
+
+		NotifierWorkers:   env.envInt("NOTIFIER_WORKERS", 10, 1),
+		NotifierRate:      env.envFloat("NOTIFIER_RATE", 30),
+		NotifierBurst:     env.envInt("NOTIFIER_BURST", 30, 0),
+		NotifierChatRate:  env.envFloat("NOTIFIER_CHAT_RATE", 1),
+		NotifierChatBurst: env.envInt("NOTIFIER_CHAT_BURST", 3, 0),
+
+		NotifierMaxAttempts:    env.envInt("NOTIFIER_MAX_ATTEMPTS", 4, 1),
+		NotifierRetryBaseDelay: env.envDuration("NOTIFIER_RETRY_BASE_DELAY", time.Second),
+		NotifierRetryMaxDelay:  env.envDuration("NOTIFIER_RETRY_MAX_DELAY", 30*time.Second),
+
+		SchedulerLease: env.envDuration("SCHEDULER_LEASE", 2*time.Minute),
+
+		APIAddr:   os.Getenv("API_ADDR"),
+		APITokens: splitList(os.Getenv("API_TOKENS")),
+
+		MetricsAddr: os.Getenv("METRICS_ADDR"),
+		HealthAddr:  os.Getenv("HEALTH_ADDR"),
+
+		LogLevel:  os.Getenv("LOG_LEVEL"),
+		LogFormat: os.Getenv("LOG_FORMAT"),
+		LogRedact: os.Getenv("LOG_REDACT") != "false",
 	}
 
-	if cfg.BotToken == "" {
-		return nil, errors.New("bot token is required")
+	// опечатка в числовом параметре не должна молча превращаться в значение по умолчанию
+	if err := errors.Join(env.errs...); err != nil {
+		return nil, err
+	}
+
+	// DEBUG=true по-прежнему включает подробный журнал, если уровень не задан явно
+	if cfg.LogLevel == "" && cfg.Debug {
+		cfg.LogLevel = "debug"
+	}
+
+	if cfg.Transport == "" {
+		cfg.Transport = TransportVKTeams
+	}
+	if cfg.Transport != TransportVKTeams && cfg.Transport != TransportMemory {
+		return nil, fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
 
+	if cfg.HealthAddr == "" {
+		cfg.HealthAddr = DefaultHealthAddr
+	}
+
+	if cfg.APIAddr != "" && len(cfg.APITokens) == 0 {
+		return nil, errors.New("api tokens are required when api is enabled")
+	}
+
+	if cfg.BotToken == "" && cfg.Transport == TransportVKTeams {
+		return nil, errors.New("bot token is required")
 	}
 
 	return cfg, nil
 }
+
+// splitList разбирает список значений через запятую
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envParser читает числовые параметры из окружения и запоминает ошибки разбора.
+// Незаданный параметр получает значение по умолчанию
+type envParser struct {
+	errs []error
+}
+
+// envInt читает целое число не меньше min
+func (p *envParser) envInt(key string, def, min int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min {
+		p.errs = append(p.errs, fmt.Errorf("invalid %s %q: must be an integer >= %d", key, raw, min))
+		return def
+	}
+	return value
+}
+
+// envFloat читает неотрицательное число
+func (p *envParser) envFloat(key string, def float64) float64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		p.errs = append(p.errs, fmt.Errorf("invalid %s %q: must be a non-negative number", key, raw))
+		return def
+	}
+	return value
+}
+
+// envDuration читает положительную длительность, например 30s или 2m
+func (p *envParser) envDuration(key string, def time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value <= 0 {
+		p.errs = append(p.errs, fmt.Errorf("invalid %s %q: must be a positive duration such as 30s", key, raw))
+		return def
+	}
+	return value
+}
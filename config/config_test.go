@@ -0,0 +1,102 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// setBaseEnv задаёт минимальную рабочую конфигурацию без подключения к VK Teams
+func setBaseEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("TRANSPORT", TransportMemory)
+	for _, key := range []string{
+		"NOTIFIER_WORKERS", "NOTIFIER_RATE", "NOTIFIER_BURST", "NOTIFIER_CHAT_RATE", "NOTIFIER_CHAT_BURST",
+		"NOTIFIER_MAX_ATTEMPTS", "NOTIFIER_RETRY_BASE_DELAY", "NOTIFIER_RETRY_MAX_DELAY", "SCHEDULER_LEASE", "API_ADDR",
+		"HEALTH_ADDR",
+	} {
+		t.Setenv(key, "")
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	setBaseEnv(t)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NotifierWorkers != 10 || cfg.NotifierRate != 30 || cfg.NotifierChatBurst != 3 ||
+		cfg.NotifierRetryMaxDelay != 30*time.Second || cfg.SchedulerLease != 2*time.Minute || cfg.HealthAddr != DefaultHealthAddr {
+		t.Errorf("defaults = %+v", cfg)
+	}
+}
+
+func TestLoadConfigNumbers(t *testing.T) {
+	setBaseEnv(t)
+	t.Setenv("NOTIFIER_WORKERS", "4")
+	t.Setenv("NOTIFIER_RATE", "0")
+	t.Setenv("NOTIFIER_RETRY_BASE_DELAY", "250ms")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0 отключает общий лимит и должен сохраниться, а не замениться значением по умолчанию
+	if cfg.NotifierWorkers != 4 || cfg.NotifierRate != 0 || cfg.NotifierRetryBaseDelay != 250*time.Millisecond {
+		t.Errorf("config = %+v", cfg)
+	}
+}
+
+func TestLoadConfigHealthAddr(t *testing.T) {
+	setBaseEnv(t)
+	t.Setenv("HEALTH_ADDR", ":9090")
+	t.Setenv("METRICS_ADDR", ":9090")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HealthAddr != ":9090" || cfg.MetricsAddr != ":9090" {
+		t.Errorf("HealthAddr = %q, MetricsAddr = %q, want :9090", cfg.HealthAddr, cfg.MetricsAddr)
+	}
+}
+
+func TestLoadConfigInvalidNumbers(t *testing.T) {
+	tests := []struct {
+		key, value string
+	}{
+		{"NOTIFIER_WORKERS", "abc"},
+		{"NOTIFIER_WORKERS", "-1"},
+		{"NOTIFIER_WORKERS", "0"},
+		{"NOTIFIER_RATE", "fast"},
+		{"NOTIFIER_RATE", "-5"},
+		{"NOTIFIER_CHAT_RATE", "NaN"},
+		{"NOTIFIER_BURST", "1.5"},
+		{"NOTIFIER_MAX_ATTEMPTS", "0"},
+		{"NOTIFIER_RETRY_BASE_DELAY", "10"},
+		{"SCHEDULER_LEASE", "-1m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			setBaseEnv(t)
+			t.Setenv(tt.key, tt.value)
+
+			_, err := LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), tt.key) {
+				t.Errorf("LoadConfig() error = %v, want error about %s", err, tt.key)
+			}
+		})
+	}
+}
+
+func TestLoadConfigReportsAllErrors(t *testing.T) {
+	setBaseEnv(t)
+	t.Setenv("NOTIFIER_WORKERS", "-1")
+	t.Setenv("NOTIFIER_RATE", "abc")
+
+	_, err := LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "NOTIFIER_WORKERS") || !strings.Contains(err.Error(), "NOTIFIER_RATE") {
+		t.Errorf("LoadConfig() error = %v, want both invalid values", err)
+	}
+}
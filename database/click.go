@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ButtonClickRepository struct {
+	collection *mongo.Collection
+}
+
+func NewButtonClickRepository(db *Database) *ButtonClickRepository {
+	return &ButtonClickRepository{
+		collection: db.GetCollection("button_clicks"),
+	}
+}
+
+// EnsureIndexes создаёт уникальный индекс, по которому Save находит уже сохранённое нажатие.
+// Повторные нажатия, сохранённые до появления индекса, сначала удаляются, иначе индекс не создастся
+func (r *ButtonClickRepository) EnsureIndexes(ctx context.Context) error {
+	if err := r.removeDuplicates(ctx); err != nil {
+		return err
+	}
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "mailing_id", Value: 1}, {Key: "chat_id", Value: 1}, {Key: "button_index", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// removeDuplicates оставляет только первое нажатие каждой кнопки каждым пользователем
+func (r *ButtonClickRepository) removeDuplicates(ctx context.Context) error {
+	cursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"mailing_id": "$mailing_id", "chat_id": "$chat_id", "button_index": "$button_index"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var group struct {
+			IDs []primitive.ObjectID `bson:"ids"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return err
+		}
+		if _, err := r.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": group.IDs[1:]}}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Save сохраняет нажатие кнопки. Повторное нажатие той же кнопки тем же пользователем
+// не добавляет новую запись, поэтому в статистике каждый пользователь учитывается один раз
+func (r *ButtonClickRepository) Save(ctx context.Context, click *models.ButtonClick) error {
+	click.CreatedAt = time.Now().UTC()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"mailing_id": click.MailingID, "chat_id": click.ChatID, "button_index": click.ButtonIndex},
+		bson.M{
+			"$set":         bson.M{"button_text": click.ButtonText},
+			"$setOnInsert": bson.M{"created_at": click.CreatedAt},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *ButtonClickRepository) ListByMailing(ctx context.Context, mailingID primitive.ObjectID) ([]*models.ButtonClick, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"mailing_id": mailingID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var clicks []*models.ButtonClick
+	if err := cursor.All(ctx, &clicks); err != nil {
+		return nil, err
+	}
+	return clicks, nil
+}
+
+// CountByButton возвращает количество пользователей, нажавших каждую кнопку рассылки, по её индексу.
+// Повторные нажатия, сохранённые до появления уникального индекса, не учитываются
+func (r *ButtonClickRepository) CountByButton(ctx context.Context, mailingID primitive.ObjectID) (map[int]int, error) {
+	cursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"mailing_id": mailingID}}},
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"button_index": "$button_index", "chat_id": "$chat_id"}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$_id.button_index", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Index int `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int]int, len(rows))
+	for _, row := range rows {
+		counts[row.Index] = row.Count
+	}
+	return counts, nil
+}
@@ -35,6 +35,11 @@ func Connect(ctx context.Context, uri string) (*Database, error) {
 }
 
 
-func (d *Database) GetCollection(name string) *mongo.Collection {
-	return d.Database(d.db).Collection(name)
-}
\ No newline at end of file
+func (d *Database) GetCollection(name string, opts ...*options.CollectionOptions) *mongo.Collection {
+	return d.Database(d.db).Collection(name, opts...)
+}
+
+// CheckHealth проверяет, что MongoDB отвечает
+func (d *Database) CheckHealth(ctx context.Context) error {
+	return d.Ping(ctx, readpref.Primary())
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DeadLetterRepository struct {
+	collection *mongo.Collection
+}
+
+func NewDeadLetterRepository(db *Database) *DeadLetterRepository {
+	return &DeadLetterRepository{
+		collection: db.GetCollection("dead_letters"),
+	}
+}
+
+func (r *DeadLetterRepository) Create(ctx context.Context, letter *models.DeadLetter) error {
+	letter.CreatedAt = time.Now().UTC()
+	letter.UpdatedAt = letter.CreatedAt
+
+	_, err := r.collection.InsertOne(ctx, letter)
+	return err
+}
+
+func (r *DeadLetterRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.DeadLetter, error) {
+	var letter models.DeadLetter
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&letter)
+	if err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+func (r *DeadLetterRepository) Update(ctx context.Context, letter *models.DeadLetter) error {
+	letter.UpdatedAt = time.Now().UTC()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": letter.ID},
+		bson.M{"$set": letter},
+	)
+	return err
+}
+
+func (r *DeadLetterRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// List возвращает записи, начиная со старых; limit 0 - без ограничения
+func (r *DeadLetterRepository) List(ctx context.Context, limit int64) ([]*models.DeadLetter, error) {
+	return r.find(ctx, bson.M{}, limit)
+}
+
+func (r *DeadLetterRepository) ListByMailing(ctx context.Context, mailingID primitive.ObjectID, limit int64) ([]*models.DeadLetter, error) {
+	return r.find(ctx, bson.M{"mailing_id": mailingID}, limit)
+}
+
+func (r *DeadLetterRepository) Count(ctx context.Context) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{})
+}
+
+func (r *DeadLetterRepository) find(ctx context.Context, filter bson.M, limit int64) ([]*models.DeadLetter, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var letters []*models.DeadLetter
+	if err := cursor.All(ctx, &letters); err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
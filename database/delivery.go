@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DeliveryRepository struct {
+	collection *mongo.Collection
+}
+
+func NewDeliveryRepository(db *Database) *DeliveryRepository {
+	return &DeliveryRepository{
+		collection: db.GetCollection("deliveries"),
+	}
+}
+
+// EnsureIndexes создаёт индекс для SentChatIDs и подсчёта итогов отправки: журнал растёт
+// на каждого получателя каждой рассылки, и без индекса эти выборки читали бы его целиком
+func (r *DeliveryRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "mailing_id", Value: 1},
+			{Key: "occurrence", Value: 1},
+			{Key: "status", Value: 1},
+			{Key: "chat_id", Value: 1},
+		},
+	})
+	return err
+}
+
+func (r *DeliveryRepository) Create(ctx context.Context, delivery *models.Delivery) error {
+	delivery.CreatedAt = time.Now().UTC()
+
+	_, err := r.collection.InsertOne(ctx, delivery)
+	return err
+}
+
+func (r *DeliveryRepository) ListByMailing(ctx context.Context, mailingID primitive.ObjectID) ([]*models.Delivery, error) {
+	return r.find(ctx, bson.M{"mailing_id": mailingID})
+}
+
+func (r *DeliveryRepository) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]*models.Delivery, error) {
+	return r.find(ctx, bson.M{"user_id": userID})
+}
+
+func (r *DeliveryRepository) ListByChatID(ctx context.Context, chatID string) ([]*models.Delivery, error) {
+	return r.find(ctx, bson.M{"chat_id": chatID})
+}
+
+// SentChatIDs возвращает чаты, в которые отправка рассылки с номером occurrence уже прошла успешно
+func (r *DeliveryRepository) SentChatIDs(ctx context.Context, mailingID primitive.ObjectID, occurrence int) (map[string]bool, error) {
+	values, err := r.collection.Distinct(ctx, "chat_id", bson.M{
+		"mailing_id": mailingID,
+		"occurrence": occurrence,
+		"status":     models.DeliverySent,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	chatIDs := make(map[string]bool, len(values))
+	for _, value := range values {
+		if chatID, ok := value.(string); ok {
+			chatIDs[chatID] = true
+		}
+	}
+	return chatIDs, nil
+}
+
+// CountByStatus возвращает количество попыток отправки рассылки по статусам
+func (r *DeliveryRepository) CountByStatus(ctx context.Context, mailingID primitive.ObjectID) (map[models.DeliveryStatus]int, error) {
+	cursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"mailing_id": mailingID}}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Status models.DeliveryStatus `bson:"_id"`
+		Count  int                   `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[models.DeliveryStatus]int, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// CountOccurrence возвращает количество получателей отправки рассылки с номером occurrence по статусам
+func (r *DeliveryRepository) CountOccurrence(ctx context.Context, mailingID primitive.ObjectID, occurrence int) (map[models.DeliveryStatus]int, error) {
+	counts := make(map[models.DeliveryStatus]int)
+	for _, status := range []models.DeliveryStatus{models.DeliverySent, models.DeliveryFailed} {
+		values, err := r.collection.Distinct(ctx, "chat_id", bson.M{
+			"mailing_id": mailingID,
+			"occurrence": occurrence,
+			"status":     status,
+		})
+		if err != nil {
+			return nil, err
+		}
+		counts[status] = len(values)
+	}
+	return counts, nil
+}
+
+func (r *DeliveryRepository) find(ctx context.Context, filter bson.M) ([]*models.Delivery, error) {
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var deliveries []*models.Delivery
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -2,13 +2,13 @@ package database
 
 import (
 	"context"
-	"log"
 	"time"
 
 	"github.com/g0shi4ek/VK_bot/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MailingRepository struct {
@@ -23,6 +23,9 @@ func NewMailingRepository(db *Database) *MailingRepository {
 
 func (r *MailingRepository) Create(ctx context.Context, mailing *models.Mailing) error {
 	//mailing.CreatedAt = time.Now().UTC().Truncate(time.Minute)
+	if mailing.ID.IsZero() {
+		mailing.ID = primitive.NewObjectID()
+	}
 	mailing.UpdatedAt = time.Now().UTC().Truncate(time.Minute)
 
 	_, err := r.collection.InsertOne(ctx, mailing)
@@ -49,40 +52,189 @@ func (r *MailingRepository) Update(ctx context.Context, mailing *models.Mailing)
 	return err
 }
 
+// UpdateInStatus сохраняет рассылку, только если она всё ещё в одном из состояний statuses.
+// Возвращает mongo.ErrNoDocuments, если рассылка не найдена или её состояние изменилось
+func (r *MailingRepository) UpdateInStatus(ctx context.Context, mailing *models.Mailing, statuses ...models.MailingStatus) error {
+	mailing.UpdatedAt = time.Now().UTC().Truncate(time.Minute)
+
+	res, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": mailing.ID, "status": bson.M{"$in": statuses}},
+		bson.M{"$set": mailing},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteInStatus удаляет рассылку, только если она в одном из состояний statuses
+func (r *MailingRepository) DeleteInStatus(ctx context.Context, id primitive.ObjectID, statuses ...models.MailingStatus) error {
+	res, err := r.collection.DeleteOne(ctx, bson.M{"_id": id, "status": bson.M{"$in": statuses}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
 func (r *MailingRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
 	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
 	return err
 }
 
-func (r *MailingRepository) GetPendingMailings(ctx context.Context, now time.Time) ([]*models.Mailing, error) {
-	log.Println("check all before: ", now.UTC().Truncate(time.Minute))
+// EnsureIndexes создаёт индексы для выборок планировщика: ClaimDue ищет запланированные рассылки
+// по scheduled_at и отправляемые с истёкшей арендой по lease_expires_at, ExpireUnapproved -
+// неподтверждённые по scheduled_at
+func (r *MailingRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "scheduled_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_expires_at", Value: 1}}},
+	})
+	return err
+}
+
+// ClaimDue атомарно забирает одну рассылку, время которой наступило, и выдаёт owner аренду на lease.
+// Рассылки в состоянии sending с истёкшей арендой (экземпляр бота упал во время отправки) забираются повторно.
+// Возвращает nil, если забирать нечего
+func (r *MailingRepository) ClaimDue(ctx context.Context, now time.Time, owner string, lease time.Duration) (*models.Mailing, error) {
+	now = now.UTC()
+	expiresAt := now.Add(lease)
 
-	cursor, err := r.collection.Find(ctx, bson.M{
-		"scheduled_at": bson.M{
-			"$lte": now.UTC().Truncate(time.Minute), // Все, чьё время уже наступило
+	filter := bson.M{
+		"scheduled_at": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"status": models.MailingScheduled},
+			bson.M{"status": models.MailingSending, "lease_expires_at": bson.M{"$lt": now}},
 		},
-		"is_sent": false,
-	})
+	}
+	// pipeline-обновление, чтобы записать в историю предыдущее состояние
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"status_history": bson.M{"$concatArrays": bson.A{
+				bson.M{"$ifNull": bson.A{"$status_history", bson.A{}}},
+				bson.A{bson.M{"from": "$status", "to": models.MailingSending, "at": now}},
+			}},
+			"status":           models.MailingSending,
+			"lease_owner":      bson.M{"$literal": owner},
+			"lease_expires_at": expiresAt,
+			"updated_at":       now.Truncate(time.Minute),
+		}}},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"scheduled_at": 1}).
+		SetReturnDocument(options.After)
+
+	var mailing models.Mailing
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&mailing)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &mailing, nil
+}
+
+// ExpireUnapproved атомарно возвращает в черновик одну рассылку, которую не подтвердили до даты отправки.
+// Возвращает nil, если таких рассылок нет
+func (r *MailingRepository) ExpireUnapproved(ctx context.Context, now time.Time) (*models.Mailing, error) {
+	now = now.UTC()
+
+	filter := bson.M{
+		"scheduled_at": bson.M{"$lte": now},
+		"status":       models.MailingPendingApproval,
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"status_history": bson.M{"$concatArrays": bson.A{
+				bson.M{"$ifNull": bson.A{"$status_history", bson.A{}}},
+				bson.A{bson.M{"from": "$status", "to": models.MailingDraft, "at": now}},
+			}},
+			"status":     models.MailingDraft,
+			"updated_at": now.Truncate(time.Minute),
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var mailing models.Mailing
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&mailing)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &mailing, nil
+}
+
+// RenewLease продлевает аренду рассылки. Возвращает mongo.ErrNoDocuments, если аренда потеряна
+func (r *MailingRepository) RenewLease(ctx context.Context, id primitive.ObjectID, owner string, lease time.Duration) error {
+	res, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status": models.MailingSending, "lease_owner": owner},
+		bson.M{"$set": bson.M{"lease_expires_at": time.Now().UTC().Add(lease)}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// CompleteClaimed сохраняет рассылку после отправки и снимает аренду.
+// Возвращает mongo.ErrNoDocuments, если аренду успел забрать другой экземпляр
+func (r *MailingRepository) CompleteClaimed(ctx context.Context, mailing *models.Mailing, owner string) error {
+	mailing.UpdatedAt = time.Now().UTC().Truncate(time.Minute)
+	mailing.LeaseOwner = ""
+	mailing.LeaseExpiresAt = nil
+
+	res, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": mailing.ID, "lease_owner": owner},
+		bson.M{
+			"$set":   mailing,
+			"$unset": bson.M{"lease_owner": "", "lease_expires_at": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *MailingRepository) ListAll(ctx context.Context) ([]*models.Mailing, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{})
 	if err != nil {
-		log.Println("ERROR")
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
 	var mailings []*models.Mailing
 	if err := cursor.All(ctx, &mailings); err != nil {
-		log.Println("ERROR")
 		return nil, err
 	}
-	if len(mailings) == 0 {
-		log.Println("0 mailings")
-		return nil, nil
-	}
 	return mailings, nil
 }
 
-func (r *MailingRepository) ListAll(ctx context.Context) ([]*models.Mailing, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{})
+// List возвращает страницу рассылок по запросу filter, начиная с новых
+func (r *MailingRepository) List(ctx context.Context, filter bson.M, offset, limit int64) ([]*models.Mailing, error) {
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetSkip(offset)
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -94,3 +246,75 @@ func (r *MailingRepository) ListAll(ctx context.Context) ([]*models.Mailing, err
 	}
 	return mailings, nil
 }
+
+func (r *MailingRepository) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return r.collection.CountDocuments(ctx, filter)
+}
+
+// CountByStatus возвращает количество рассылок в каждом состоянии
+func (r *MailingRepository) CountByStatus(ctx context.Context) (map[models.MailingStatus]int, error) {
+	cursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Status models.MailingStatus `bson:"_id"`
+		Count  int                  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[models.MailingStatus]int, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// MigrateIsSent переводит рассылки, созданные до появления состояний, с флага is_sent на поле status
+func (r *MailingRepository) MigrateIsSent(ctx context.Context) (int64, error) {
+	now := time.Now().UTC()
+	var migrated int64
+	for _, m := range []struct {
+		isSent bool
+		status models.MailingStatus
+	}{
+		{true, models.MailingSent},
+		{false, models.MailingScheduled},
+	} {
+		res, err := r.collection.UpdateMany(
+			ctx,
+			bson.M{"status": bson.M{"$exists": false}, "is_sent": m.isSent},
+			bson.M{
+				"$set": bson.M{
+					"status":         m.status,
+					"status_history": migratedHistory(m.status, now),
+				},
+				"$unset": bson.M{"is_sent": ""},
+			},
+		)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += res.ModifiedCount
+	}
+	return migrated, nil
+}
+
+// migratedHistory возвращает историю состояний старой рассылки: путь до status по допустимым переходам,
+// чтобы история не расходилась с жизненным циклом
+func migratedHistory(status models.MailingStatus, at time.Time) []models.StatusChange {
+	history := []models.StatusChange{{From: models.MailingDraft, To: models.MailingScheduled, At: at}}
+	if status == models.MailingSent {
+		history = append(history,
+			models.StatusChange{From: models.MailingScheduled, To: models.MailingSending, At: at},
+			models.StatusChange{From: models.MailingSending, To: models.MailingSent, At: at},
+		)
+	}
+	return history
+}
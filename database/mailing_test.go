@@ -0,0 +1,27 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+func TestMigratedHistory(t *testing.T) {
+	at := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+	for _, status := range []models.MailingStatus{models.MailingScheduled, models.MailingSent} {
+		history := migratedHistory(status, at)
+
+		// история должна проходить по переходам, которые разрешает жизненный цикл
+		from := models.MailingDraft
+		for _, change := range history {
+			if change.From != from || !change.From.CanTransitionTo(change.To) || !change.At.Equal(at) {
+				t.Fatalf("migratedHistory(%s) = %+v, invalid change %+v", status, history, change)
+			}
+			from = change.To
+		}
+		if from != status {
+			t.Errorf("migratedHistory(%s) ends in %s", status, from)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type SegmentRepository struct {
@@ -63,6 +64,30 @@ func (r *SegmentRepository) ListAll(ctx context.Context) ([]*models.Segment, err
 	return segments, nil
 }
 
+// List возвращает страницу сегментов по запросу filter в алфавитном порядке
+func (r *SegmentRepository) List(ctx context.Context, filter bson.M, offset, limit int64) ([]*models.Segment, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1}).SetSkip(offset)
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var segments []*models.Segment
+	if err := cursor.All(ctx, &segments); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+func (r *SegmentRepository) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return r.collection.CountDocuments(ctx, filter)
+}
+
 func (r *SegmentRepository) Update(ctx context.Context, segment *models.Segment) error {
 	segment.UpdatedAt = time.Now().UTC().Truncate(time.Minute)
 
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type UserStateRepository struct {
+	collection *mongo.Collection
+}
+
+func NewUserStateRepository(db *Database) *UserStateRepository {
+	return &UserStateRepository{
+		collection: db.GetCollection("user_states", options.Collection().SetRegistry(newStateRegistry())),
+	}
+}
+
+// newStateRegistry возвращает реестр кодеков для коллекции состояний. Данные состояния
+// хранятся как interface{}, поэтому без своего реестра даты вернутся как primitive.DateTime,
+// а вложенные документы как bson.D вместо карт
+func newStateRegistry() *bsoncodec.Registry {
+	registry := bson.NewRegistry()
+	registry.RegisterTypeMapEntry(bson.TypeDateTime, reflect.TypeOf(time.Time{}))
+	registry.RegisterTypeMapEntry(bson.TypeEmbeddedDocument, reflect.TypeOf(bson.M{}))
+	return registry
+}
+
+// EnsureIndexes создаёт уникальный индекс по chat_id: у пользователя одно состояние,
+// и без индекса одновременные upsert в Save могли бы создать два документа
+func (r *UserStateRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "chat_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (r *UserStateRepository) Get(ctx context.Context, chatID string) (*models.UserState, error) {
+	var state models.UserState
+	err := r.collection.FindOne(ctx, bson.M{"chat_id": chatID}).Decode(&state)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if state.Data == nil {
+		state.Data = make(map[string]interface{})
+	}
+	return &state, nil
+}
+
+func (r *UserStateRepository) Save(ctx context.Context, state *models.UserState) error {
+	state.UpdatedAt = time.Now().UTC()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"chat_id": state.ChatID},
+		bson.M{"$set": bson.M{
+			"status":     state.Status,
+			"data":       state.Data,
+			"updated_at": state.UpdatedAt,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *UserStateRepository) Delete(ctx context.Context, chatID string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"chat_id": chatID})
+	return err
+}
+
+// CountByStatus возвращает количество незавершённых многошаговых команд на каждом шаге
+func (r *UserStateRepository) CountByStatus(ctx context.Context) (map[string]int, error) {
+	cursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Status string `bson:"_id"`
+		Count  int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
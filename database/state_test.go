@@ -0,0 +1,77 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStateRegistryRoundTrip(t *testing.T) {
+	registry := newStateRegistry()
+	scheduledAt := time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	state := &models.UserState{
+		ChatID: "user@example.com",
+		Status: "waiting_for_date",
+		Data: map[string]interface{}{
+			"name":         "Новогодняя рассылка",
+			"scheduled_at": scheduledAt,
+			"button":       bson.M{"text": "Да", "index": int32(0)},
+		},
+		UpdatedAt: scheduledAt,
+	}
+
+	raw, err := bson.MarshalWithRegistry(registry, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded models.UserState
+	if err := bson.UnmarshalWithRegistry(registry, raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := decoded.Data["scheduled_at"].(time.Time)
+	if !ok {
+		t.Fatalf("scheduled_at decoded as %T, want time.Time", decoded.Data["scheduled_at"])
+	}
+	if !got.Equal(scheduledAt) {
+		t.Errorf("scheduled_at = %v, want %v", got, scheduledAt)
+	}
+
+	// вложенный документ должен вернуться картой, а не bson.D
+	var buttonText interface{}
+	switch button := decoded.Data["button"].(type) {
+	case bson.M:
+		buttonText = button["text"]
+	case map[string]interface{}:
+		buttonText = button["text"]
+	default:
+		t.Fatalf("button decoded as %T, want a map", decoded.Data["button"])
+	}
+	if buttonText != "Да" {
+		t.Errorf("button text = %v, want Да", buttonText)
+	}
+	if decoded.Data["name"] != "Новогодняя рассылка" || decoded.Status != state.Status || decoded.ChatID != state.ChatID {
+		t.Errorf("decoded state = %+v, want %+v", decoded, *state)
+	}
+}
+
+func TestDefaultRegistryLosesTimeType(t *testing.T) {
+	// без своего реестра дата из interface{} возвращается как primitive.DateTime,
+	// и шаги мастера не смогли бы её прочитать
+	raw, err := bson.Marshal(bson.M{"data": bson.M{"at": time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Data map[string]interface{} `bson:"data"`
+	}
+	if err := bson.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded.Data["at"].(time.Time); ok {
+		t.Error("default registry decodes time.Time, newStateRegistry is no longer needed")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UserRepository struct {
@@ -57,6 +58,100 @@ func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
 	return err
 }
 
+// SetRole меняет роль пользователя, возвращает mongo.ErrNoDocuments если пользователь не найден
+func (r *UserRepository) SetRole(ctx context.Context, chatID string, role models.Role) error {
+	res, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"chat_id": chatID},
+		bson.M{"$set": bson.M{
+			"role":       role,
+			"updated_at": time.Now().UTC().Truncate(time.Minute),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// SetOptedOut отписывает пользователя от рассылок или возвращает подписку
+func (r *UserRepository) SetOptedOut(ctx context.Context, chatID string, optedOut bool) error {
+	res, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"chat_id": chatID},
+		bson.M{"$set": bson.M{
+			"opted_out":  optedOut,
+			"updated_at": time.Now().UTC().Truncate(time.Minute),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *UserRepository) SetTimezone(ctx context.Context, chatID, timezone string) error {
+	res, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"chat_id": chatID},
+		bson.M{"$set": bson.M{
+			"timezone":   timezone,
+			"updated_at": time.Now().UTC().Truncate(time.Minute),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// SetAttribute задаёт произвольное поле пользователя, пустое значение удаляет поле
+func (r *UserRepository) SetAttribute(ctx context.Context, chatID, key, value string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"attributes." + key: value,
+			"updated_at":        time.Now().UTC().Truncate(time.Minute),
+		},
+	}
+	if value == "" {
+		update = bson.M{
+			"$unset": bson.M{"attributes." + key: ""},
+			"$set":   bson.M{"updated_at": time.Now().UTC().Truncate(time.Minute)},
+		}
+	}
+
+	res, err := r.collection.UpdateOne(ctx, bson.M{"chat_id": chatID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// PullSegment убирает сегмент у всех пользователей, например после удаления сегмента
+func (r *UserRepository) PullSegment(ctx context.Context, segment string) error {
+	_, err := r.collection.UpdateMany(
+		ctx,
+		bson.M{"segments": segment},
+		bson.M{
+			"$pull": bson.M{"segments": segment},
+			"$set":  bson.M{"updated_at": time.Now().UTC().Truncate(time.Minute)},
+		},
+	)
+	return err
+}
+
 func (r *UserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
 	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
 	return err
@@ -75,3 +170,100 @@ func (r *UserRepository) ListBySegment(ctx context.Context, segment string) ([]*
 	}
 	return users, nil
 }
+
+func (r *UserRepository) ListByRole(ctx context.Context, role models.Role) ([]*models.User, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"role": role})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []*models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ListByFilter возвращает пользователей по произвольному запросу, например по правилу динамического сегмента
+func (r *UserRepository) ListByFilter(ctx context.Context, filter bson.M) ([]*models.User, error) {
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []*models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ListPage возвращает страницу пользователей по запросу filter в порядке регистрации
+func (r *UserRepository) ListPage(ctx context.Context, filter bson.M, offset, limit int64) ([]*models.User, error) {
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetSkip(offset)
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []*models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *UserRepository) CountByFilter(ctx context.Context, filter bson.M) (int64, error) {
+	return r.collection.CountDocuments(ctx, filter)
+}
+
+// CountBySegment возвращает количество участников каждого сегмента, в который пользователи вступили сами
+func (r *UserRepository) CountBySegment(ctx context.Context) (map[string]int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$segments"}},
+		{{Key: "$group", Value: bson.M{"_id": "$segments", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Segment string `bson:"_id"`
+		Count   int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Segment] = row.Count
+	}
+	return counts, nil
+}
+
+// DistinctTimezones возвращает часовые пояса пользователей, подходящих под filter.
+// Пользователи без часового пояса здесь не учитываются
+func (r *UserRepository) DistinctTimezones(ctx context.Context, filter bson.M) ([]string, error) {
+	values, err := r.collection.Distinct(ctx, "timezone", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	timezones := make([]string, 0, len(values))
+	for _, value := range values {
+		if timezone, ok := value.(string); ok && timezone != "" {
+			timezones = append(timezones, timezone)
+		}
+	}
+	return timezones, nil
+}
@@ -0,0 +1,504 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/internal/bot"
+	"github.com/g0shi4ek/VK_bot/internal/notifier"
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/internal/utils"
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type buttonJSON struct {
+	Text string `json:"text"`
+	URL  string `json:"url,omitempty"`
+}
+
+type approvalJSON struct {
+	ChatID   string    `json:"chat_id"`
+	Approved bool      `json:"approved"`
+	At       time.Time `json:"at"`
+}
+
+type mailingJSON struct {
+	ID              string               `json:"id"`
+	Name            string               `json:"name"`
+	Message         string               `json:"message"`
+	Segments        []string             `json:"segments"`
+	ExcludeSegments []string             `json:"exclude_segments"`
+	FileID          string               `json:"file_id,omitempty"`
+	FileType        string               `json:"file_type,omitempty"`
+	Buttons         []buttonJSON         `json:"buttons"`
+	ScheduledAt     time.Time            `json:"scheduled_at"`
+	Timezone        string               `json:"timezone"`
+	LocalTime       bool                 `json:"local_time"`
+	OccurrenceAt    *time.Time           `json:"occurrence_at,omitempty"`
+	Status          models.MailingStatus `json:"status"`
+	Recurrence      string               `json:"recurrence,omitempty"`
+	RecurrenceEnd   *time.Time           `json:"recurrence_end,omitempty"`
+	MaxOccurrences  int                  `json:"max_occurrences,omitempty"`
+	Occurrences     int                  `json:"occurrences"`
+	Mandatory       bool                 `json:"mandatory"`
+	AuthorChatID    string               `json:"author_chat_id,omitempty"`
+	Approval        *approvalJSON        `json:"approval,omitempty"`
+	CreatedAt       time.Time            `json:"created_at"`
+	UpdatedAt       time.Time            `json:"updated_at"`
+}
+
+func newMailingJSON(mailing *models.Mailing) mailingJSON {
+	out := mailingJSON{
+		ID:              mailing.ID.Hex(),
+		Name:            mailing.Name,
+		Message:         mailing.Message,
+		Segments:        mailing.IncludedSegments(),
+		ExcludeSegments: mailing.ExcludeSegments,
+		FileID:          mailing.FileID,
+		FileType:        mailing.FileType,
+		Buttons:         []buttonJSON{},
+		ScheduledAt:     mailing.ScheduledAt,
+		Timezone:        utils.LoadTimezone(mailing.Timezone).String(),
+		LocalTime:       mailing.LocalTime,
+		OccurrenceAt:    mailing.OccurrenceAt,
+		Status:          mailing.Status,
+		Recurrence:      mailing.Recurrence,
+		RecurrenceEnd:   mailing.RecurrenceEnd,
+		MaxOccurrences:  mailing.MaxOccurrences,
+		Occurrences:     mailing.Occurrences,
+		Mandatory:       mailing.Mandatory,
+		AuthorChatID:    mailing.AuthorChatID,
+		CreatedAt:       mailing.CreatedAt,
+		UpdatedAt:       mailing.UpdatedAt,
+	}
+	if out.ExcludeSegments == nil {
+		out.ExcludeSegments = []string{}
+	}
+	for _, button := range mailing.Buttons {
+		out.Buttons = append(out.Buttons, buttonJSON{Text: button.Text, URL: button.URL})
+	}
+	if mailing.Approval != nil {
+		out.Approval = &approvalJSON{
+			ChatID:   mailing.Approval.ChatID,
+			Approved: mailing.Approval.Approved,
+			At:       mailing.Approval.At,
+		}
+	}
+	return out
+}
+
+// mailingRequest - поля рассылки при создании и изменении; незаданные поля не меняются
+type mailingRequest struct {
+	Name            *string       `json:"name"`
+	Message         *string       `json:"message"`
+	Segments        *[]string     `json:"segments"`
+	ExcludeSegments *[]string     `json:"exclude_segments"`
+	FileID          *string       `json:"file_id"`
+	Buttons         *[]buttonJSON `json:"buttons"`
+	// дата в том же виде, что и в мастере бота: «31.12.2024 10:00», «завтра в 10», RFC3339
+	ScheduledAt *string `json:"scheduled_at"`
+	Timezone    *string `json:"timezone"`
+	LocalTime   *bool   `json:"local_time"`
+	// правило повторения как в мастере бота: «каждый день в 10:00» или cron-выражение; пустое - без повторов
+	Recurrence     *string `json:"recurrence"`
+	RecurrenceEnd  *string `json:"recurrence_end"`
+	MaxOccurrences *int    `json:"max_occurrences"`
+	Mandatory      *bool   `json:"mandatory"`
+	AuthorChatID   *string `json:"author_chat_id"`
+}
+
+// GET /api/mailings?status=&segment=&author_chat_id=&limit=&offset=
+func (s *Server) handleListMailings(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := MailingFilter{
+		Status:       models.MailingStatus(query.Get("status")),
+		Segment:      query.Get("segment"),
+		AuthorChatID: query.Get("author_chat_id"),
+	}
+	if filter.Status != "" && !filter.Status.Valid() {
+		s.writeFailure(w, r, badRequest("неизвестный статус %s", filter.Status))
+		return
+	}
+
+	mailings, total, err := s.store.ListMailings(r.Context(), filter, offset, limit)
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	items := make([]mailingJSON, 0, len(mailings))
+	for _, mailing := range mailings {
+		items = append(items, newMailingJSON(mailing))
+	}
+	s.writeJSON(w, http.StatusOK, page{Items: items, Total: total, Limit: limit, Offset: offset})
+}
+
+// GET /api/mailings/{id}
+func (s *Server) handleGetMailing(w http.ResponseWriter, r *http.Request) {
+	mailing, err := s.loadMailing(r.Context(), r.PathValue("id"))
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newMailingJSON(mailing))
+}
+
+// POST /api/mailings
+func (s *Server) handleCreateMailing(w http.ResponseWriter, r *http.Request) {
+	var req mailingRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	mailing := &models.Mailing{Status: models.MailingDraft}
+	if err := s.applyMailingRequest(ctx, mailing, &req, true); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	if err := s.bot.SubmitMailing(ctx, mailing, time.Now()); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	if err := s.store.CreateMailing(ctx, mailing); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	s.logger.InfoContext(ctx, "Mailing created via API", "mailing_id", mailing.ID.Hex(),
+		"author_chat_id", mailing.AuthorChatID, "status", mailing.Status, "scheduled_at", mailing.ScheduledAt)
+
+	if mailing.Status == models.MailingPendingApproval {
+		s.bot.RequestApproval(ctx, mailing)
+	}
+	s.writeJSON(w, http.StatusCreated, newMailingJSON(mailing))
+}
+
+// PATCH /api/mailings/{id}
+func (s *Server) handleUpdateMailing(w http.ResponseWriter, r *http.Request) {
+	var req mailingRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	mailing, err := s.loadMailing(ctx, r.PathValue("id"))
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	if !bot.IsEditable(mailing) {
+		s.writeFailure(w, r, conflict("рассылку в состоянии %s изменить нельзя", mailing.Status))
+		return
+	}
+
+	if err := s.applyMailingRequest(ctx, mailing, &req, false); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	// изменённую рассылку на защищённый сегмент нужно подтвердить заново
+	previous := mailing.Status
+	if err := s.bot.SubmitMailing(ctx, mailing, time.Now()); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	err = s.store.UpdateMailingInStatus(ctx, mailing, previous)
+	if err == mongo.ErrNoDocuments {
+		s.writeFailure(w, r, conflict("рассылка уже отправляется или была отменена, изменения не сохранены"))
+		return
+	}
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	if mailing.Status == models.MailingPendingApproval {
+		s.bot.RequestApproval(ctx, mailing)
+	}
+	s.writeJSON(w, http.StatusOK, newMailingJSON(mailing))
+}
+
+// DELETE /api/mailings/{id}
+func (s *Server) handleDeleteMailing(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	mailing, err := s.loadMailing(ctx, r.PathValue("id"))
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	err = s.store.DeleteMailingInStatus(ctx, mailing.ID,
+		models.MailingDraft, models.MailingPendingApproval, models.MailingScheduled, models.MailingSent,
+		models.MailingPartiallyFailed, models.MailingFailed, models.MailingCancelled)
+	if err == mongo.ErrNoDocuments {
+		s.writeFailure(w, r, conflict("рассылка сейчас отправляется, удалить её нельзя"))
+		return
+	}
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/mailings/{id}/cancel
+func (s *Server) handleCancelMailing(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	mailing, err := s.loadMailing(ctx, r.PathValue("id"))
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	previous := mailing.Status
+	if err := mailing.Transition(models.MailingCancelled, time.Now()); err != nil {
+		s.writeFailure(w, r, conflict("рассылку в состоянии %s отменить нельзя", previous))
+		return
+	}
+
+	err = s.store.UpdateMailingInStatus(ctx, mailing, previous)
+	if err == mongo.ErrNoDocuments {
+		s.writeFailure(w, r, conflict("состояние рассылки изменилось, попробуйте ещё раз"))
+		return
+	}
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newMailingJSON(mailing))
+}
+
+func (s *Server) loadMailing(ctx context.Context, hex string) (*models.Mailing, error) {
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return nil, badRequest("неверный id рассылки")
+	}
+	mailing, err := s.store.GetMailing(ctx, id)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFound("рассылка не найдена")
+	}
+	return mailing, err
+}
+
+// applyMailingRequest переносит поля запроса в рассылку с теми же проверками, что в мастере /create_mailing
+func (s *Server) applyMailingRequest(ctx context.Context, mailing *models.Mailing, req *mailingRequest, creating bool) error {
+	if req.AuthorChatID != nil {
+		if !creating {
+			return badRequest("author_chat_id задаётся только при создании рассылки")
+		}
+		mailing.AuthorChatID = strings.TrimSpace(*req.AuthorChatID)
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return badRequest("название рассылки не может быть пустым")
+		}
+		mailing.Name = name
+	}
+
+	if req.Segments != nil || req.ExcludeSegments != nil {
+		if err := s.applyTargeting(ctx, mailing, req); err != nil {
+			return err
+		}
+	}
+
+	if err := s.applySchedule(ctx, mailing, req, creating); err != nil {
+		return err
+	}
+	if err := applyRecurrence(mailing, req); err != nil {
+		return err
+	}
+
+	if req.Message != nil {
+		if err := notifier.ValidateTemplate(*req.Message); err != nil {
+			return badRequest("ошибка в шаблоне сообщения: %v", err)
+		}
+		mailing.Message = *req.Message
+	}
+	if req.FileID != nil {
+		if err := s.applyFile(mailing, strings.TrimSpace(*req.FileID)); err != nil {
+			return err
+		}
+	}
+	if mailing.Message == "" && mailing.FileID == "" {
+		return badRequest("сообщение пустое: укажите message или file_id")
+	}
+
+	if req.Buttons != nil {
+		buttons := make([]models.Button, 0, len(*req.Buttons))
+		for _, button := range *req.Buttons {
+			buttons = append(buttons, models.Button{Text: strings.TrimSpace(button.Text), URL: strings.TrimSpace(button.URL)})
+		}
+		if err := bot.ValidateButtons(buttons); err != nil {
+			return badRequest("%v", err)
+		}
+		mailing.Buttons = nil
+		if len(buttons) > 0 {
+			mailing.Buttons = buttons
+		}
+	}
+
+	if req.Mandatory != nil {
+		mailing.Mandatory = *req.Mandatory
+	}
+
+	if creating {
+		switch {
+		case mailing.Name == "":
+			return badRequest("укажите name")
+		case len(mailing.IncludedSegments()) == 0:
+			return badRequest("укажите segments")
+		case mailing.ScheduledAt.IsZero():
+			return badRequest("укажите scheduled_at")
+		}
+	}
+	return nil
+}
+
+// applyTargeting проверяет сегменты так же, как шаг 2 мастера: хотя бы один получатель и только существующие сегменты
+func (s *Server) applyTargeting(ctx context.Context, mailing *models.Mailing, req *mailingRequest) error {
+	include, exclude := mailing.IncludedSegments(), mailing.ExcludeSegments
+	if req.Segments != nil {
+		include = *req.Segments
+	}
+	if req.ExcludeSegments != nil {
+		exclude = *req.ExcludeSegments
+	}
+
+	names := append([]string{}, include...)
+	for _, segment := range exclude {
+		names = append(names, "-"+segment)
+	}
+	include, exclude, err := bot.ParseTargeting(strings.Join(names, ","))
+	if err != nil {
+		return badRequest("%v", err)
+	}
+
+	for _, segment := range append(append([]string{}, include...), exclude...) {
+		err := s.store.CheckSegments(ctx, segment)
+		if errors.Is(err, segmenter.ErrUnknownSegment) {
+			return badRequest("сегмент %s не найден", segment)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	bot.SetTargeting(mailing, include, exclude)
+	return nil
+}
+
+// applySchedule задаёт дату отправки как шаг 3 мастера. Без timezone дата указывается
+// в часовом поясе автора рассылки, при изменении - в прежнем часовом поясе рассылки
+func (s *Server) applySchedule(ctx context.Context, mailing *models.Mailing, req *mailingRequest, creating bool) error {
+	if req.ScheduledAt == nil {
+		if req.Timezone != nil || req.LocalTime != nil {
+			return badRequest("timezone и local_time меняются вместе с scheduled_at")
+		}
+		return nil
+	}
+
+	loc := utils.LoadTimezone(mailing.Timezone)
+	if creating && mailing.AuthorChatID != "" {
+		loc = s.bot.ChatLocation(ctx, mailing.AuthorChatID)
+	}
+	if req.Timezone != nil {
+		timezone, err := utils.ParseTimezone(*req.Timezone)
+		if err != nil {
+			return badRequest("неизвестный часовой пояс %s", *req.Timezone)
+		}
+		loc = utils.LoadTimezone(timezone)
+	}
+
+	text, local := bot.SplitLocalTime(*req.ScheduledAt)
+	if req.LocalTime != nil {
+		local = *req.LocalTime
+	}
+	scheduledAt, err := bot.ParseScheduledAt(text, loc)
+	if err != nil {
+		return badRequest("%v: %s", err, text)
+	}
+	bot.SetMailingSchedule(mailing, scheduledAt, local, loc.String())
+	return nil
+}
+
+// applyRecurrence задаёт повтор и условие окончания повторов как шаг 4 мастера
+func applyRecurrence(mailing *models.Mailing, req *mailingRequest) error {
+	loc := utils.LoadTimezone(mailing.Timezone)
+	start := mailing.ScheduledAt
+	if mailing.OccurrenceAt != nil {
+		start = *mailing.OccurrenceAt
+	}
+
+	if req.Recurrence != nil {
+		text := strings.TrimSpace(*req.Recurrence)
+		if text == "" || bot.IsNoAnswer(text) {
+			mailing.Recurrence = ""
+			mailing.RecurrenceEnd = nil
+			mailing.MaxOccurrences = 0
+		} else {
+			recurrence, err := utils.ParseRecurrence(text, start, loc)
+			if err != nil {
+				return badRequest("не удалось разобрать правило повторения: %s", text)
+			}
+			mailing.Recurrence = recurrence
+		}
+	}
+
+	if req.RecurrenceEnd != nil {
+		mailing.RecurrenceEnd = nil
+		if text := strings.TrimSpace(*req.RecurrenceEnd); text != "" {
+			endAt, err := utils.ParseTimeIn(text, loc)
+			if err != nil {
+				return badRequest("неверный формат даты окончания повторов: %s", text)
+			}
+			if !endAt.After(start) {
+				return badRequest("дата окончания должна быть позже первой отправки")
+			}
+			endAt = endAt.UTC()
+			mailing.RecurrenceEnd = &endAt
+		}
+	}
+	if req.MaxOccurrences != nil {
+		if *req.MaxOccurrences < 0 {
+			return badRequest("количество отправок должно быть больше нуля")
+		}
+		mailing.MaxOccurrences = *req.MaxOccurrences
+	}
+
+	if mailing.Recurrence == "" && (mailing.RecurrenceEnd != nil || mailing.MaxOccurrences > 0) {
+		return badRequest("recurrence_end и max_occurrences задаются только для повторяющихся рассылок")
+	}
+	return nil
+}
+
+// applyFile проверяет вложение так же, как мастер: файл должен быть уже загружен в VK Teams
+func (s *Server) applyFile(mailing *models.Mailing, fileID string) error {
+	mailing.FileID, mailing.FileType = "", ""
+	if fileID == "" {
+		return nil
+	}
+
+	id, fileType, err := s.bot.FileInfo(fileID)
+	if err != nil {
+		return badRequest("файл %s не найден в VK Teams", fileID)
+	}
+	mailing.FileID = id
+	mailing.FileType = fileType
+	return nil
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+func TestCreateMailing(t *testing.T) {
+	handler, store, bot := newTestServer()
+
+	rec := do(handler, http.MethodPost, "/api/mailings", `{"name": " Новости ", "segments": ["clients"], "exclude_segments": ["all"],
+		"scheduled_at": "31.12.2099 10:00", "message": "Здравствуйте, {{.FirstName}}!", "file_id": "file-1",
+		"buttons": [{"text": "Сайт", "url": "https://example.com"}], "author_chat_id": "editor"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	var created mailingJSON
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Name != "Новости" || created.Status != models.MailingScheduled || created.FileType != "image" ||
+		created.Timezone != "Europe/Moscow" || created.ScheduledAt.Format("2006-01-02 15:04") != "2099-12-31 07:00" {
+		t.Errorf("created mailing = %+v", created)
+	}
+
+	// рассылку на защищённый сегмент нужно подтвердить
+	rec = do(handler, http.MethodPost, "/api/mailings", `{"name": "Всем", "segments": ["all"], "scheduled_at": "31.12.2099 10:00", "message": "текст"}`)
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusCreated || created.Status != models.MailingPendingApproval {
+		t.Errorf("protected mailing: status = %d, mailing status = %s, want 201 and pending_approval", rec.Code, created.Status)
+	}
+	if len(bot.approvals) != 1 || bot.approvals[0] != "Всем" {
+		t.Errorf("approval requests = %v, want Всем", bot.approvals)
+	}
+	if _, total, _ := store.ListMailings(context.Background(), MailingFilter{}, 0, 10); total != 2 {
+		t.Errorf("stored %d mailings, want 2", total)
+	}
+}
+
+func TestCreateMailingValidation(t *testing.T) {
+	handler, store, _ := newTestServer()
+	const valid = `"name": "Новости", "segments": ["clients"], "scheduled_at": "31.12.2099 10:00"`
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{` + valid + `, "message": "текст", "priority": 1}`, `неверный JSON: json: unknown field "priority"`},
+		{`{"segments": ["clients"], "scheduled_at": "31.12.2099 10:00", "message": "текст"}`, "укажите name"},
+		{`{"name": " ", "segments": ["clients"], "message": "текст"}`, "название рассылки не может быть пустым"},
+		{`{"name": "Новости", "scheduled_at": "31.12.2099 10:00", "message": "текст"}`, "укажите segments"},
+		{`{"name": "Новости", "segments": ["clients"], "message": "текст"}`, "укажите scheduled_at"},
+		{`{"name": "Новости", "segments": ["workers"], "message": "текст"}`, "сегмент workers не найден"},
+		{`{"name": "Новости", "exclude_segments": ["clients"], "message": "текст"}`, "не указан ни один сегмент получателей"},
+		{`{` + valid + `}`, "сообщение пустое: укажите message или file_id"},
+		{`{` + valid + `, "message": "Привет, {{.FirstName"}`, "ошибка в шаблоне сообщения: template: message:1: unclosed action"},
+		{`{` + valid + `, "file_id": "missing"}`, "файл missing не найден в VK Teams"},
+		{`{` + valid + `, "message": "текст", "buttons": [{"text": "Сайт", "url": "example.com"}]}`, "Неверная ссылка у кнопки «Сайт»"},
+		{`{"name": "Новости", "segments": ["clients"], "scheduled_at": "01.01.2020 10:00", "message": "текст"}`, "дата должна быть в будущем: 01.01.2020 10:00"},
+		{`{"name": "Новости", "segments": ["clients"], "scheduled_at": "когда-нибудь", "message": "текст"}`, "неверный формат даты: когда-нибудь"},
+		{`{` + valid + `, "message": "текст", "timezone": "Марс"}`, "неизвестный часовой пояс Марс"},
+		{`{` + valid + `, "message": "текст", "max_occurrences": 3}`, "recurrence_end и max_occurrences задаются только для повторяющихся рассылок"},
+		{`{` + valid + `, "message": "текст", "recurrence": "иногда"}`, "не удалось разобрать правило повторения: иногда"},
+	}
+	for _, tt := range tests {
+		rec := do(handler, http.MethodPost, "/api/mailings", tt.body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s: status = %d, want 400", tt.body, rec.Code)
+			continue
+		}
+		if message := decodeError(t, rec); message != tt.want {
+			t.Errorf("POST %s: error = %q, want %q", tt.body, message, tt.want)
+		}
+	}
+	if _, total, _ := store.ListMailings(context.Background(), MailingFilter{}, 0, 10); total != 0 {
+		t.Errorf("stored %d mailings, want none", total)
+	}
+}
+
+func TestMailingStatusConflicts(t *testing.T) {
+	handler, store, _ := newTestServer()
+	sent := store.addMailing(&models.Mailing{Name: "sent", Segments: []string{"clients"}, Message: "текст", Status: models.MailingSent})
+	sending := store.addMailing(&models.Mailing{Name: "sending", Segments: []string{"clients"}, Message: "текст", Status: models.MailingSending})
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		want   string
+	}{
+		{http.MethodPatch, "/api/mailings/" + sent.ID.Hex(), `{"name": "другое"}`, "рассылку в состоянии sent изменить нельзя"},
+		{http.MethodPost, "/api/mailings/" + sent.ID.Hex() + "/cancel", "", "рассылку в состоянии sent отменить нельзя"},
+		{http.MethodDelete, "/api/mailings/" + sending.ID.Hex(), "", "рассылка сейчас отправляется, удалить её нельзя"},
+	}
+	for _, tt := range tests {
+		rec := do(handler, tt.method, tt.path, tt.body)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("%s %s: status = %d, want 409", tt.method, tt.path, rec.Code)
+			continue
+		}
+		if message := decodeError(t, rec); message != tt.want {
+			t.Errorf("%s %s: error = %q, want %q", tt.method, tt.path, message, tt.want)
+		}
+	}
+	if mailing := store.mailing(sent.ID); mailing.Name != "sent" || mailing.Status != models.MailingSent {
+		t.Errorf("sent mailing changed: %+v", mailing)
+	}
+
+	if rec := do(handler, http.MethodGet, "/api/mailings/"+sent.ID.Hex()[1:], ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET with invalid id: status = %d, want 400", rec.Code)
+	}
+	if rec := do(handler, http.MethodGet, "/api/mailings/000000000000000000000000", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET unknown mailing: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestCancelMailing(t *testing.T) {
+	handler, store, _ := newTestServer()
+	mailing := store.addMailing(&models.Mailing{Name: "news", Segments: []string{"clients"}, Message: "текст", Status: models.MailingScheduled})
+
+	rec := do(handler, http.MethodPost, "/api/mailings/"+mailing.ID.Hex()+"/cancel", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if stored := store.mailing(mailing.ID); stored.Status != models.MailingCancelled {
+		t.Errorf("status = %s, want cancelled", stored.Status)
+	}
+
+	if rec := do(handler, http.MethodDelete, "/api/mailings/"+mailing.ID.Hex(), ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE cancelled mailing: status = %d, want 204", rec.Code)
+	}
+	if stored := store.mailing(mailing.ID); stored != nil {
+		t.Errorf("mailing %+v not deleted", stored)
+	}
+}
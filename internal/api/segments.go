@@ -0,0 +1,234 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+type segmentJSON struct {
+	Name string `json:"name"`
+	// dynamic - участники выбираются по правилу rule, иначе пользователи вступают в сегмент сами
+	Dynamic   bool      `json:"dynamic"`
+	Rule      string    `json:"rule,omitempty"`
+	Members   int       `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newSegmentJSON(segment *models.Segment, members int) segmentJSON {
+	out := segmentJSON{
+		Name:      segment.Name,
+		Dynamic:   segment.Rule != nil,
+		Members:   members,
+		CreatedAt: segment.CreatedAt,
+		UpdatedAt: segment.UpdatedAt,
+	}
+	if segment.Rule != nil {
+		out.Rule = segmenter.DescribeRule(segment.Rule)
+	}
+	return out
+}
+
+// segmentRequest - сегмент при создании и изменении. Правило записывается так же, как в /define_segment
+type segmentRequest struct {
+	Name string `json:"name"`
+	Rule string `json:"rule"`
+}
+
+// GET /api/segments?dynamic=&limit=&offset=
+func (s *Server) handleListSegments(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	dynamic, err := parseBoolQuery(r, "dynamic")
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	segments, total, err := s.store.ListSegments(ctx, SegmentFilter{Dynamic: dynamic}, offset, limit)
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	counts, err := s.store.CountMembers(ctx, segments)
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	items := make([]segmentJSON, 0, len(segments))
+	for _, segment := range segments {
+		items = append(items, newSegmentJSON(segment, counts[segment.Name]))
+	}
+	s.writeJSON(w, http.StatusOK, page{Items: items, Total: total, Limit: limit, Offset: offset})
+}
+
+// GET /api/segments/{name}
+func (s *Server) handleGetSegment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	segment, err := s.loadSegment(r, r.PathValue("name"))
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	counts, err := s.store.CountMembers(ctx, []*models.Segment{segment})
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newSegmentJSON(segment, counts[segment.Name]))
+}
+
+// POST /api/segments создаёт обычный сегмент или, если задано правило, динамический
+func (s *Server) handleCreateSegment(w http.ResponseWriter, r *http.Request) {
+	var req segmentRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if err := validateSegmentName(name); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	existing, err := s.store.GetSegment(ctx, name)
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	if existing != nil {
+		s.writeFailure(w, r, conflict("сегмент %s уже существует", name))
+		return
+	}
+
+	if strings.TrimSpace(req.Rule) == "" {
+		err = s.store.CreateSegment(ctx, name)
+	} else {
+		err = s.defineSegment(r, name, req.Rule)
+	}
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	segment, err := s.loadSegment(r, name)
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, newSegmentJSON(segment, 0))
+}
+
+// PATCH /api/segments/{name} меняет правило динамического сегмента
+func (s *Server) handleUpdateSegment(w http.ResponseWriter, r *http.Request) {
+	var req segmentRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	if req.Name != "" {
+		s.writeFailure(w, r, badRequest("сегмент нельзя переименовать"))
+		return
+	}
+	if strings.TrimSpace(req.Rule) == "" {
+		s.writeFailure(w, r, badRequest("укажите rule"))
+		return
+	}
+
+	name := r.PathValue("name")
+	if _, err := s.loadSegment(r, name); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	if err := s.defineSegment(r, name, req.Rule); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	s.handleGetSegment(w, r)
+}
+
+// DELETE /api/segments/{name}; участники обычного сегмента из него выходят.
+// Сегмент, на который запланированы рассылки, удалить нельзя: получатели рассылок изменились бы
+func (s *Server) handleDeleteSegment(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "all" {
+		s.writeFailure(w, r, conflict("сегмент all удалить нельзя"))
+		return
+	}
+
+	ctx := r.Context()
+	segment, err := s.loadSegment(r, name)
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	mailings, err := s.store.CountMailingsUsingSegment(ctx, name, models.MailingScheduled, models.MailingPendingApproval)
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	if mailings > 0 {
+		s.writeFailure(w, r, conflict("сегмент %s используется в запланированных рассылках (%d), сначала измените или отмените их", name, mailings))
+		return
+	}
+
+	if err := s.store.DeleteSegment(ctx, segment); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) loadSegment(r *http.Request, name string) (*models.Segment, error) {
+	segment, err := s.store.GetSegment(r.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	if segment == nil {
+		return nil, notFound("сегмент %s не найден", name)
+	}
+	return segment, nil
+}
+
+// defineSegment задаёт правило динамического сегмента, как /define_segment
+func (s *Server) defineSegment(r *http.Request, name, text string) error {
+	rule, err := segmenter.ParseRule(text)
+	if err != nil {
+		return badRequest("не удалось разобрать правило: %v", err)
+	}
+
+	err = s.store.DefineSegment(r.Context(), name, rule)
+	if errors.Is(err, segmenter.ErrStaticSegment) {
+		return conflict("сегмент %s заполняется вручную, правило задать нельзя", name)
+	}
+	if err != nil {
+		return badRequest("ошибка в правиле сегмента: %v", err)
+	}
+	return nil
+}
+
+// validateSegmentName проверяет, что название сегмента можно указать в сегментах рассылки
+func validateSegmentName(name string) error {
+	if name == "" {
+		return badRequest("укажите name")
+	}
+	if strings.HasPrefix(name, "-") || strings.ContainsAny(name, ", \n") {
+		return badRequest("название сегмента не может начинаться с минуса и содержать пробелы и запятые")
+	}
+	return nil
+}
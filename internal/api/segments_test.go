@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+func TestDeleteSegmentInUse(t *testing.T) {
+	handler, store, _ := newTestServer()
+	store.addSegment(&models.Segment{Name: "contractors"})
+	store.addUser(&models.User{ChatID: "user", Segments: []string{"all", "clients", "contractors"}})
+
+	scheduled := store.addMailing(&models.Mailing{Name: "news", Segments: []string{"clients"}, Status: models.MailingScheduled})
+	// исключённый сегмент тоже нельзя удалить: его участники стали бы получателями
+	pending := store.addMailing(&models.Mailing{Name: "notice", Segments: []string{"all"}, ExcludeSegments: []string{"contractors"},
+		Status: models.MailingPendingApproval})
+	// уже отправленные рассылки сегмент не держат
+	store.addMailing(&models.Mailing{Name: "old", Segment: "contractors", Status: models.MailingSent})
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"clients", "сегмент clients используется в запланированных рассылках (1), сначала измените или отмените их"},
+		{"contractors", "сегмент contractors используется в запланированных рассылках (1), сначала измените или отмените их"},
+		{"all", "сегмент all удалить нельзя"},
+	}
+	for _, tt := range tests {
+		rec := do(handler, http.MethodDelete, "/api/segments/"+tt.name, "")
+		if rec.Code != http.StatusConflict {
+			t.Errorf("DELETE %s: status = %d, want 409", tt.name, rec.Code)
+			continue
+		}
+		if message := decodeError(t, rec); message != tt.want {
+			t.Errorf("DELETE %s: error = %q, want %q", tt.name, message, tt.want)
+		}
+	}
+
+	for _, mailing := range []*models.Mailing{scheduled, pending} {
+		if rec := do(handler, http.MethodPost, "/api/mailings/"+mailing.ID.Hex()+"/cancel", ""); rec.Code != http.StatusOK {
+			t.Fatalf("cancel %s: status = %d", mailing.Name, rec.Code)
+		}
+	}
+	for _, name := range []string{"clients", "contractors"} {
+		if rec := do(handler, http.MethodDelete, "/api/segments/"+name, ""); rec.Code != http.StatusNoContent {
+			t.Errorf("DELETE %s after cancel: status = %d, want 204", name, rec.Code)
+		}
+	}
+	if user, _ := store.GetUser(context.Background(), "user"); len(user.Segments) != 1 || user.Segments[0] != "all" {
+		t.Errorf("user segments = %v, want only all", user.Segments)
+	}
+	if rec := do(handler, http.MethodDelete, "/api/segments/clients", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE deleted segment: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestSegmentValidation(t *testing.T) {
+	handler, store, _ := newTestServer()
+	store.addSegment(&models.Segment{Name: "it", Rule: &models.SegmentRule{Field: "attributes.department", Op: models.RuleEq, Value: "IT"}})
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{http.MethodPost, "/api/segments", `{"name": ""}`, http.StatusBadRequest, "укажите name"},
+		{http.MethodPost, "/api/segments", `{"name": "-clients"}`, http.StatusBadRequest,
+			"название сегмента не может начинаться с минуса и содержать пробелы и запятые"},
+		{http.MethodPost, "/api/segments", `{"name": "new clients"}`, http.StatusBadRequest,
+			"название сегмента не может начинаться с минуса и содержать пробелы и запятые"},
+		{http.MethodPost, "/api/segments", `{"name": "clients"}`, http.StatusConflict, "сегмент clients уже существует"},
+		{http.MethodPost, "/api/segments", `{"name": "new", "rule": "сегмент"}`, http.StatusBadRequest, ""},
+		{http.MethodPatch, "/api/segments/clients", `{"rule": "department = IT"}`, http.StatusConflict,
+			"сегмент clients заполняется вручную, правило задать нельзя"},
+		{http.MethodPatch, "/api/segments/it", `{"name": "dev", "rule": "department = dev"}`, http.StatusBadRequest, "сегмент нельзя переименовать"},
+		{http.MethodPatch, "/api/segments/it", `{}`, http.StatusBadRequest, "укажите rule"},
+		{http.MethodPatch, "/api/segments/unknown", `{"rule": "department = IT"}`, http.StatusNotFound, "сегмент unknown не найден"},
+	}
+	for _, tt := range tests {
+		rec := do(handler, tt.method, tt.path, tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s %s: status = %d, want %d", tt.method, tt.path, tt.body, rec.Code, tt.status)
+			continue
+		}
+		if message := decodeError(t, rec); tt.want != "" && message != tt.want {
+			t.Errorf("%s %s %s: error = %q, want %q", tt.method, tt.path, tt.body, message, tt.want)
+		}
+	}
+
+	if rec := do(handler, http.MethodPost, "/api/segments", `{"name": "dev", "rule": "department = dev"}`); rec.Code != http.StatusCreated {
+		t.Errorf("POST dynamic segment: status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	if segment, _ := store.GetSegment(context.Background(), "dev"); segment == nil || segment.Rule == nil || segment.Rule.Value != "dev" {
+		t.Errorf("segment dev = %+v, want dynamic", segment)
+	}
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/logging"
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+const (
+	// размер страницы списков API по умолчанию и максимальный
+	defaultLimit = 50
+	maxLimit     = 200
+	// максимальный размер тела запроса
+	maxBodySize = 1 << 20
+	// более длинный X-Request-ID заменяется своим, чтобы не раздувать журнал
+	maxRequestIDLength = 64
+)
+
+// Bot - действия бота, которые API выполняет так же, как команды
+type Bot interface {
+	// SubmitMailing планирует рассылку или отправляет рассылку на защищённый сегмент на подтверждение
+	SubmitMailing(ctx context.Context, mailing *models.Mailing, now time.Time) error
+	// RequestApproval рассылает подтверждающим рассылку и кнопки для решения
+	RequestApproval(ctx context.Context, mailing *models.Mailing)
+	// ChatLocation возвращает часовой пояс пользователя с этим chat id
+	ChatLocation(ctx context.Context, chatID string) *time.Location
+	// IsAdmin сообщает, что пользователь назначен администратором в конфигурации
+	IsAdmin(chatID string) bool
+	// FileInfo возвращает id и тип файла, уже загруженного в VK Teams
+	FileInfo(fileID string) (id, fileType string, err error)
+	// ClearUserState прерывает многошаговую команду пользователя
+	ClearUserState(ctx context.Context, chatID string)
+}
+
+// Server - HTTP API для внутренних сервисов: рассылки, сегменты и пользователи.
+// Изменения проходят те же проверки, что и в командах бота
+type Server struct {
+	bot    Bot
+	store  Store
+	tokens [][]byte
+	server *http.Server
+	logger *slog.Logger
+}
+
+func NewServer(addr string, tokens []string, bot Bot, db *database.Database, segmenter *segmenter.Segmenter, logger *slog.Logger) *Server {
+	return newServer(addr, tokens, bot, &mongoStore{db: db, segmenter: segmenter}, logger)
+}
+
+func newServer(addr string, tokens []string, bot Bot, store Store, logger *slog.Logger) *Server {
+	s := &Server{bot: bot, store: store, logger: logger}
+	for _, token := range tokens {
+		s.tokens = append(s.tokens, []byte(token))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/mailings", s.handleListMailings)
+	mux.HandleFunc("POST /api/mailings", s.handleCreateMailing)
+	mux.HandleFunc("GET /api/mailings/{id}", s.handleGetMailing)
+	mux.HandleFunc("PATCH /api/mailings/{id}", s.handleUpdateMailing)
+	mux.HandleFunc("DELETE /api/mailings/{id}", s.handleDeleteMailing)
+	mux.HandleFunc("POST /api/mailings/{id}/cancel", s.handleCancelMailing)
+
+	mux.HandleFunc("GET /api/segments", s.handleListSegments)
+	mux.HandleFunc("POST /api/segments", s.handleCreateSegment)
+	mux.HandleFunc("GET /api/segments/{name}", s.handleGetSegment)
+	mux.HandleFunc("PATCH /api/segments/{name}", s.handleUpdateSegment)
+	mux.HandleFunc("DELETE /api/segments/{name}", s.handleDeleteSegment)
+
+	mux.HandleFunc("GET /api/users", s.handleListUsers)
+	mux.HandleFunc("POST /api/users", s.handleCreateUser)
+	mux.HandleFunc("GET /api/users/{chat_id}", s.handleGetUser)
+	mux.HandleFunc("PATCH /api/users/{chat_id}", s.handleUpdateUser)
+	mux.HandleFunc("DELETE /api/users/{chat_id}", s.handleDeleteUser)
+
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.withLogging(s.withToken(mux)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Start принимает запросы, пока сервер не остановят через Shutdown
+func (s *Server) Start() error {
+	s.logger.Info("Starting API server", "addr", s.server.Addr)
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// statusRecorder запоминает код ответа для журнала
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// withLogging пишет запросы в журнал. Correlation id запроса берётся из заголовка X-Request-ID
+// или создаётся и возвращается клиенту в том же заголовке
+func (s *Server) withLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = logging.NewCorrelationID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		ctx := logging.WithCorrelationID(r.Context(), requestID)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		s.logger.InfoContext(ctx, "API request", "method", r.Method, "path", r.URL.Path,
+			"status", recorder.status, "duration", time.Since(start))
+	})
+}
+
+// withToken пропускает запросы с заголовком Authorization: Bearer <токен из API_TOKENS>
+func (s *Server) withToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !s.validToken(token) {
+			s.writeError(w, http.StatusUnauthorized, "неверный токен доступа")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) validToken(token string) bool {
+	valid := false
+	for _, expected := range s.tokens {
+		// сравниваем все токены за постоянное время, чтобы не подсказывать совпавший префикс
+		if subtle.ConstantTimeCompare([]byte(token), expected) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// apiError - ошибка, которую можно показать клиенту API
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusNotFound, message: fmt.Sprintf(format, args...)}
+}
+
+func conflict(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusConflict, message: fmt.Sprintf(format, args...)}
+}
+
+// page - страница списка в ответе API
+type page struct {
+	Items  interface{} `json:"items"`
+	Total  int64       `json:"total"`
+	Limit  int64       `json:"limit"`
+	Offset int64       `json:"offset"`
+}
+
+// parsePage разбирает параметры limit и offset
+func parsePage(r *http.Request) (offset, limit int64, err error) {
+	limit = defaultLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			return 0, 0, badRequest("limit должен быть числом от 1 до %d", maxLimit)
+		}
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || offset < 0 {
+			return 0, 0, badRequest("offset должен быть неотрицательным числом")
+		}
+	}
+	return offset, limit, nil
+}
+
+// parseBoolQuery разбирает необязательный параметр-флаг, nil - параметр не задан
+func parseBoolQuery(r *http.Request, name string) (*bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, badRequest("%s должен быть true или false", name)
+	}
+	return &flag, nil
+}
+
+// decodeJSON читает тело запроса, неизвестные поля считаются ошибкой
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return badRequest("неверный JSON: %v", err)
+	}
+	return nil
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Warn("Failed to write API response", "error", err)
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, message string) {
+	s.writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeFailure отвечает ошибкой: apiError показывается клиенту, остальные ошибки только пишутся в журнал
+func (s *Server) writeFailure(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		s.writeError(w, apiErr.status, apiErr.message)
+		return
+	}
+	s.logger.ErrorContext(r.Context(), "API request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	s.writeError(w, http.StatusInternalServerError, "внутренняя ошибка")
+}
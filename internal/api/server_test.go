@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+const testToken = "secret"
+
+// newTestServer возвращает обработчик API с хранилищем в памяти и сегментами all и clients
+func newTestServer() (http.Handler, *memStore, *fakeBot) {
+	store := &memStore{}
+	store.addSegment(&models.Segment{Name: "all"})
+	store.addSegment(&models.Segment{Name: "clients"})
+	bot := &fakeBot{protected: map[string]bool{"all": true}}
+	s := newServer("", []string{"other", testToken}, bot, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return s.server.Handler, store, bot
+}
+
+// do выполняет запрос с токеном доступа
+func do(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error response: %v", err)
+	}
+	return body.Error
+}
+
+func TestToken(t *testing.T) {
+	handler, _, _ := newTestServer()
+
+	for _, authorization := range []string{"", "Bearer", "Bearer wrong", "Bearer " + testToken + "x", testToken, "Basic " + testToken} {
+		req := httptest.NewRequest(http.MethodGet, "/api/segments", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", authorization, rec.Code)
+			continue
+		}
+		if message := decodeError(t, rec); message != "неверный токен доступа" {
+			t.Errorf("Authorization %q: error = %q", authorization, message)
+		}
+	}
+
+	// подходит любой токен из API_TOKENS
+	for _, token := range []string{testToken, "other"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/segments", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-Request-ID", "request-1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("token %q: status = %d, want 200", token, rec.Code)
+		}
+		if id := rec.Header().Get("X-Request-ID"); id != "request-1" {
+			t.Errorf("X-Request-ID = %q, want request-1", id)
+		}
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	handler, store, _ := newTestServer()
+	for _, name := range []string{"m1", "m2", "m3", "m4", "m5"} {
+		store.addMailing(&models.Mailing{Name: name, Segments: []string{"clients"}, Status: models.MailingScheduled})
+	}
+	store.addMailing(&models.Mailing{Name: "sent", Segments: []string{"clients"}, Status: models.MailingSent})
+
+	tests := []struct {
+		query string
+		names []string
+		total int64
+		limit int64
+	}{
+		{"", []string{"sent", "m5", "m4", "m3", "m2", "m1"}, 6, defaultLimit},
+		{"?limit=2&offset=1", []string{"m5", "m4"}, 6, 2},
+		{"?limit=2&offset=5", []string{"m1"}, 6, 2},
+		{"?offset=10", []string{}, 6, defaultLimit},
+		{"?status=scheduled&limit=3&offset=3", []string{"m2", "m1"}, 5, 3},
+	}
+	for _, tt := range tests {
+		rec := do(handler, http.MethodGet, "/api/mailings"+tt.query, "")
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET /api/mailings%s: status = %d, want 200", tt.query, rec.Code)
+			continue
+		}
+		var got struct {
+			Items  []mailingJSON `json:"items"`
+			Total  int64         `json:"total"`
+			Limit  int64         `json:"limit"`
+			Offset int64         `json:"offset"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, item := range got.Items {
+			names = append(names, item.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.names, ",") || got.Total != tt.total || got.Limit != tt.limit {
+			t.Errorf("GET /api/mailings%s = %v total %d limit %d, want %v total %d limit %d",
+				tt.query, names, got.Total, got.Limit, tt.names, tt.total, tt.limit)
+		}
+	}
+}
+
+func TestListInvalidQuery(t *testing.T) {
+	handler, _, _ := newTestServer()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/mailings?limit=0", "limit должен быть числом от 1 до 200"},
+		{"/api/mailings?limit=201", "limit должен быть числом от 1 до 200"},
+		{"/api/users?limit=ten", "limit должен быть числом от 1 до 200"},
+		{"/api/segments?offset=-1", "offset должен быть неотрицательным числом"},
+		{"/api/mailings?status=done", "неизвестный статус done"},
+		{"/api/segments?dynamic=maybe", "dynamic должен быть true или false"},
+		{"/api/users?role=owner", "неизвестная роль owner, доступные роли: admin, editor, subscriber"},
+	}
+	for _, tt := range tests {
+		rec := do(handler, http.MethodGet, tt.path, "")
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: status = %d, want 400", tt.path, rec.Code)
+			continue
+		}
+		if message := decodeError(t, rec); message != tt.want {
+			t.Errorf("GET %s: error = %q, want %q", tt.path, message, tt.want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+
+	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MailingFilter - условия списка рассылок, пустые условия не проверяются
+type MailingFilter struct {
+	Status models.MailingStatus
+	// рассылка отправляется этому сегменту
+	Segment      string
+	AuthorChatID string
+}
+
+// SegmentFilter - условия списка сегментов, nil - любые сегменты
+type SegmentFilter struct {
+	Dynamic *bool
+}
+
+// UserFilter - условия списка пользователей, пустые условия не проверяются
+type UserFilter struct {
+	Role models.Role
+	// участники сегмента, для динамического сегмента - по его правилу
+	Segment  string
+	OptedOut *bool
+}
+
+// Store - данные, с которыми работает API
+type Store interface {
+	// ListMailings возвращает страницу рассылок, новые первыми, и сколько всего рассылок подходит под filter
+	ListMailings(ctx context.Context, filter MailingFilter, offset, limit int64) ([]*models.Mailing, int64, error)
+	// GetMailing возвращает mongo.ErrNoDocuments, если рассылки нет
+	GetMailing(ctx context.Context, id primitive.ObjectID) (*models.Mailing, error)
+	CreateMailing(ctx context.Context, mailing *models.Mailing) error
+	// UpdateMailingInStatus сохраняет рассылку, только если она всё ещё в состоянии status, иначе возвращает mongo.ErrNoDocuments
+	UpdateMailingInStatus(ctx context.Context, mailing *models.Mailing, status models.MailingStatus) error
+	// DeleteMailingInStatus удаляет рассылку в одном из состояний statuses, иначе возвращает mongo.ErrNoDocuments
+	DeleteMailingInStatus(ctx context.Context, id primitive.ObjectID, statuses ...models.MailingStatus) error
+	// CountMailingsUsingSegment считает рассылки в состояниях statuses, которые отправляются сегменту или исключают его
+	CountMailingsUsingSegment(ctx context.Context, segment string, statuses ...models.MailingStatus) (int64, error)
+
+	ListSegments(ctx context.Context, filter SegmentFilter, offset, limit int64) ([]*models.Segment, int64, error)
+	// GetSegment возвращает nil без ошибки, если сегмента нет
+	GetSegment(ctx context.Context, name string) (*models.Segment, error)
+	CreateSegment(ctx context.Context, name string) error
+	// DefineSegment создаёт динамический сегмент или меняет его правило, как segmenter.Segmenter.DefineSegment
+	DefineSegment(ctx context.Context, name string, rule *models.SegmentRule) error
+	// DeleteSegment удаляет сегмент; участники обычного сегмента из него выходят
+	DeleteSegment(ctx context.Context, segment *models.Segment) error
+	CountMembers(ctx context.Context, segments []*models.Segment) (map[string]int, error)
+	// CheckSegments и CheckMembership проверяют сегменты, как одноимённые методы segmenter.Segmenter
+	CheckSegments(ctx context.Context, segments ...string) error
+	CheckMembership(ctx context.Context, segments ...string) error
+
+	ListUsers(ctx context.Context, filter UserFilter, offset, limit int64) ([]*models.User, int64, error)
+	// GetUser возвращает mongo.ErrNoDocuments, если пользователя нет
+	GetUser(ctx context.Context, chatID string) (*models.User, error)
+	CreateUser(ctx context.Context, user *models.User) error
+	// UpdateUser сохраняет пользователя; атрибуты из attributes с пустым значением удаляются
+	UpdateUser(ctx context.Context, user *models.User, attributes map[string]string) error
+	DeleteUser(ctx context.Context, user *models.User) error
+}
+
+// mongoStore - Store поверх репозиториев MongoDB
+type mongoStore struct {
+	db        *database.Database
+	segmenter *segmenter.Segmenter
+}
+
+func (s *mongoStore) ListMailings(ctx context.Context, filter MailingFilter, offset, limit int64) ([]*models.Mailing, int64, error) {
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.Segment != "" {
+		query["$or"] = bson.A{bson.M{"segments": filter.Segment}, bson.M{"segment": filter.Segment}}
+	}
+	if filter.AuthorChatID != "" {
+		query["author_chat_id"] = filter.AuthorChatID
+	}
+
+	mailingRepo := database.NewMailingRepository(s.db)
+	mailings, err := mailingRepo.List(ctx, query, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := mailingRepo.Count(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	return mailings, total, nil
+}
+
+func (s *mongoStore) GetMailing(ctx context.Context, id primitive.ObjectID) (*models.Mailing, error) {
+	return database.NewMailingRepository(s.db).GetByID(ctx, id)
+}
+
+func (s *mongoStore) CreateMailing(ctx context.Context, mailing *models.Mailing) error {
+	return database.NewMailingRepository(s.db).Create(ctx, mailing)
+}
+
+func (s *mongoStore) UpdateMailingInStatus(ctx context.Context, mailing *models.Mailing, status models.MailingStatus) error {
+	return database.NewMailingRepository(s.db).UpdateInStatus(ctx, mailing, status)
+}
+
+func (s *mongoStore) DeleteMailingInStatus(ctx context.Context, id primitive.ObjectID, statuses ...models.MailingStatus) error {
+	return database.NewMailingRepository(s.db).DeleteInStatus(ctx, id, statuses...)
+}
+
+func (s *mongoStore) CountMailingsUsingSegment(ctx context.Context, segment string, statuses ...models.MailingStatus) (int64, error) {
+	return database.NewMailingRepository(s.db).Count(ctx, bson.M{
+		"status": bson.M{"$in": statuses},
+		"$or": bson.A{
+			bson.M{"segments": segment},
+			bson.M{"segment": segment},
+			bson.M{"exclude_segments": segment},
+		},
+	})
+}
+
+func (s *mongoStore) ListSegments(ctx context.Context, filter SegmentFilter, offset, limit int64) ([]*models.Segment, int64, error) {
+	query := bson.M{}
+	if filter.Dynamic != nil {
+		query["rule"] = bson.M{"$exists": *filter.Dynamic}
+	}
+
+	segmentRepo := database.NewSegmentRepository(s.db)
+	segments, err := segmentRepo.List(ctx, query, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := segmentRepo.Count(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	return segments, total, nil
+}
+
+func (s *mongoStore) GetSegment(ctx context.Context, name string) (*models.Segment, error) {
+	return database.NewSegmentRepository(s.db).GetByName(ctx, name)
+}
+
+func (s *mongoStore) CreateSegment(ctx context.Context, name string) error {
+	return s.segmenter.CreateSegmentIfNotExists(ctx, name)
+}
+
+func (s *mongoStore) DefineSegment(ctx context.Context, name string, rule *models.SegmentRule) error {
+	return s.segmenter.DefineSegment(ctx, name, rule)
+}
+
+func (s *mongoStore) DeleteSegment(ctx context.Context, segment *models.Segment) error {
+	if err := database.NewSegmentRepository(s.db).Delete(ctx, segment.ID); err != nil {
+		return err
+	}
+	if segment.Rule != nil {
+		return nil
+	}
+	return database.NewUserRepository(s.db).PullSegment(ctx, segment.Name)
+}
+
+func (s *mongoStore) CountMembers(ctx context.Context, segments []*models.Segment) (map[string]int, error) {
+	return s.segmenter.CountMembers(ctx, segments)
+}
+
+func (s *mongoStore) CheckSegments(ctx context.Context, segments ...string) error {
+	return s.segmenter.CheckSegments(ctx, segments...)
+}
+
+func (s *mongoStore) CheckMembership(ctx context.Context, segments ...string) error {
+	return s.segmenter.CheckMembership(ctx, segments...)
+}
+
+func (s *mongoStore) ListUsers(ctx context.Context, filter UserFilter, offset, limit int64) ([]*models.User, int64, error) {
+	filters := bson.A{}
+	if filter.Role == models.RoleSubscriber {
+		// пользователи, зарегистрированные до появления ролей, считаются подписчиками
+		filters = append(filters, bson.M{"role": bson.M{"$in": bson.A{filter.Role, "", nil}}})
+	} else if filter.Role != "" {
+		filters = append(filters, bson.M{"role": filter.Role})
+	}
+	if filter.Segment != "" {
+		segmentFilter, err := s.segmenter.SegmentFilter(ctx, filter.Segment)
+		if err != nil {
+			return nil, 0, err
+		}
+		filters = append(filters, segmentFilter)
+	}
+	if filter.OptedOut != nil && *filter.OptedOut {
+		filters = append(filters, bson.M{"opted_out": true})
+	} else if filter.OptedOut != nil {
+		filters = append(filters, bson.M{"opted_out": bson.M{"$ne": true}})
+	}
+
+	query := bson.M{}
+	if len(filters) > 0 {
+		query["$and"] = filters
+	}
+
+	userRepo := database.NewUserRepository(s.db)
+	users, err := userRepo.ListPage(ctx, query, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := userRepo.CountByFilter(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (s *mongoStore) GetUser(ctx context.Context, chatID string) (*models.User, error) {
+	return database.NewUserRepository(s.db).GetByChatID(ctx, chatID)
+}
+
+func (s *mongoStore) CreateUser(ctx context.Context, user *models.User) error {
+	return database.NewUserRepository(s.db).Create(ctx, user)
+}
+
+func (s *mongoStore) UpdateUser(ctx context.Context, user *models.User, attributes map[string]string) error {
+	userRepo := database.NewUserRepository(s.db)
+	if err := userRepo.Update(ctx, user); err != nil {
+		return err
+	}
+	// поля с omitempty не попадают в $set, поэтому удаление атрибутов и часового пояса сохраняется отдельно
+	for key, value := range attributes {
+		if err := userRepo.SetAttribute(ctx, user.ChatID, key, value); err != nil {
+			return err
+		}
+	}
+	if user.Timezone == "" {
+		return userRepo.SetTimezone(ctx, user.ChatID, "")
+	}
+	return nil
+}
+
+func (s *mongoStore) DeleteUser(ctx context.Context, user *models.User) error {
+	return database.NewUserRepository(s.db).Delete(ctx, user.ID)
+}
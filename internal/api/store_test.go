@@ -0,0 +1,340 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/internal/utils"
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memStore - Store в памяти для тестов. Участники динамических сегментов не вычисляются,
+// пользователь входит в сегмент, если он указан в User.Segments
+type memStore struct {
+	mu       sync.Mutex
+	mailings []*models.Mailing
+	segments []*models.Segment
+	users    []*models.User
+}
+
+func (s *memStore) addMailing(mailing *models.Mailing) *models.Mailing {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mailing.ID = primitive.NewObjectID()
+	s.mailings = append(s.mailings, mailing)
+	return mailing
+}
+
+func (s *memStore) addSegment(segment *models.Segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	segment.ID = primitive.NewObjectID()
+	s.segments = append(s.segments, segment)
+}
+
+func (s *memStore) addUser(user *models.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user.ID = primitive.NewObjectID()
+	s.users = append(s.users, user)
+}
+
+// mailing возвращает сохранённую рассылку или nil
+func (s *memStore) mailing(id primitive.ObjectID) *models.Mailing {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, mailing := range s.mailings {
+		if mailing.ID == id {
+			copied := *mailing
+			return &copied
+		}
+	}
+	return nil
+}
+
+// paginate возвращает элементы с offset по offset+limit
+func paginate[T any](items []T, offset, limit int64) []T {
+	if offset >= int64(len(items)) {
+		return nil
+	}
+	return items[offset:min(offset+limit, int64(len(items)))]
+}
+
+func (s *memStore) ListMailings(ctx context.Context, filter MailingFilter, offset, limit int64) ([]*models.Mailing, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var mailings []*models.Mailing
+	// новые рассылки первыми
+	for i := len(s.mailings) - 1; i >= 0; i-- {
+		mailing := s.mailings[i]
+		if filter.Status != "" && mailing.Status != filter.Status ||
+			filter.Segment != "" && !slices.Contains(mailing.IncludedSegments(), filter.Segment) ||
+			filter.AuthorChatID != "" && mailing.AuthorChatID != filter.AuthorChatID {
+			continue
+		}
+		copied := *mailing
+		mailings = append(mailings, &copied)
+	}
+	return paginate(mailings, offset, limit), int64(len(mailings)), nil
+}
+
+func (s *memStore) GetMailing(ctx context.Context, id primitive.ObjectID) (*models.Mailing, error) {
+	if mailing := s.mailing(id); mailing != nil {
+		return mailing, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (s *memStore) CreateMailing(ctx context.Context, mailing *models.Mailing) error {
+	copied := *mailing
+	s.addMailing(&copied)
+	mailing.ID = copied.ID
+	return nil
+}
+
+func (s *memStore) UpdateMailingInStatus(ctx context.Context, mailing *models.Mailing, status models.MailingStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, stored := range s.mailings {
+		if stored.ID == mailing.ID && stored.Status == status {
+			copied := *mailing
+			s.mailings[i] = &copied
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (s *memStore) DeleteMailingInStatus(ctx context.Context, id primitive.ObjectID, statuses ...models.MailingStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, stored := range s.mailings {
+		if stored.ID == id && slices.Contains(statuses, stored.Status) {
+			s.mailings = slices.Delete(s.mailings, i, i+1)
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (s *memStore) CountMailingsUsingSegment(ctx context.Context, segment string, statuses ...models.MailingStatus) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int64
+	for _, mailing := range s.mailings {
+		if slices.Contains(statuses, mailing.Status) &&
+			(slices.Contains(mailing.IncludedSegments(), segment) || slices.Contains(mailing.ExcludeSegments, segment)) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memStore) ListSegments(ctx context.Context, filter SegmentFilter, offset, limit int64) ([]*models.Segment, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var segments []*models.Segment
+	for _, segment := range s.segments {
+		if filter.Dynamic != nil && (segment.Rule != nil) != *filter.Dynamic {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	return paginate(segments, offset, limit), int64(len(segments)), nil
+}
+
+func (s *memStore) GetSegment(ctx context.Context, name string) (*models.Segment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, segment := range s.segments {
+		if segment.Name == name {
+			return segment, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memStore) CreateSegment(ctx context.Context, name string) error {
+	if segment, _ := s.GetSegment(ctx, name); segment == nil {
+		s.addSegment(&models.Segment{Name: name, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	}
+	return nil
+}
+
+func (s *memStore) DefineSegment(ctx context.Context, name string, rule *models.SegmentRule) error {
+	segment, _ := s.GetSegment(ctx, name)
+	if segment == nil {
+		s.addSegment(&models.Segment{Name: name, Rule: rule, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+		return nil
+	}
+	if segment.Rule == nil {
+		return segmenter.ErrStaticSegment
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	segment.Rule = rule
+	return nil
+}
+
+func (s *memStore) DeleteSegment(ctx context.Context, segment *models.Segment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.segments = slices.DeleteFunc(s.segments, func(stored *models.Segment) bool {
+		return stored.Name == segment.Name
+	})
+	if segment.Rule == nil {
+		for _, user := range s.users {
+			user.Segments = slices.DeleteFunc(user.Segments, func(name string) bool { return name == segment.Name })
+		}
+	}
+	return nil
+}
+
+func (s *memStore) CountMembers(ctx context.Context, segments []*models.Segment) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int)
+	for _, user := range s.users {
+		for _, segment := range user.Segments {
+			counts[segment]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *memStore) CheckSegments(ctx context.Context, segments ...string) error {
+	for _, name := range segments {
+		if segment, _ := s.GetSegment(ctx, name); segment == nil && name != "all" {
+			return fmt.Errorf("%w: %s", segmenter.ErrUnknownSegment, name)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) CheckMembership(ctx context.Context, segments ...string) error {
+	if err := s.CheckSegments(ctx, segments...); err != nil {
+		return err
+	}
+	for _, name := range segments {
+		if segment, _ := s.GetSegment(ctx, name); segment != nil && segment.Rule != nil {
+			return fmt.Errorf("%w: %s", segmenter.ErrDynamicSegment, name)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) ListUsers(ctx context.Context, filter UserFilter, offset, limit int64) ([]*models.User, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []*models.User
+	for _, user := range s.users {
+		role := user.Role
+		if role == "" {
+			role = models.RoleSubscriber
+		}
+		if filter.Role != "" && role != filter.Role ||
+			filter.Segment != "" && !slices.Contains(user.Segments, filter.Segment) ||
+			filter.OptedOut != nil && user.OptedOut != *filter.OptedOut {
+			continue
+		}
+		copied := *user
+		users = append(users, &copied)
+	}
+	return paginate(users, offset, limit), int64(len(users)), nil
+}
+
+func (s *memStore) GetUser(ctx context.Context, chatID string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.ChatID == chatID {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (s *memStore) CreateUser(ctx context.Context, user *models.User) error {
+	copied := *user
+	s.addUser(&copied)
+	user.ID = copied.ID
+	return nil
+}
+
+func (s *memStore) UpdateUser(ctx context.Context, user *models.User, attributes map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, stored := range s.users {
+		if stored.ID == user.ID {
+			copied := *user
+			s.users[i] = &copied
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (s *memStore) DeleteUser(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = slices.DeleteFunc(s.users, func(stored *models.User) bool { return stored.ID == user.ID })
+	return nil
+}
+
+// fakeBot повторяет правила бота: рассылки на защищённые сегменты ждут подтверждения
+type fakeBot struct {
+	mu        sync.Mutex
+	protected map[string]bool
+	admins    map[string]bool
+	// рассылки, разосланные на подтверждение, и пользователи, у которых прервана команда
+	approvals []string
+	cleared   []string
+}
+
+func (b *fakeBot) SubmitMailing(ctx context.Context, mailing *models.Mailing, now time.Time) error {
+	target := models.MailingScheduled
+	for _, segment := range mailing.IncludedSegments() {
+		if b.protected[segment] {
+			target = models.MailingPendingApproval
+		}
+	}
+	mailing.Approval = nil
+	if mailing.Status == target {
+		return nil
+	}
+	return mailing.Transition(target, now)
+}
+
+func (b *fakeBot) RequestApproval(ctx context.Context, mailing *models.Mailing) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.approvals = append(b.approvals, mailing.Name)
+}
+
+func (b *fakeBot) ChatLocation(ctx context.Context, chatID string) *time.Location {
+	return utils.LoadTimezone("")
+}
+
+func (b *fakeBot) IsAdmin(chatID string) bool {
+	return b.admins[chatID]
+}
+
+func (b *fakeBot) FileInfo(fileID string) (string, string, error) {
+	if fileID == "missing" {
+		return "", "", fmt.Errorf("file %s not found", fileID)
+	}
+	return fileID, "image", nil
+}
+
+func (b *fakeBot) ClearUserState(ctx context.Context, chatID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cleared = append(b.cleared, chatID)
+}
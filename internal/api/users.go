@@ -0,0 +1,291 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/internal/utils"
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type userJSON struct {
+	ChatID     string            `json:"chat_id"`
+	FirstName  string            `json:"first_name"`
+	LastName   string            `json:"last_name"`
+	Role       models.Role       `json:"role"`
+	Segments   []string          `json:"segments"`
+	Attributes map[string]string `json:"attributes"`
+	OptedOut   bool              `json:"opted_out"`
+	Timezone   string            `json:"timezone"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+func newUserJSON(user *models.User) userJSON {
+	out := userJSON{
+		ChatID:     user.ChatID,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		Role:       user.Role,
+		Segments:   user.Segments,
+		Attributes: user.Attributes,
+		OptedOut:   user.OptedOut,
+		Timezone:   utils.LoadTimezone(user.Timezone).String(),
+		CreatedAt:  user.CreatedAt,
+		UpdatedAt:  user.UpdatedAt,
+	}
+	// пользователи, зарегистрированные до появления ролей, считаются подписчиками
+	if out.Role == "" {
+		out.Role = models.RoleSubscriber
+	}
+	if out.Segments == nil {
+		out.Segments = []string{}
+	}
+	if out.Attributes == nil {
+		out.Attributes = map[string]string{}
+	}
+	return out
+}
+
+// userRequest - поля пользователя при создании и изменении; незаданные поля не меняются.
+// Пустое значение в attributes удаляет поле, пустой timezone возвращает московское время
+type userRequest struct {
+	ChatID     *string           `json:"chat_id"`
+	FirstName  *string           `json:"first_name"`
+	LastName   *string           `json:"last_name"`
+	Role       *models.Role      `json:"role"`
+	Segments   *[]string         `json:"segments"`
+	Attributes map[string]string `json:"attributes"`
+	OptedOut   *bool             `json:"opted_out"`
+	Timezone   *string           `json:"timezone"`
+}
+
+// GET /api/users?role=&segment=&opted_out=&limit=&offset=
+func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	optedOut, err := parseBoolQuery(r, "opted_out")
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := UserFilter{
+		Role:     models.Role(query.Get("role")),
+		Segment:  query.Get("segment"),
+		OptedOut: optedOut,
+	}
+	if filter.Role != "" {
+		if err := validateRole(filter.Role); err != nil {
+			s.writeFailure(w, r, err)
+			return
+		}
+	}
+
+	users, total, err := s.store.ListUsers(r.Context(), filter, offset, limit)
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	items := make([]userJSON, 0, len(users))
+	for _, user := range users {
+		items = append(items, newUserJSON(user))
+	}
+	s.writeJSON(w, http.StatusOK, page{Items: items, Total: total, Limit: limit, Offset: offset})
+}
+
+// GET /api/users/{chat_id}
+func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := s.loadUser(r.Context(), r.PathValue("chat_id"))
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newUserJSON(user))
+}
+
+// POST /api/users регистрирует пользователя так же, как /start
+func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	if req.ChatID == nil || strings.TrimSpace(*req.ChatID) == "" {
+		s.writeFailure(w, r, badRequest("укажите chat_id"))
+		return
+	}
+
+	ctx := r.Context()
+	chatID := strings.TrimSpace(*req.ChatID)
+	if _, err := s.store.GetUser(ctx, chatID); err != mongo.ErrNoDocuments {
+		if err == nil {
+			err = conflict("пользователь %s уже зарегистрирован", chatID)
+		}
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	user := &models.User{
+		ChatID:   chatID,
+		Segments: []string{"all"},
+		Role:     models.RoleSubscriber,
+	}
+	if s.bot.IsAdmin(chatID) {
+		user.Role = models.RoleAdmin
+	}
+	if err := s.applyUserRequest(ctx, user, &req); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	if err := s.store.CreateUser(ctx, user); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, newUserJSON(user))
+}
+
+// PATCH /api/users/{chat_id}
+func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	if req.ChatID != nil {
+		s.writeFailure(w, r, badRequest("chat_id изменить нельзя"))
+		return
+	}
+
+	ctx := r.Context()
+	user, err := s.loadUser(ctx, r.PathValue("chat_id"))
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	if err := s.applyUserRequest(ctx, user, &req); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+
+	if err := s.store.UpdateUser(ctx, user, req.Attributes); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newUserJSON(user))
+}
+
+// DELETE /api/users/{chat_id}
+func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, err := s.loadUser(ctx, r.PathValue("chat_id"))
+	if err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	if err := s.store.DeleteUser(ctx, user); err != nil {
+		s.writeFailure(w, r, err)
+		return
+	}
+	s.bot.ClearUserState(ctx, user.ChatID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) loadUser(ctx context.Context, chatID string) (*models.User, error) {
+	user, err := s.store.GetUser(ctx, chatID)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFound("пользователь %s не найден", chatID)
+	}
+	return user, err
+}
+
+// applyUserRequest переносит поля запроса в пользователя с проверками команд
+// /grant_role, /add_segment, /set_attribute и /timezone
+func (s *Server) applyUserRequest(ctx context.Context, user *models.User, req *userRequest) error {
+	if req.FirstName != nil {
+		user.FirstName = strings.TrimSpace(*req.FirstName)
+	}
+	if req.LastName != nil {
+		user.LastName = strings.TrimSpace(*req.LastName)
+	}
+
+	if req.Role != nil {
+		if err := validateRole(*req.Role); err != nil {
+			return err
+		}
+		user.Role = *req.Role
+	}
+
+	if req.Segments != nil {
+		segments := make([]string, 0, len(*req.Segments))
+		seen := make(map[string]bool)
+		for _, segment := range *req.Segments {
+			segment = strings.TrimSpace(segment)
+			if segment == "" || seen[segment] {
+				continue
+			}
+			seen[segment] = true
+
+			err := s.store.CheckMembership(ctx, segment)
+			switch {
+			case errors.Is(err, segmenter.ErrUnknownSegment):
+				return badRequest("сегмент %s не найден", segment)
+			case errors.Is(err, segmenter.ErrDynamicSegment):
+				return badRequest("сегмент %s формируется автоматически по правилу, добавить в него вручную нельзя", segment)
+			case err != nil:
+				return err
+			}
+			segments = append(segments, segment)
+		}
+		user.Segments = segments
+	}
+
+	for key, value := range req.Attributes {
+		if !segmenter.IsAttributeKey(key) {
+			return badRequest("название поля %s может содержать только латинские буквы, цифры и _", key)
+		}
+		if user.Attributes == nil {
+			user.Attributes = map[string]string{}
+		}
+		if value == "" {
+			delete(user.Attributes, key)
+		} else {
+			user.Attributes[key] = value
+		}
+	}
+
+	if req.OptedOut != nil {
+		user.OptedOut = *req.OptedOut
+	}
+
+	if req.Timezone != nil {
+		user.Timezone = ""
+		if strings.TrimSpace(*req.Timezone) != "" {
+			timezone, err := utils.ParseTimezone(*req.Timezone)
+			if err != nil {
+				return badRequest("неизвестный часовой пояс %s", *req.Timezone)
+			}
+			user.Timezone = timezone
+		}
+	}
+	return nil
+}
+
+func validateRole(role models.Role) error {
+	switch role {
+	case models.RoleAdmin, models.RoleEditor, models.RoleSubscriber:
+		return nil
+	}
+	return badRequest("неизвестная роль %s, доступные роли: admin, editor, subscriber", role)
+}
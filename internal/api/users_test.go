@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+func TestCreateUser(t *testing.T) {
+	handler, store, bot := newTestServer()
+	bot.admins = map[string]bool{"boss": true}
+
+	tests := []struct {
+		body   string
+		status int
+		want   string
+	}{
+		{`{"first_name": "Анна"}`, http.StatusBadRequest, "укажите chat_id"},
+		{`{"chat_id": "anna", "role": "owner"}`, http.StatusBadRequest, "неизвестная роль owner, доступные роли: admin, editor, subscriber"},
+		{`{"chat_id": "anna", "segments": ["workers"]}`, http.StatusBadRequest, "сегмент workers не найден"},
+		{`{"chat_id": "anna", "attributes": {"отдел": "IT"}}`, http.StatusBadRequest,
+			"название поля отдел может содержать только латинские буквы, цифры и _"},
+		{`{"chat_id": "anna", "timezone": "Марс"}`, http.StatusBadRequest, "неизвестный часовой пояс Марс"},
+		{`{"chat_id": "anna", "first_name": "Анна", "segments": ["all", "clients", "clients"], "attributes": {"department": "IT"}}`, http.StatusCreated, ""},
+		{`{"chat_id": "anna"}`, http.StatusConflict, "пользователь anna уже зарегистрирован"},
+		{`{"chat_id": "boss"}`, http.StatusCreated, ""},
+	}
+	for _, tt := range tests {
+		rec := do(handler, http.MethodPost, "/api/users", tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("POST %s: status = %d, want %d: %s", tt.body, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.want != "" {
+			if message := decodeError(t, rec); message != tt.want {
+				t.Errorf("POST %s: error = %q, want %q", tt.body, message, tt.want)
+			}
+		}
+	}
+
+	ctx := context.Background()
+	anna, err := store.GetUser(ctx, "anna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anna.Role != models.RoleSubscriber || !slices.Equal(anna.Segments, []string{"all", "clients"}) || anna.Attributes["department"] != "IT" {
+		t.Errorf("anna = %+v", anna)
+	}
+	// администраторы из конфигурации получают роль при регистрации, как в /start
+	if boss, _ := store.GetUser(ctx, "boss"); boss == nil || boss.Role != models.RoleAdmin {
+		t.Errorf("boss = %+v, want admin", boss)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	handler, store, bot := newTestServer()
+	store.addSegment(&models.Segment{Name: "it", Rule: &models.SegmentRule{Field: "attributes.department", Op: models.RuleEq, Value: "IT"}})
+	store.addUser(&models.User{ChatID: "anna", Segments: []string{"all"}, Attributes: map[string]string{"department": "IT", "city": "Омск"}})
+
+	tests := []struct {
+		body   string
+		status int
+		want   string
+	}{
+		{`{"chat_id": "boris"}`, http.StatusBadRequest, "chat_id изменить нельзя"},
+		{`{"segments": ["it"]}`, http.StatusBadRequest, "сегмент it формируется автоматически по правилу, добавить в него вручную нельзя"},
+		{`{"role": "editor", "attributes": {"city": ""}, "timezone": "Asia/Omsk"}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		rec := do(handler, http.MethodPatch, "/api/users/anna", tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("PATCH %s: status = %d, want %d: %s", tt.body, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.want != "" {
+			if message := decodeError(t, rec); message != tt.want {
+				t.Errorf("PATCH %s: error = %q, want %q", tt.body, message, tt.want)
+			}
+		}
+	}
+
+	anna, err := store.GetUser(context.Background(), "anna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := anna.Attributes["city"]; ok || anna.Role != models.RoleEditor || anna.Timezone != "Asia/Omsk" || anna.Attributes["department"] != "IT" {
+		t.Errorf("anna = %+v", anna)
+	}
+
+	if rec := do(handler, http.MethodDelete, "/api/users/anna", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: status = %d, want 204", rec.Code)
+	}
+	if len(bot.cleared) != 1 || bot.cleared[0] != "anna" {
+		t.Errorf("cleared states = %v, want anna", bot.cleared)
+	}
+	if rec := do(handler, http.MethodGet, "/api/users/anna", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET deleted user: status = %d, want 404", rec.Code)
+	}
+}
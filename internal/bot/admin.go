@@ -0,0 +1,223 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/notifier"
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/models"
+	botgolang "github.com/mail-ru-im/bot-golang"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// сколько недоставленных сообщений показывать в /dead_letters
+const deadLettersPageSize = 20
+
+// /grant_role
+func (h *Handler) handleGrantRole(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	if len(args) < 2 {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Используйте: /grant_role [chat_id] [admin|editor|subscriber]")
+		return
+	}
+
+	role := models.Role(args[1])
+	switch role {
+	case models.RoleAdmin, models.RoleEditor, models.RoleSubscriber:
+	default:
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Неизвестная роль. Доступные роли: admin, editor, subscriber")
+		return
+	}
+
+	h.setRole(ctx, msg, user, args[0], role)
+}
+
+// /revoke_role
+func (h *Handler) handleRevokeRole(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	if len(args) == 0 {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Используйте: /revoke_role [chat_id]")
+		return
+	}
+
+	h.setRole(ctx, msg, user, args[0], models.RoleSubscriber)
+}
+
+func (h *Handler) setRole(ctx context.Context, msg *botgolang.Message, user *models.User, chatID string, role models.Role) {
+	// иначе можно случайно остаться без администратора
+	if chatID == user.ChatID && role != models.RoleAdmin {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Нельзя понизить собственную роль.")
+		return
+	}
+
+	userRepo := database.NewUserRepository(h.db)
+	err := userRepo.SetRole(ctx, chatID, role)
+	if err == mongo.ErrNoDocuments {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("Пользователь %s не найден.", chatID))
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to set role", "target_chat_id", chatID, "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при изменении роли.")
+		return
+	}
+
+	h.logger.InfoContext(ctx, "Role changed", "target_chat_id", chatID, "role", role, "chat_id", user.ChatID)
+	h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Пользователю %s назначена роль %s.", chatID, role))
+}
+
+// /dead_letters
+func (h *Handler) handleDeadLetters(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	deadLetterRepo := database.NewDeadLetterRepository(h.db)
+
+	var (
+		letters []*models.DeadLetter
+		err     error
+	)
+	if len(args) > 0 {
+		mailingID, parseErr := primitive.ObjectIDFromHex(args[0])
+		if parseErr != nil {
+			h.notifier.SendMessage(ctx, msg.Chat.ID, "Неверный id рассылки.")
+			return
+		}
+		letters, err = deadLetterRepo.ListByMailing(ctx, mailingID, deadLettersPageSize)
+	} else {
+		letters, err = deadLetterRepo.List(ctx, deadLettersPageSize)
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to list dead letters", "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при получении недоставленных сообщений.")
+		return
+	}
+
+	if len(letters) == 0 {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Недоставленных сообщений нет.")
+		return
+	}
+
+	total, _ := deadLetterRepo.Count(ctx)
+
+	var response strings.Builder
+	response.WriteString(fmt.Sprintf("📭 Недоставленные сообщения (%d из %d):\n\n", len(letters), total))
+	for _, letter := range letters {
+		response.WriteString(fmt.Sprintf(
+			"%s\n"+
+				"Рассылка: %s\n"+
+				"Получатель: %s\n"+
+				"Попыток: %d\n"+
+				"Ошибка: %s\n\n",
+			letter.ID.Hex(),
+			letter.MailingID.Hex(),
+			letter.ChatID,
+			letter.Attempts,
+			letter.Error,
+		))
+	}
+	response.WriteString("Используйте: /redrive [id|all]")
+
+	h.notifier.SendMessage(ctx, msg.Chat.ID, response.String())
+}
+
+// /redrive
+func (h *Handler) handleRedrive(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	if len(args) == 0 {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Используйте: /redrive [id|all]")
+		return
+	}
+
+	deadLetterRepo := database.NewDeadLetterRepository(h.db)
+
+	var letters []*models.DeadLetter
+	if args[0] == "all" {
+		var err error
+		letters, err = deadLetterRepo.List(ctx, 0)
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Failed to list dead letters", "error", err)
+			h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при получении недоставленных сообщений.")
+			return
+		}
+	} else {
+		id, err := primitive.ObjectIDFromHex(args[0])
+		if err != nil {
+			h.notifier.SendMessage(ctx, msg.Chat.ID, "Неверный id.")
+			return
+		}
+		letter, err := deadLetterRepo.GetByID(ctx, id)
+		if err != nil {
+			h.notifier.SendMessage(ctx, msg.Chat.ID, "Недоставленное сообщение не найдено.")
+			return
+		}
+		letters = append(letters, letter)
+	}
+
+	if len(letters) == 0 {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Недоставленных сообщений нет.")
+		return
+	}
+
+	h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("Повторная отправка %d сообщений...", len(letters)))
+
+	sent, optedOut, cancelled := 0, 0, 0
+	for _, letter := range letters {
+		err := h.notifier.Redrive(ctx, letter)
+		switch {
+		case err == nil:
+			sent++
+		case errors.Is(err, notifier.ErrOptedOut):
+			optedOut++
+		case errors.Is(err, notifier.ErrMailingCancelled):
+			cancelled++
+		}
+	}
+
+	response := fmt.Sprintf("✅ Доставлено: %d\n❌ Не доставлено: %d", sent, len(letters)-sent-optedOut-cancelled)
+	if optedOut > 0 {
+		response += fmt.Sprintf("\n🔕 Отписались, сообщения удалены: %d", optedOut)
+	}
+	if cancelled > 0 {
+		response += fmt.Sprintf("\n🚫 Рассылка отменена или удалена, сообщения удалены: %d", cancelled)
+	}
+	h.notifier.SendMessage(ctx, msg.Chat.ID, response)
+}
+
+// /set_attribute
+func (h *Handler) handleSetAttribute(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	if len(args) < 2 {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			"Используйте: /set_attribute [chat_id] [поле] [значение]\nБез значения поле удаляется.")
+		return
+	}
+
+	chatID, key := args[0], args[1]
+	if !segmenter.IsAttributeKey(key) {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Название поля может содержать только латинские буквы, цифры и _.")
+		return
+	}
+	value := strings.Join(args[2:], " ")
+
+	userRepo := database.NewUserRepository(h.db)
+	err := userRepo.SetAttribute(ctx, chatID, key, value)
+	if err == mongo.ErrNoDocuments {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("Пользователь %s не найден.", chatID))
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to set attribute", "target_chat_id", chatID, "attribute", key, "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при изменении поля.")
+		return
+	}
+
+	if value == "" {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Поле %s пользователя %s удалено.", key, chatID))
+		return
+	}
+	h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Пользователю %s задано %s = %s.", chatID, key, value))
+}
+
+// IsAdmin сообщает, что пользователь назначен администратором в конфигурации (ADMIN_CHAT_IDS)
+func (h *Handler) IsAdmin(chatID string) bool {
+	return h.adminChatIDs[chatID]
+}
@@ -0,0 +1,201 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/notifier"
+	"github.com/g0shi4ek/VK_bot/models"
+	botgolang "github.com/mail-ru-im/bot-golang"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SubmitMailing планирует рассылку, а рассылку на защищённый сегмент отправляет на подтверждение.
+// Прежнее решение по рассылке стирается: изменённую рассылку нужно подтвердить заново
+func (h *Handler) SubmitMailing(ctx context.Context, mailing *models.Mailing, now time.Time) error {
+	protected, err := h.needsApproval(ctx, mailing)
+	if err != nil {
+		return err
+	}
+	target := models.MailingScheduled
+	if protected {
+		target = models.MailingPendingApproval
+	}
+
+	mailing.Approval = nil
+	if mailing.Status == target {
+		return nil
+	}
+	return mailing.Transition(target, now)
+}
+
+// needsApproval сообщает, нужно ли подтверждать рассылку. Правило динамического сегмента может
+// выбрать тех же пользователей, что и защищённый сегмент (например, «сегмент all» или
+// «зарегистрирован после 01.01.2000»), поэтому при включённой защите подтверждаются
+// и все рассылки на динамические сегменты
+func (h *Handler) needsApproval(ctx context.Context, mailing *models.Mailing) (bool, error) {
+	if len(h.protectedSegments) == 0 {
+		return false, nil
+	}
+	included := mailing.IncludedSegments()
+	for _, segment := range included {
+		if h.protectedSegments[segment] {
+			return true, nil
+		}
+	}
+	return h.segmenter.HasDynamic(ctx, included...)
+}
+
+// RequestApproval рассылает подтверждающим рассылку и кнопки для решения
+func (h *Handler) RequestApproval(ctx context.Context, mailing *models.Mailing) {
+	approvers, err := h.approvers(ctx)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to list approvers", "error", err)
+	}
+
+	keyboard := notifier.Keyboard{{
+		{Text: "✅ Подтвердить", CallbackData: "approve:" + mailing.ID.Hex()},
+		{Text: "❌ Отклонить", CallbackData: "reject:" + mailing.ID.Hex()},
+	}}
+
+	notified := 0
+	for _, chatID := range approvers {
+		// подтверждает всегда второй человек, а не автор
+		if chatID == mailing.AuthorChatID {
+			continue
+		}
+
+		h.notifier.SendMessage(ctx, chatID, fmt.Sprintf(
+			"🔏 Рассылка %s от %s ждёт подтверждения.\n\n%s\n\nТак её увидят получатели:",
+			mailing.Name, mailing.AuthorChatID, describeNewMailing(mailing, h.ChatLocation(ctx, chatID))))
+		h.sendPreview(ctx, chatID, mailing)
+		if err := h.notifier.SendKeyboard(ctx, chatID, "Подтвердить отправку?", keyboard); err != nil {
+			h.logger.WarnContext(ctx, "Failed to request approval", "mailing_id", mailing.ID.Hex(), "approver_chat_id", chatID, "error", err)
+			continue
+		}
+		notified++
+	}
+
+	if notified == 0 && mailing.AuthorChatID != "" {
+		h.notifier.SendMessage(ctx, mailing.AuthorChatID,
+			"⚠️ Некому подтвердить рассылку: обратитесь к администратору.")
+	}
+}
+
+// approvers возвращает chat id подтверждающих: из настроек или, если они не заданы, всех администраторов
+func (h *Handler) approvers(ctx context.Context) ([]string, error) {
+	if len(h.approverChatIDs) > 0 {
+		chatIDs := make([]string, 0, len(h.approverChatIDs))
+		for chatID := range h.approverChatIDs {
+			chatIDs = append(chatIDs, chatID)
+		}
+		return chatIDs, nil
+	}
+
+	admins, err := database.NewUserRepository(h.db).ListByRole(ctx, models.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	chatIDs := make([]string, 0, len(admins))
+	for _, admin := range admins {
+		chatIDs = append(chatIDs, admin.ChatID)
+	}
+	return chatIDs, nil
+}
+
+func (h *Handler) isApprover(ctx context.Context, chatID string) bool {
+	if len(h.approverChatIDs) > 0 {
+		return h.approverChatIDs[chatID]
+	}
+	user, err := database.NewUserRepository(h.db).GetByChatID(ctx, chatID)
+	return err == nil && user.Role == models.RoleAdmin
+}
+
+// нажатие кнопки «Подтвердить»
+func (h *Handler) handleApproveButton(ctx context.Context, payload *botgolang.EventPayload, args []string) string {
+	return h.decideApproval(ctx, payload.From.ID, args, true)
+}
+
+// нажатие кнопки «Отклонить»
+func (h *Handler) handleRejectButton(ctx context.Context, payload *botgolang.EventPayload, args []string) string {
+	return h.decideApproval(ctx, payload.From.ID, args, false)
+}
+
+// decideApproval сохраняет решение подтверждающего и сообщает его автору рассылки
+func (h *Handler) decideApproval(ctx context.Context, chatID string, args []string, approved bool) string {
+	if len(args) != 1 {
+		return "Кнопка устарела."
+	}
+	id, err := primitive.ObjectIDFromHex(args[0])
+	if err != nil {
+		return "Кнопка устарела."
+	}
+
+	if !h.isApprover(ctx, chatID) {
+		return "У вас нет прав подтверждать рассылки."
+	}
+
+	mailingRepo := database.NewMailingRepository(h.db)
+	mailing, err := mailingRepo.GetByID(ctx, id)
+	if err != nil {
+		return "Рассылка не найдена."
+	}
+	if mailing.Status != models.MailingPendingApproval {
+		return "Рассылка уже не ждёт подтверждения."
+	}
+	if chatID == mailing.AuthorChatID {
+		return "Нельзя подтвердить собственную рассылку."
+	}
+
+	now := time.Now()
+	if approved && !mailing.ScheduledAt.After(now) {
+		return "Время отправки уже прошло, рассылка не будет отправлена."
+	}
+
+	to := models.MailingDraft
+	if approved {
+		to = models.MailingScheduled
+	}
+	if err := mailing.Transition(to, now); err != nil {
+		return "Рассылка уже не ждёт подтверждения."
+	}
+	mailing.Approval = &models.Approval{
+		ChatID:   chatID,
+		Approved: approved,
+		At:       now.UTC(),
+	}
+
+	err = mailingRepo.UpdateInStatus(ctx, mailing, models.MailingPendingApproval)
+	if err == mongo.ErrNoDocuments {
+		return "Рассылка уже не ждёт подтверждения."
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to save approval", "mailing_id", mailing.ID.Hex(), "error", err)
+		return "Не удалось сохранить решение, попробуйте ещё раз."
+	}
+
+	if !approved {
+		h.notifier.SendMessage(ctx, mailing.AuthorChatID, fmt.Sprintf(
+			"❌ Рассылку %s отклонил %s.\n"+
+				"Исправьте её через /edit_mailing %s, и она снова уйдёт на подтверждение.",
+			mailing.Name, chatID, mailing.ID.Hex()))
+		return "❌ Рассылка отклонена."
+	}
+
+	h.notifier.SendMessage(ctx, mailing.AuthorChatID, fmt.Sprintf(
+		"✅ Рассылку %s подтвердил %s. Она будет отправлена %s.",
+		mailing.Name, chatID, describeSchedule(mailing, h.ChatLocation(ctx, mailing.AuthorChatID))))
+	return "✅ Рассылка подтверждена."
+}
+
+// описание решения по рассылке для /list_mailings
+func describeApproval(approval *models.Approval, loc *time.Location) string {
+	verb := "Подтвердил"
+	if !approval.Approved {
+		verb = "Отклонил"
+	}
+	return fmt.Sprintf("%s: %s (%s)", verb, approval.ChatID, approval.At.In(loc).Format("02.01.2006 15:04"))
+}
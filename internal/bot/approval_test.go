@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSubmitMailing(t *testing.T) {
+	h, _ := newTestHandler()
+	ctx := context.Background()
+	now := time.Now()
+
+	// без защищённых сегментов рассылка сразу планируется
+	mailing := &models.Mailing{Segments: []string{"team"}}
+	if err := h.SubmitMailing(ctx, mailing, now); err != nil {
+		t.Fatal(err)
+	}
+	if mailing.Status != models.MailingScheduled {
+		t.Errorf("status = %s, want scheduled", mailing.Status)
+	}
+
+	// после изменения рассылки на защищённый сегмент прежнее решение стирается
+	h.protectedSegments = toSet([]string{"all"})
+	mailing.Segments = []string{"team", "all"}
+	mailing.Approval = &models.Approval{ChatID: "approver", Approved: true, At: now}
+	if err := h.SubmitMailing(ctx, mailing, now); err != nil {
+		t.Fatal(err)
+	}
+	if mailing.Status != models.MailingPendingApproval || mailing.Approval != nil {
+		t.Errorf("mailing = %+v, want pending approval without decision", mailing)
+	}
+
+	// повторная отправка на подтверждение не добавляет переходов
+	history := len(mailing.StatusHistory)
+	if err := h.SubmitMailing(ctx, mailing, now); err != nil {
+		t.Fatal(err)
+	}
+	if mailing.Status != models.MailingPendingApproval || len(mailing.StatusHistory) != history {
+		t.Errorf("mailing = %+v, want unchanged pending approval", mailing)
+	}
+}
+
+func TestApprovers(t *testing.T) {
+	h, _ := newTestHandler()
+	ctx := context.Background()
+	h.approverChatIDs = toSet([]string{"lead", "security"})
+
+	approvers, err := h.approvers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(approvers)
+	if !slices.Equal(approvers, []string{"lead", "security"}) {
+		t.Errorf("approvers = %v, want lead and security", approvers)
+	}
+	if !h.isApprover(ctx, "lead") || h.isApprover(ctx, "editor") {
+		t.Error("isApprover does not follow configured approvers")
+	}
+}
+
+func TestDecideApprovalRejectsBeforeLoading(t *testing.T) {
+	h, _ := newTestHandler()
+	ctx := context.Background()
+	h.approverChatIDs = toSet([]string{"lead"})
+	id := primitive.NewObjectID().Hex()
+
+	tests := []struct {
+		chatID string
+		args   []string
+		want   string
+	}{
+		{"lead", nil, "Кнопка устарела."},
+		{"lead", []string{"not-an-id"}, "Кнопка устарела."},
+		{"editor", []string{id}, "У вас нет прав подтверждать рассылки."},
+	}
+	for _, tt := range tests {
+		for _, approved := range []bool{true, false} {
+			if got := h.decideApproval(ctx, tt.chatID, tt.args, approved); got != tt.want {
+				t.Errorf("decideApproval(%s, %v, %v) = %q, want %q", tt.chatID, tt.args, approved, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestDescribeApproval(t *testing.T) {
+	at := time.Date(2026, 1, 15, 7, 30, 0, 0, time.UTC)
+	moscow := time.FixedZone("MSK", 3*60*60)
+
+	if got := describeApproval(&models.Approval{ChatID: "lead", Approved: true, At: at}, moscow); got != "Подтвердил: lead (15.01.2026 10:30)" {
+		t.Errorf("describeApproval(approved) = %q", got)
+	}
+	if got := describeApproval(&models.Approval{ChatID: "lead", At: at}, moscow); got != "Отклонил: lead (15.01.2026 10:30)" {
+		t.Errorf("describeApproval(rejected) = %q", got)
+	}
+}
@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/metrics"
+	"github.com/g0shi4ek/VK_bot/models"
+	botgolang "github.com/mail-ru-im/bot-golang"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// максимальное количество кнопок под сообщением рассылки
+const maxMailingButtons = 10
+
+// обработчик нажатия кнопки, возвращает текст ответа пользователю
+type callbackHandler func(ctx context.Context, payload *botgolang.EventPayload, args []string) string
+
+// handleCallback разбирает данные нажатой кнопки вида "действие:аргумент:..." и отвечает на нажатие
+func (h *Handler) handleCallback(ctx context.Context, payload *botgolang.EventPayload) {
+	h.logger.InfoContext(ctx, "Received callback", "chat_id", payload.From.ID, "callback_data", payload.CallbackData)
+
+	parts := strings.Split(payload.CallbackData, ":")
+	response := "Действие недоступно."
+	handler, ok := h.callbackRouter[parts[0]]
+	if ok {
+		metrics.Updates.WithLabelValues("callback", parts[0]).Inc()
+		response = handler(ctx, payload, parts[1:])
+	} else {
+		metrics.Updates.WithLabelValues("callback", "unknown").Inc()
+	}
+
+	answer := payload.CallbackQuery()
+	answer.Text = response
+	start := time.Now()
+	err := answer.Send()
+	metrics.ObserveBotAPI("messages/answerCallbackQuery", start, err)
+	if err != nil {
+		h.logger.WarnContext(ctx, "Failed to answer callback", "query_id", payload.QueryID, "error", err)
+	}
+}
+
+// нажатие кнопки-отклика в рассылке
+func (h *Handler) handleMailingButton(ctx context.Context, payload *botgolang.EventPayload, args []string) string {
+	if len(args) != 2 {
+		return "Кнопка устарела."
+	}
+	mailingID, err := primitive.ObjectIDFromHex(args[0])
+	if err != nil {
+		return "Кнопка устарела."
+	}
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "Кнопка устарела."
+	}
+
+	mailing, err := database.NewMailingRepository(h.db).GetByID(ctx, mailingID)
+	if err != nil || index < 0 || index >= len(mailing.Buttons) {
+		return "Рассылка больше не доступна."
+	}
+
+	click := &models.ButtonClick{
+		MailingID:   mailingID,
+		ButtonIndex: index,
+		ButtonText:  mailing.Buttons[index].Text,
+		ChatID:      payload.From.ID,
+	}
+	if err := database.NewButtonClickRepository(h.db).Save(ctx, click); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to save button click", "mailing_id", mailingID.Hex(), "error", err)
+		return "Не удалось сохранить ответ, попробуйте ещё раз."
+	}
+
+	return fmt.Sprintf("✅ Ваш ответ учтён: %s", click.ButtonText)
+}
+
+// нажатие кнопки-отклика в тестовой отправке
+func (h *Handler) handlePreviewButton(ctx context.Context, payload *botgolang.EventPayload, args []string) string {
+	return "Это тестовая отправка, нажатие не учитывается."
+}
+
+// parseButtons разбирает кнопки рассылки: по одной на строке, "Текст | https://ссылка" или "Текст"
+func parseButtons(text string) ([]models.Button, error) {
+	var buttons []models.Button
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		button := models.Button{Text: line}
+		if i := strings.LastIndex(line, "|"); i >= 0 {
+			button.Text = strings.TrimSpace(line[:i])
+			button.URL = strings.TrimSpace(line[i+1:])
+			if button.URL == "" {
+				return nil, fmt.Errorf("Неверная ссылка у кнопки «%s»", button.Text)
+			}
+		}
+		buttons = append(buttons, button)
+	}
+
+	if len(buttons) == 0 {
+		return nil, errors.New("Не указано ни одной кнопки")
+	}
+	if err := ValidateButtons(buttons); err != nil {
+		return nil, err
+	}
+	return buttons, nil
+}
+
+// ValidateButtons проверяет текст и ссылки кнопок рассылки и их количество
+func ValidateButtons(buttons []models.Button) error {
+	for _, button := range buttons {
+		if button.URL != "" && !strings.HasPrefix(button.URL, "http://") && !strings.HasPrefix(button.URL, "https://") {
+			return fmt.Errorf("Неверная ссылка у кнопки «%s»", button.Text)
+		}
+		if strings.TrimSpace(button.Text) == "" {
+			return errors.New("У кнопки не указан текст")
+		}
+	}
+	if len(buttons) > maxMailingButtons {
+		return fmt.Errorf("Можно добавить не больше %d кнопок", maxMailingButtons)
+	}
+	return nil
+}
+
+// описание кнопок рассылки с количеством пользователей, нажавших кнопки-отклики
+func (h *Handler) describeButtons(ctx context.Context, mailing *models.Mailing) string {
+	counts, err := database.NewButtonClickRepository(h.db).CountByButton(ctx, mailing.ID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to count button clicks", "mailing_id", mailing.ID.Hex(), "error", err)
+	}
+
+	descriptions := make([]string, 0, len(mailing.Buttons))
+	for i, button := range mailing.Buttons {
+		if button.URL != "" {
+			descriptions = append(descriptions, button.Text+" (ссылка)")
+			continue
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s — %d", button.Text, counts[i]))
+	}
+	return strings.Join(descriptions, ", ")
+}
@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/internal/notifier"
+	"github.com/g0shi4ek/VK_bot/models"
+	botgolang "github.com/mail-ru-im/bot-golang"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseButtons(t *testing.T) {
+	buttons, err := parseButtons("Да\n\n  Сайт | https://example.com  \nA|B | http://example.com/?a=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Button{
+		{Text: "Да"},
+		{Text: "Сайт", URL: "https://example.com"},
+		// ссылка отделяется последней чертой
+		{Text: "A|B", URL: "http://example.com/?a=1"},
+	}
+	if !reflect.DeepEqual(buttons, want) {
+		t.Errorf("parseButtons() = %+v, want %+v", buttons, want)
+	}
+
+	tooMany := strings.Repeat("Кнопка\n", maxMailingButtons+1)
+	for _, text := range []string{"", "  \n ", "Сайт |", "Сайт | example.com", "| https://example.com", tooMany} {
+		if buttons, err := parseButtons(text); err == nil {
+			t.Errorf("parseButtons(%q) = %+v, want error", text, buttons)
+		}
+	}
+}
+
+func TestMailingButtonCallbackData(t *testing.T) {
+	mailingID := primitive.NewObjectID()
+	data := notifier.ButtonCallbackData(mailingID, 3)
+	if want := fmt.Sprintf("btn:%s:3", mailingID.Hex()); data != want {
+		t.Errorf("ButtonCallbackData() = %q, want %q", data, want)
+	}
+}
+
+func TestMailingButtonStale(t *testing.T) {
+	h, _ := newTestHandler()
+	payload := &botgolang.EventPayload{}
+
+	// старые и испорченные данные кнопки не должны доходить до базы
+	for _, args := range [][]string{nil, {"abc", "0"}, {primitive.NewObjectID().Hex()}, {primitive.NewObjectID().Hex(), "x"}} {
+		if got := h.handleMailingButton(context.Background(), payload, args); got != "Кнопка устарела." {
+			t.Errorf("handleMailingButton(%v) = %q, want stale button", args, got)
+		}
+	}
+}
+
+func TestPreviewButton(t *testing.T) {
+	h, _ := newTestHandler()
+	if got := h.handlePreviewButton(context.Background(), &botgolang.EventPayload{}, nil); got != "Это тестовая отправка, нажатие не учитывается." {
+		t.Errorf("handlePreviewButton() = %q", got)
+	}
+}
@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/internal/metrics"
+	"github.com/g0shi4ek/VK_bot/models"
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+// attachFile переносит в сообщение файл из частей события: Message() их не сохраняет.
+// Текстом сообщения с файлом считается подпись к файлу
+func attachFile(msg *botgolang.Message, parts []botgolang.Part) {
+	for _, part := range parts {
+		if part.Type != botgolang.FILE {
+			continue
+		}
+		msg.FileID = part.Payload.FileID
+		msg.ContentType = botgolang.OtherFile
+		msg.Text = part.Payload.Caption
+		return
+	}
+}
+
+// attachMailingFile проверяет файл из сообщения и сохраняет его в рассылке.
+// Файл уже загружен в VK Teams, поэтому при отправке переиспользуется его id.
+// Сообщение без файла убирает прежнее вложение рассылки
+func (h *Handler) attachMailingFile(ctx context.Context, msg *botgolang.Message, mailing *models.Mailing) bool {
+	if msg.FileID == "" {
+		mailing.FileID, mailing.FileType = "", ""
+		return true
+	}
+
+	fileID, fileType, err := h.FileInfo(msg.FileID)
+	if err != nil {
+		h.logger.WarnContext(ctx, "Failed to get file info", "file_id", msg.FileID, "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Не удалось получить файл. Попробуйте отправить его ещё раз.")
+		return false
+	}
+
+	mailing.FileID = fileID
+	mailing.FileType = fileType
+	return true
+}
+
+func describeFile(mailing *models.Mailing) string {
+	if mailing.FileType == "image" {
+		return "🖼 изображение"
+	}
+	return "📎 файл"
+}
+
+// FileInfo проверяет, что файл уже загружен в VK Teams, и возвращает его id и тип.
+// Без токена бота (TRANSPORT=memory) проверить файл негде, он принимается как есть
+func (h *Handler) FileInfo(fileID string) (id, fileType string, err error) {
+	if h.bot == nil {
+		return fileID, "", nil
+	}
+
+	start := time.Now()
+	info, err := h.bot.GetFileInfo(fileID)
+	metrics.ObserveBotAPI("files/getInfo", start, err)
+	if err != nil {
+		return "", "", err
+	}
+	return info.ID, info.Type, nil
+}
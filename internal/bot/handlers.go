@@ -2,13 +2,17 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/logging"
+	"github.com/g0shi4ek/VK_bot/internal/metrics"
 	"github.com/g0shi4ek/VK_bot/internal/notifier"
 	"github.com/g0shi4ek/VK_bot/internal/scheduler"
 	"github.com/g0shi4ek/VK_bot/internal/segmenter"
@@ -18,108 +22,197 @@ import (
 )
 
 type Handler struct {
-	bot           *botgolang.Bot
-	db            *database.Database
-	notifier      *notifier.Notifier
-	segmenter     *segmenter.Segmenter
-	scheduler     *scheduler.Scheduler
-	commandRouter map[string]func(*botgolang.Message, []string)
-	userStates    map[string]UserState
-	mu            sync.Mutex
+	bot            *botgolang.Bot
+	db             *database.Database
+	notifier       *notifier.Notifier
+	segmenter      *segmenter.Segmenter
+	scheduler      *scheduler.Scheduler
+	logger         *slog.Logger
+	commandRouter  map[string]func(context.Context, *botgolang.Message, []string)
+	callbackRouter map[string]callbackHandler
+	states         StateStore
+	adminChatIDs   map[string]bool
+	// рассылки на эти сегменты требуют подтверждения второго человека
+	protectedSegments map[string]bool
+	// кто подтверждает рассылки; пусто - все администраторы
+	approverChatIDs map[string]bool
+	// работает ли цикл чтения обновлений и с какого момента (UnixNano) он обрабатывает
+	// текущее обновление, 0 - ждёт следующего
+	consuming   atomic.Bool
+	updateSince atomic.Int64
 }
 
-type UserState struct {
-	Status string
-	Data   map[string]interface{}
-}
+// сколько может обрабатываться одно обновление, прежде чем бот считается зависшим.
+// Шаги многошаговых команд обрабатываются прямо в цикле чтения и задерживают следующие обновления
+const maxUpdateProcessing = time.Minute
 
 func NewHandler(bot *botgolang.Bot, db *database.Database, notifier *notifier.Notifier,
-	segmenter *segmenter.Segmenter, scheduler *scheduler.Scheduler) *Handler {
+	segmenter *segmenter.Segmenter, scheduler *scheduler.Scheduler,
+	adminChatIDs, approverChatIDs, protectedSegments []string, logger *slog.Logger) *Handler {
 	h := &Handler{
-		bot:        bot,
-		db:         db,
-		notifier:   notifier,
-		segmenter:  segmenter,
-		scheduler:  scheduler,
-		userStates: make(map[string]UserState),
+		bot:               bot,
+		db:                db,
+		notifier:          notifier,
+		segmenter:         segmenter,
+		scheduler:         scheduler,
+		logger:            logger,
+		states:            database.NewUserStateRepository(db),
+		adminChatIDs:      toSet(adminChatIDs),
+		approverChatIDs:   toSet(approverChatIDs),
+		protectedSegments: toSet(protectedSegments),
+	}
+
+	h.commandRouter = map[string]func(context.Context, *botgolang.Message, []string){
+		"start":             h.handleStart,
+		"help":              h.withLogging(h.handleHelp),
+		"create_mailing":    h.withLogging(h.withAuth(h.withRole(h.handleCreateMailing, models.RoleEditor))),
+		"list_mailings":     h.withLogging(h.withAuth(h.withRole(h.handleListMailings, models.RoleEditor))),
+		"edit_mailing":      h.withLogging(h.withAuth(h.withRole(h.handleEditMailing, models.RoleEditor))),
+		"cancel_mailing":    h.withLogging(h.withAuth(h.withRole(h.handleCancelMailing, models.RoleEditor))),
+		"delete_mailing":    h.withLogging(h.withAuth(h.withRole(h.handleDeleteMailing, models.RoleEditor))),
+		"test_mailing":      h.withLogging(h.withAuth(h.withRole(h.handleTestMailing, models.RoleEditor))),
+		"add_segment":       h.withLogging(h.withAuth(h.handleAddSegment)),
+		"remove_segment":    h.withLogging(h.withAuth(h.handleRemoveSegment)),
+		"list_segments":     h.withLogging(h.withAuth(h.handleListSegments)),
+		"define_segment":    h.withLogging(h.withAuth(h.withRole(h.handleDefineSegment, models.RoleAdmin))),
+		"cancel":            h.withLogging(h.withAuth(h.handleCancel)),
+		"unsubscribe":       h.withLogging(h.withAuth(h.handleUnsubscribe)),
+		"timezone":          h.withLogging(h.withAuth(h.handleTimezone)),
+		"subscribe":         h.withLogging(h.withAuth(h.handleSubscribe)),
+		"mandatory_mailing": h.withLogging(h.withAuth(h.withRole(h.handleMandatoryMailing, models.RoleAdmin))),
+		"grant_role":        h.withLogging(h.withAuth(h.withRole(h.handleGrantRole, models.RoleAdmin))),
+		"revoke_role":       h.withLogging(h.withAuth(h.withRole(h.handleRevokeRole, models.RoleAdmin))),
+		"set_attribute":     h.withLogging(h.withAuth(h.withRole(h.handleSetAttribute, models.RoleAdmin))),
+		"dead_letters":      h.withLogging(h.withAuth(h.withRole(h.handleDeadLetters, models.RoleAdmin))),
+		"redrive":           h.withLogging(h.withAuth(h.withRole(h.handleRedrive, models.RoleAdmin))),
 	}
 
-	h.commandRouter = map[string]func(*botgolang.Message, []string){
-		"start":          h.handleStart,
-		"help":           h.withLogging(h.handleHelp),
-		"create_mailing": h.withLogging(h.withAuth(h.handleCreateMailing)),
-		"list_mailings":  h.withLogging(h.withAuth(h.handleListMailings)),
-		"add_segment":    h.withLogging(h.withAuth(h.handleAddSegment)),
-		"remove_segment": h.withLogging(h.withAuth(h.handleRemoveSegment)),
-		"list_segments":  h.withLogging(h.withAuth(h.handleListSegments)),
-		"cancel":         h.withLogging(h.withAuth(h.handleCancel)),
+	h.callbackRouter = map[string]callbackHandler{
+		"btn":     h.handleMailingButton,
+		"preview": h.handlePreviewButton,
+		"approve": h.handleApproveButton,
+		"reject":  h.handleRejectButton,
+		// кнопка «Отписаться» под рассылками
+		"unsubscribe": h.handleUnsubscribeButton,
 	}
 
 	return h
 }
 
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
 func (h *Handler) Start() error {
-	log.Println("Starting bot handler...")
+	h.logger.Info("Starting bot handler")
 	updates := h.bot.GetUpdatesChannel(context.Background())
 
-	for update := range updates {
-		if update.Type == botgolang.NEW_MESSAGE {
-			msg := update.Payload.Message()
-			fmt.Println(msg)
+	h.consuming.Store(true)
+	defer h.consuming.Store(false)
 
-			// Обработка состояния пользователя
-			if h.checkUserState(msg) {
-				continue
-			}
+	for update := range updates {
+		h.updateSince.Store(time.Now().UnixNano())
+		h.handleUpdate(update)
+		h.updateSince.Store(0)
+	}
 
-			// Обработка команд
-			if msg.Text != "" && msg.Text[0] == '/' {
-				command, args := utils.ParseCommand(msg.Text)
-				if handler, ok := h.commandRouter[command]; ok {
-					go handler(msg, args)
-					continue
-				}
-			}
+	return nil
+}
 
-			// Обработка обычных сообщений
-			go h.handleMessage(msg)
+// CheckUpdates возвращает ошибку, если обновления из VK Teams больше не читаются:
+// цикл чтения завершился или одно обновление обрабатывается слишком долго
+func (h *Handler) CheckUpdates(ctx context.Context) error {
+	if !h.consuming.Load() {
+		return errors.New("updates channel is not consumed")
+	}
+	if since := h.updateSince.Load(); since != 0 {
+		if busy := time.Since(time.Unix(0, since)); busy > maxUpdateProcessing {
+			return fmt.Errorf("update is being processed for %s", busy.Truncate(time.Second))
 		}
 	}
-
 	return nil
 }
 
+// handleUpdate передаёт обновление обработчику. У каждого обновления свой correlation id,
+// по нему в журнале видны все записи об обработке
+func (h *Handler) handleUpdate(update botgolang.Event) {
+	ctx := logging.WithCorrelationID(context.Background(), logging.NewCorrelationID())
+	h.logger.DebugContext(ctx, "Received update", "type", update.Type, "event_id", update.EventID)
+
+	if update.Type == botgolang.CALLBACK_QUERY {
+		payload := update.Payload
+		go h.handleCallback(ctx, &payload)
+		return
+	}
+
+	if update.Type == botgolang.NEW_MESSAGE {
+		msg := update.Payload.Message()
+		attachFile(msg, update.Payload.Parts)
+
+		// Обработка состояния пользователя, /cancel всегда прерывает текущее действие
+		if strings.TrimSpace(msg.Text) != "/cancel" && h.checkUserState(ctx, msg) {
+			metrics.Updates.WithLabelValues("message", "wizard").Inc()
+			return
+		}
+
+		// Обработка команд
+		if msg.Text != "" && msg.Text[0] == '/' {
+			command, args := utils.ParseCommand(msg.Text)
+			if handler, ok := h.commandRouter[command]; ok {
+				metrics.Updates.WithLabelValues("message", command).Inc()
+				go handler(ctx, msg, args)
+				return
+			}
+		}
+
+		// Обработка обычных сообщений
+		metrics.Updates.WithLabelValues("message", "text").Inc()
+		go h.handleMessage(ctx, msg)
+	}
+}
+
 // команда /start
-func (h *Handler) handleStart(msg *botgolang.Message, args []string) {
+func (h *Handler) handleStart(ctx context.Context, msg *botgolang.Message, args []string) {
 	userRepo := database.NewUserRepository(h.db)
-	user, _ := userRepo.GetByChatID(context.Background(), msg.Chat.ID)
+	user, _ := userRepo.GetByChatID(ctx, msg.Chat.ID)
 	if user != nil {
-		h.notifier.SendMessage(msg.Chat.ID, "Вы уже зарегистрированы! Используйте /help для списка команд.")
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Вы уже зарегистрированы! Используйте /help для списка команд.")
 		return
 	}
 
 	from := msg.Chat
+	role := models.RoleSubscriber
+	if h.adminChatIDs[from.ID] {
+		role = models.RoleAdmin
+	}
 	// Создание нового пользователя
 	newUser := &models.User{
 		ChatID:    from.ID,
 		FirstName: from.FirstName,
 		LastName:  from.LastName,
 		Segments:  []string{"all"},
+		Role:      role,
 	}
 
-	err := userRepo.Create(context.Background(), newUser)
+	err := userRepo.Create(ctx, newUser)
 	if err != nil {
-		h.notifier.SendMessage(msg.Chat.ID, "Ошибка при регистрации. Пожалуйста, попробуйте снова.")
+		h.logger.ErrorContext(ctx, "Failed to register user", "chat_id", from.ID, "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при регистрации. Пожалуйста, попробуйте снова.")
 		return
 	}
+	h.logger.InfoContext(ctx, "User registered", "chat_id", from.ID, "role", role)
 
-	h.notifier.SendMessage(msg.Chat.ID,
+	h.notifier.SendMessage(ctx, msg.Chat.ID,
 		"Добро пожаловать! Вы успешно зарегистрированы.\n\n"+
 			"Используйте /help для списка доступных команд.")
 }
 
 // команда /help
-func (h *Handler) handleHelp(msg *botgolang.Message, args []string) {
+func (h *Handler) handleHelp(ctx context.Context, msg *botgolang.Message, args []string) {
 	helpText := `📋 Доступные команды:
 
 /start - Регистрация в системе
@@ -128,72 +221,108 @@ func (h *Handler) handleHelp(msg *botgolang.Message, args []string) {
 📬 Работа с рассылками:
 /create_mailing - Создать новую рассылку
 /list_mailings - Список всех рассылок
+/edit_mailing [id] - Изменить запланированную рассылку
+/cancel_mailing [id] - Отменить рассылку
+/delete_mailing [id] - Удалить рассылку
+/test_mailing [id] - Отправить рассылку только себе для проверки
 
 🏷️ Работа с сегментами:
 /add_segment - Добавить пользователя в сегмент
 /remove_segment - Удалить пользователя из сегмента
 /list_segments - Список всех сегментов
+/define_segment [название] [правило] - Создать сегмент, который формируется по правилу (администраторы)
+
+🔑 Администрирование:
+/grant_role [chat_id] [admin|editor|subscriber] - Назначить роль
+/revoke_role [chat_id] - Снять роль (вернуть subscriber)
+/set_attribute [chat_id] [поле] [значение] - Задать поле пользователя для шаблонов
+/dead_letters [id_рассылки] - Недоставленные сообщения
+/redrive [id|all] - Повторно отправить недоставленные сообщения
+/mandatory_mailing [id] [да|нет] - Сделать рассылку обязательной: она придёт и отписавшимся
+
+🔕 Подписка:
+/unsubscribe - Отписаться от рассылок (обязательные уведомления всё равно придут)
+/subscribe - Снова получать рассылки
+
+🕰 /timezone [пояс] - Часовой пояс, в котором указываются и показываются даты
 
 ❌ /cancel - Отменить текущее действие`
 
-	h.notifier.SendMessage(msg.Chat.ID, helpText)
+	h.notifier.SendMessage(ctx, msg.Chat.ID, helpText)
 }
 
 // /create_mailing
-func (h *Handler) handleCreateMailing(msg *botgolang.Message, user *models.User, args []string) {
+func (h *Handler) handleCreateMailing(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
 	// Многошаговая команда - сохраняем состояние
 	st := make(map[string]interface{}, 0)
-	h.saveUserState(msg.Chat.ID, "awaiting_mailing_name", st)
+	h.saveUserState(ctx, msg.Chat.ID, "awaiting_mailing_name", st)
 
-	h.notifier.SendMessage(msg.Chat.ID,
+	h.notifier.SendMessage(ctx, msg.Chat.ID,
 		"Создание новой рассылки. Ответьте на несколько вопросов:\n\n"+
 			"1. Введите название рассылки:")
 }
 
 // /list_mailings
-func (h *Handler) handleListMailings(msg *botgolang.Message, user *models.User, args []string) {
+func (h *Handler) handleListMailings(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
 	mailingRepo := database.NewMailingRepository(h.db)
-	mailings, err := mailingRepo.ListAll(context.Background())
+	mailings, err := mailingRepo.ListAll(ctx)
 	if err != nil {
-		h.notifier.SendMessage(msg.Chat.ID, "Ошибка при получении списка рассылок.")
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при получении списка рассылок.")
 		return
 	}
 
 	if len(mailings) == 0 {
-		h.notifier.SendMessage(msg.Chat.ID, "Нет активных рассылок.")
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Нет активных рассылок.")
 		return
 	}
 
+	loc := utils.LoadTimezone(user.Timezone)
 	var response strings.Builder
 	response.WriteString("📫 Список рассылок:\n\n")
 	for _, mailing := range mailings {
-		status := "🟢 Активна"
-		if mailing.IsSent {
-			status = "✅ Отправлена"
+		status := mailingStatusLabels[mailing.Status]
+		if changedAt, ok := mailing.StatusChangedAt(mailing.Status); ok {
+			status += " (" + changedAt.In(loc).Format("02.01.2006 15:04") + ")"
 		}
 		response.WriteString(fmt.Sprintf(
 			"%s\n"+
-				"Сегмент: %s\n"+
-				"Дата: %s\n"+
-				"Статус: %s\n\n",
+				"ID: %s\n"+
+				"Сегменты: %s\n"+
+				"Дата: %s\n",
 			mailing.Name,
-			mailing.Segment,
-			mailing.ScheduledAt.Format("02.01.2006 15:04"),
-			status,
+			mailing.ID.Hex(),
+			describeTargeting(mailing),
+			describeSchedule(mailing, loc),
 		))
+		if mailing.Recurrence != "" {
+			response.WriteString(fmt.Sprintf("Повтор: %s\n", describeRecurrence(mailing, loc)))
+		}
+		if mailing.FileID != "" {
+			response.WriteString(fmt.Sprintf("Вложение: %s\n", describeFile(mailing)))
+		}
+		if len(mailing.Buttons) > 0 {
+			response.WriteString(fmt.Sprintf("Кнопки: %s\n", h.describeButtons(ctx, mailing)))
+		}
+		if mailing.Mandatory {
+			response.WriteString("❗ Обязательная: придёт и отписавшимся\n")
+		}
+		if mailing.Approval != nil {
+			response.WriteString(describeApproval(mailing.Approval, loc) + "\n")
+		}
+		response.WriteString(fmt.Sprintf("Статус: %s\n\n", status))
 	}
 
-	h.notifier.SendMessage(msg.Chat.ID, response.String())
+	h.notifier.SendMessage(ctx, msg.Chat.ID, response.String())
 }
 
 // /add_segment
-func (h *Handler) handleAddSegment(msg *botgolang.Message, user *models.User, args []string) {
+func (h *Handler) handleAddSegment(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
 	if len(args) == 0 {
 		// список доступных сегментов
 		segmentRepo := database.NewSegmentRepository(h.db)
-		segments, err := segmentRepo.ListAll(context.Background())
+		segments, err := segmentRepo.ListAll(ctx)
 		if err != nil {
-			h.notifier.SendMessage(msg.Chat.ID, "Ошибка при получении списка сегментов.")
+			h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при получении списка сегментов.")
 			return
 		}
 
@@ -207,31 +336,36 @@ func (h *Handler) handleAddSegment(msg *botgolang.Message, user *models.User, ar
 		}
 		response.WriteString("Используйте: /add_segment [название_сегмента]")
 
-		h.notifier.SendMessage(msg.Chat.ID, response.String())
+		h.notifier.SendMessage(ctx, msg.Chat.ID, response.String())
 		return
 	}
 
 	segmentName := args[0]
 
 	//создаём сегмент (если не существует)
-	if err := h.segmenter.CreateSegmentIfNotExists(context.Background(), segmentName); err != nil {
-		log.Printf("Ошибка создания сегмента: %v", err)
-		h.notifier.SendMessage(msg.Chat.ID, "Ошибка при обработке сегмента")
+	if err := h.segmenter.CreateSegmentIfNotExists(ctx, segmentName); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to create segment", "segment", segmentName, "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при обработке сегмента")
 		return
 	}
 
-	err := h.segmenter.AddUserToSegment(context.Background(), user.ID, segmentName)
+	err := h.segmenter.AddUserToSegment(ctx, user.ID, segmentName)
+	if errors.Is(err, segmenter.ErrDynamicSegment) {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			fmt.Sprintf("Сегмент %s формируется автоматически по правилу, вступить в него вручную нельзя.", segmentName))
+		return
+	}
 	if err != nil {
-		h.notifier.SendMessage(msg.Chat.ID, "Ошибка при добавлении в сегмент.")
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при добавлении в сегмент.")
 		return
 	}
 
-	h.notifier.SendMessage(msg.Chat.ID,
+	h.notifier.SendMessage(ctx, msg.Chat.ID,
 		fmt.Sprintf("Вы успешно добавлены в сегмент %s!", segmentName))
 }
 
 // /remove_segment
-func (h *Handler) handleRemoveSegment(msg *botgolang.Message, user *models.User, args []string) {
+func (h *Handler) handleRemoveSegment(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
 	if len(args) == 0 {
 		// сегменты пользователя
 		var response strings.Builder
@@ -241,40 +375,47 @@ func (h *Handler) handleRemoveSegment(msg *botgolang.Message, user *models.User,
 		}
 		response.WriteString("\nИспользуйте: /remove_segment [название_сегмента]")
 
-		h.notifier.SendMessage(msg.Chat.ID, response.String())
+		h.notifier.SendMessage(ctx, msg.Chat.ID, response.String())
 		return
 	}
 
 	segmentName := args[0]
-	err := h.segmenter.RemoveUserFromSegment(context.Background(), user.ID, segmentName)
+	err := h.segmenter.RemoveUserFromSegment(ctx, user.ID, segmentName)
+	if errors.Is(err, segmenter.ErrDynamicSegment) {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			fmt.Sprintf("Сегмент %s формируется автоматически по правилу, выйти из него вручную нельзя.", segmentName))
+		return
+	}
 	if err != nil {
-		h.notifier.SendMessage(msg.Chat.ID, "Ошибка при удалении из сегмента.")
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при удалении из сегмента.")
 		return
 	}
 
-	h.notifier.SendMessage(msg.Chat.ID,
+	h.notifier.SendMessage(ctx, msg.Chat.ID,
 		fmt.Sprintf("Вы успешно удалены из сегмента %s!", segmentName))
 }
 
 // /list_segments
-func (h *Handler) handleListSegments(msg *botgolang.Message, user *models.User, args []string) {
+func (h *Handler) handleListSegments(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
 	segmentRepo := database.NewSegmentRepository(h.db)
-	segments, err := segmentRepo.ListAll(context.Background())
+	segments, err := segmentRepo.ListAll(ctx)
 	if err != nil {
-		h.notifier.SendMessage(msg.Chat.ID, "Ошибка при получении списка сегментов.")
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при получении списка сегментов.")
 		return
 	}
 
+	counts, err := h.segmenter.CountMembers(ctx, segments)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to count segment members", "error", err)
+	}
+
 	var response strings.Builder
 	response.WriteString("🏷️ Все сегменты:\n\n")
 	for _, segment := range segments {
 		// Проверяем, состоит ли пользователь в этом сегменте
-		inSegment := false
-		for _, userSegment := range user.Segments {
-			if userSegment == segment.Name {
-				inSegment = true
-				break
-			}
+		inSegment, err := h.segmenter.Contains(ctx, user.ID, segment.Name)
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Failed to check segment", "segment", segment.Name, "error", err)
 		}
 
 		status := "❌ Не входите"
@@ -282,177 +423,498 @@ func (h *Handler) handleListSegments(msg *botgolang.Message, user *models.User,
 			status = "✅ Входите"
 		}
 
-		response.WriteString(fmt.Sprintf(
-			"%s\n%s\n\n",
-			segment.Name,
-			status,
-		))
+		response.WriteString(segment.Name + "\n")
+		if counts != nil {
+			response.WriteString(fmt.Sprintf("Участников: %d\n", counts[segment.Name]))
+		}
+		if segment.Rule != nil {
+			response.WriteString("Правило: " + segmenter.DescribeRule(segment.Rule) + "\n")
+		}
+		response.WriteString(status + "\n\n")
+	}
+
+	h.notifier.SendMessage(ctx, msg.Chat.ID, response.String())
+}
+
+// /define_segment
+func (h *Handler) handleDefineSegment(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	if len(args) < 2 {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			"Используйте: /define_segment [название] [правило]\n\n"+
+				"Условия правила:\n"+
+				"сегмент clients — участники другого сегмента\n"+
+				"зарегистрирован после 01.01.2024 (или до)\n"+
+				"department = IT, department != IT — поле пользователя\n"+
+				"role = editor — роль\n\n"+
+				"Условия объединяются через «и», «или» и «не», например:\n"+
+				"/define_segment it_new сегмент clients и не сегмент workers и department = IT")
+		return
+	}
+
+	segmentName := args[0]
+	rule, err := segmenter.ParseRule(strings.Join(args[1:], " "))
+	if err != nil {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("Не удалось разобрать правило: %v", err))
+		return
+	}
+
+	err = h.segmenter.DefineSegment(ctx, segmentName, rule)
+	if errors.Is(err, segmenter.ErrStaticSegment) {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			fmt.Sprintf("Сегмент %s уже существует и заполняется вручную, выберите другое название.", segmentName))
+		return
+	}
+	if err != nil {
+		h.logger.WarnContext(ctx, "Failed to define segment", "segment", segmentName, "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("Ошибка в правиле сегмента: %v", err))
+		return
 	}
 
-	h.notifier.SendMessage(msg.Chat.ID, response.String())
+	h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf(
+		"✅ Сегмент %s будет формироваться по правилу: %s", segmentName, segmenter.DescribeRule(rule)))
 }
 
 // /cancel
-func (h *Handler) handleCancel(msg *botgolang.Message, user *models.User, args []string) {
-	h.clearUserState(msg.Chat.ID)
-	h.notifier.SendMessage(msg.Chat.ID, "Текущее действие отменено.")
+func (h *Handler) handleCancel(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	h.ClearUserState(ctx, msg.Chat.ID)
+	h.notifier.SendMessage(ctx, msg.Chat.ID, "Текущее действие отменено.")
 }
 
 // обрабатывает обычные сообщения (не команды)
-func (h *Handler) handleMessage(msg *botgolang.Message) {
+func (h *Handler) handleMessage(ctx context.Context, msg *botgolang.Message) {
 	// Проверяем, есть ли у пользователя активное состояние
-	if state, exists := h.getUserState(msg.Chat.ID); exists {
-		switch state.Status {
-		case "awaiting_mailing_name":
-			h.processMailingName(msg, state)
-		case "awaiting_mailing_segment":
-			h.processMailingSegment(msg, state)
-		case "awaiting_mailing_date":
-			h.processMailingDate(msg, state)
-		case "awaiting_mailing_message":
-			h.processMailingMessage(msg, state)
-		default:
-			h.notifier.SendMessage(msg.Chat.ID, "Неизвестное состояние. Используйте /cancel для отмены.")
-		}
+	if state, exists := h.getUserState(ctx, msg.Chat.ID); exists {
+		h.processUserState(ctx, msg, state)
 		return
 	}
 
 	// Обработка обычных сообщений
-	h.notifier.SendMessage(msg.Chat.ID,
+	h.notifier.SendMessage(ctx, msg.Chat.ID,
 		"Я не понимаю ваше сообщение. Используйте /help для списка команд.")
 }
 
 // брабатывает название рассылки (шаг 1)
-func (h *Handler) processMailingName(msg *botgolang.Message, state UserState) {
+func (h *Handler) processMailingName(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
 	// Сохраняем название и переходим к следующему шагу
 	state.Data["name"] = msg.Text
 	state.Status = "awaiting_mailing_segment"
-	h.saveUserState(msg.Chat.ID, state.Status, state.Data)
+	h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
 
-	h.notifier.SendMessage(msg.Chat.ID,
-		"2. Укажите сегмент для рассылки (или 'all' для всех пользователей):")
+	h.notifier.SendMessage(ctx, msg.Chat.ID, "2. "+targetingPrompt)
 }
 
-// обрабатывает сегмент рассылки (шаг 2)
-func (h *Handler) processMailingSegment(msg *botgolang.Message, state UserState) {
-	// Проверяем существование сегмента
-	if msg.Text != "all" {
-		segmentRepo := database.NewSegmentRepository(h.db)
-		_, err := segmentRepo.GetByName(context.Background(), msg.Text)
-		if err != nil {
-			h.notifier.SendMessage(msg.Chat.ID,
-				"Сегмент не найден. Укажите существующий сегмент или 'all'.")
-			return
-		}
+// обрабатывает сегменты рассылки (шаг 2)
+func (h *Handler) processMailingSegment(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	include, exclude, ok := h.checkMailingTargeting(ctx, msg.Chat.ID, msg.Text)
+	if !ok {
+		return
 	}
 
 	state.Data["segment"] = msg.Text
 	state.Status = "awaiting_mailing_date"
-	h.saveUserState(msg.Chat.ID, state.Status, state.Data)
+	h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
 
-	h.notifier.SendMessage(msg.Chat.ID,
-		"3. Укажите дату и время рассылки (например: 31.12.2023 23:59):")
+	h.notifier.SendMessage(ctx, msg.Chat.ID, h.describeAudience(ctx, segmenter.Audience{Include: include, Exclude: exclude})+"\n\n"+
+		"3. "+mailingDatePrompt)
 }
 
+const mailingDatePrompt = "Укажите дату и время рассылки, например: 31.12.2023 23:59, завтра в 10, " +
+	"через 2 часа, пн 9:00, +30m или «сейчас». " +
+	"Добавьте «по местному времени», чтобы каждый получатель получил рассылку в это время по своему часовому поясу:"
+
 // обрабатывает дату рассылки (шаг 3)
-func (h *Handler) processMailingDate(msg *botgolang.Message, state UserState) {
-	// Парсим дату
-	scheduledAt, err := utils.ParseTime(msg.Text)
-	if err != nil {
-		h.notifier.SendMessage(msg.Chat.ID,
-			"Неверный формат даты. Укажите в формате ДД.ММ.ГГГГ ЧЧ:ММ")
+func (h *Handler) processMailingDate(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	text, local := SplitLocalTime(msg.Text)
+	scheduledAt, ok := h.parseMailingDate(ctx, msg.Chat.ID, text)
+	if !ok {
 		return
 	}
 
-	// Проверяем, что дата в будущем
-	if scheduledAt.Before(time.Now()) {
-		h.notifier.SendMessage(msg.Chat.ID,
+	// дата и повтор указаны в часовом поясе автора
+	state.Data["scheduled_at"] = scheduledAt.UTC()
+	state.Data["local_time"] = local
+	state.Data["timezone"] = scheduledAt.Location().String()
+	state.Status = "awaiting_mailing_date_confirm"
+	h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
+
+	// относительную дату вроде «завтра в 10» бот мог понять не так, как имел в виду пользователь
+	h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf(
+		"🗓 Рассылка будет отправлена %s. Верно? Ответьте «да» или «нет»:",
+		describeParsedDate(scheduledAt, local)))
+}
+
+// обрабатывает подтверждение даты рассылки (шаг 3, продолжение)
+func (h *Handler) processMailingDateConfirm(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	switch {
+	case IsNoAnswer(msg.Text):
+		state.Status = "awaiting_mailing_date"
+		h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
+
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "3. "+mailingDatePrompt)
+	case isYesAnswer(msg.Text):
+		state.Status = "awaiting_mailing_recurrence"
+		h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
+
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			"4. Повторять рассылку? Ответьте 'нет', 'каждый день', 'каждую неделю', 'каждый месяц' "+
+				"(можно с временем: 'каждый день в 10:00') или укажите cron-выражение (например: 0 10 * * 1):")
+	default:
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ответьте «да», если дата верна, или «нет», чтобы указать её заново.")
+	}
+}
+
+// проверяет существование сегмента, при ошибке сообщает пользователю
+func (h *Handler) checkMailingSegment(ctx context.Context, chatID, segment string) bool {
+	err := h.segmenter.CheckSegments(ctx, segment)
+	if errors.Is(err, segmenter.ErrUnknownSegment) {
+		h.notifier.SendMessage(ctx, chatID, fmt.Sprintf(
+			"Сегмент %s не найден. Укажите существующие сегменты (список в /list_segments) или 'all'.", segment))
+		return false
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to get segment", "segment", segment, "error", err)
+		h.notifier.SendMessage(ctx, chatID, "Ошибка при проверке сегмента, попробуйте ещё раз.")
+		return false
+	}
+	return true
+}
+
+var (
+	errMailingDateFormat = errors.New("неверный формат даты")
+	errMailingDatePast   = errors.New("дата должна быть в будущем")
+)
+
+// ParseScheduledAt разбирает дату отправки в часовом поясе loc, дата должна быть в будущем.
+// «Сейчас» допускается - рассылка уйдёт при ближайшей проверке
+func ParseScheduledAt(text string, loc *time.Location) (time.Time, error) {
+	scheduledAt, err := utils.ParseTimeIn(text, loc)
+	if err != nil {
+		return time.Time{}, errMailingDateFormat
+	}
+	if scheduledAt.Before(time.Now().Truncate(time.Minute)) {
+		return time.Time{}, errMailingDatePast
+	}
+	return scheduledAt, nil
+}
+
+// разбирает дату отправки по часовому поясу пользователя, при ошибке сообщает пользователю
+func (h *Handler) parseMailingDate(ctx context.Context, chatID, text string) (time.Time, bool) {
+	scheduledAt, err := ParseScheduledAt(text, h.ChatLocation(ctx, chatID))
+	switch err {
+	case nil:
+		return scheduledAt, true
+	case errMailingDatePast:
+		h.notifier.SendMessage(ctx, chatID,
 			"Дата должна быть в будущем. Укажите корректную дату.")
+	default:
+		h.notifier.SendMessage(ctx, chatID,
+			"Неверный формат даты. Укажите дату в формате ДД.ММ.ГГГГ ЧЧ:ММ "+
+				"или относительно: завтра в 10, через 2 часа, пн 9:00, +30m, сейчас")
+	}
+	return time.Time{}, false
+}
+
+const mailingMessagePrompt = "5. Введите текст сообщения для рассылки или отправьте файл/изображение с подписью.\n" +
+	"Текст можно персонализировать: {{.FirstName}}, {{.LastName}}, {{.Segment}}, {{.Attr \"поле\"}}"
+
+// обрабатывает правило повторения (шаг 4)
+func (h *Handler) processMailingRecurrence(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	if IsNoAnswer(msg.Text) {
+		state.Status = "awaiting_mailing_message"
+		h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
+
+		h.notifier.SendMessage(ctx, msg.Chat.ID, mailingMessagePrompt)
 		return
 	}
 
-	state.Data["scheduled_at"] = scheduledAt.UTC()
-	log.Println("scheduled_at", state.Data["scheduled_at"])
+	scheduledAt := state.Data["scheduled_at"].(time.Time)
+	recurrence, err := utils.ParseRecurrence(msg.Text, scheduledAt, h.ChatLocation(ctx, msg.Chat.ID))
+	if err != nil {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			"Не удалось разобрать правило повторения. Попробуйте ещё раз или ответьте 'нет'.")
+		return
+	}
+
+	state.Data["recurrence"] = recurrence
+	state.Status = "awaiting_mailing_recurrence_end"
+	h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
+
+	h.notifier.SendMessage(ctx, msg.Chat.ID,
+		"Когда прекратить повторы? Укажите дату окончания (ДД.ММ.ГГГГ ЧЧ:ММ или, например, через 2 недели), "+
+			"количество отправок (например: 10) или 'нет', чтобы повторять бессрочно:")
+}
+
+// обрабатывает условие окончания повторов (шаг 4, продолжение)
+func (h *Handler) processMailingRecurrenceEnd(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	text := strings.TrimSpace(msg.Text)
+	if count, err := strconv.Atoi(text); err == nil {
+		if count <= 0 {
+			h.notifier.SendMessage(ctx, msg.Chat.ID, "Количество отправок должно быть больше нуля.")
+			return
+		}
+		state.Data["max_occurrences"] = count
+	} else if !IsNoAnswer(text) {
+		endAt, err := utils.ParseTimeIn(text, h.ChatLocation(ctx, msg.Chat.ID))
+		if err != nil {
+			h.notifier.SendMessage(ctx, msg.Chat.ID,
+				"Неверный формат. Укажите дату ДД.ММ.ГГГГ ЧЧ:ММ, число отправок или 'нет'.")
+			return
+		}
+		if !endAt.After(state.Data["scheduled_at"].(time.Time)) {
+			h.notifier.SendMessage(ctx, msg.Chat.ID,
+				"Дата окончания должна быть позже первой отправки.")
+			return
+		}
+		state.Data["recurrence_end"] = endAt.UTC()
+	}
+
 	state.Status = "awaiting_mailing_message"
-	h.saveUserState(msg.Chat.ID, state.Status, state.Data)
+	h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
 
-	h.notifier.SendMessage(msg.Chat.ID,
-		"4. Введите текст сообщения для рассылки:")
+	h.notifier.SendMessage(ctx, msg.Chat.ID, mailingMessagePrompt)
 }
 
-// обрабатывает текст рассылки (шаг 4)
-func (h *Handler) processMailingMessage(msg *botgolang.Message, state UserState) {
-	// Создаем рассылку
-	mailing := &models.Mailing{
-		Name:        state.Data["name"].(string),
-		Segment:     state.Data["segment"].(string),
-		Message:     msg.Text,
-		ScheduledAt: state.Data["scheduled_at"].(time.Time),
-		IsSent:      false,
+// обрабатывает текст рассылки (шаг 5)
+func (h *Handler) processMailingMessage(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	if msg.Text == "" && msg.FileID == "" {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			"Сообщение пустое. Введите текст или отправьте файл/изображение с подписью:")
+		return
+	}
+
+	if !h.checkMailingTemplate(ctx, msg.Chat.ID, msg.Text) {
+		return
+	}
+
+	content := &models.Mailing{Message: msg.Text}
+	if !h.attachMailingFile(ctx, msg, content) {
+		return
+	}
+
+	state.Data["message"] = content.Message
+	state.Data["file_id"] = content.FileID
+	state.Data["file_type"] = content.FileType
+	state.Status = "awaiting_mailing_buttons"
+	h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
+
+	h.notifier.SendMessage(ctx, msg.Chat.ID,
+		"6. Добавить кнопки под сообщением? Укажите каждую кнопку с новой строки:\n"+
+			"Текст | https://ссылка — кнопка-ссылка\n"+
+			"Текст — кнопка-отклик, нажатия которой будут учтены\n\n"+
+			"Или ответьте 'нет':")
+}
+
+// обрабатывает кнопки рассылки (шаг 6) и показывает предпросмотр
+func (h *Handler) processMailingButtons(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	buttons := ""
+	if !IsNoAnswer(msg.Text) {
+		if _, err := parseButtons(msg.Text); err != nil {
+			h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("%v. Попробуйте ещё раз или ответьте 'нет'.", err))
+			return
+		}
+		buttons = msg.Text
+	}
+
+	state.Data["buttons"] = buttons
+	state.Status = "awaiting_mailing_confirm"
+	h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
+
+	mailing := mailingFromState(state)
+	h.notifier.SendMessage(ctx, msg.Chat.ID, "7. Так рассылку увидят получатели:")
+	h.sendPreview(ctx, msg.Chat.ID, mailing)
+	h.notifier.SendMessage(ctx, msg.Chat.ID, describeNewMailing(mailing, h.ChatLocation(ctx, msg.Chat.ID))+
+		"\n\nВсё верно? Ответьте 'да', чтобы запланировать рассылку, "+
+		"'нет', чтобы изменить сообщение, или /cancel для отмены.")
+}
+
+// обрабатывает подтверждение рассылки (шаг 7) и создаёт рассылку
+func (h *Handler) processMailingConfirm(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	if IsNoAnswer(msg.Text) {
+		state.Status = "awaiting_mailing_message"
+		h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, mailingMessagePrompt)
+		return
+	}
+	if !isYesAnswer(msg.Text) {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ответьте 'да' или 'нет':")
+		return
 	}
 
-	log.Println("created with", mailing.ScheduledAt)
+	// Создаем рассылку
+	mailing := mailingFromState(state)
+	mailing.AuthorChatID = msg.Chat.ID
+
+	if err := h.SubmitMailing(ctx, mailing, time.Now()); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to submit mailing", "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при создании рассылки.")
+		return
+	}
 
 	mailingRepo := database.NewMailingRepository(h.db)
-	err := mailingRepo.Create(context.Background(), mailing)
+	err := mailingRepo.Create(ctx, mailing)
 	if err != nil {
-		h.notifier.SendMessage(msg.Chat.ID, "Ошибка при создании рассылки.")
+		h.logger.ErrorContext(ctx, "Failed to create mailing", "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при создании рассылки.")
 		return
 	}
+	h.logger.InfoContext(ctx, "Mailing created", "mailing_id", mailing.ID.Hex(),
+		"author_chat_id", mailing.AuthorChatID, "status", mailing.Status, "scheduled_at", mailing.ScheduledAt)
 
 	// Очищаем состояние
-	h.clearUserState(msg.Chat.ID)
-
-	mskLoc, _ := time.LoadLocation("Europe/Moscow")
-	mskTime := mailing.ScheduledAt.In(mskLoc)
-	h.notifier.SendMessage(msg.Chat.ID,
-		fmt.Sprintf("✅ Рассылка %s успешно создана!\n\n"+
-			"Сегмент: %s\n"+
-			"Дата отправки: %s",
-			mailing.Name,
-			mailing.Segment,
-			mskTime.Format("02.01.2006 15:04")))
-}
+	h.ClearUserState(ctx, msg.Chat.ID)
 
-// методы для работы с состояниями пользователей
+	response := fmt.Sprintf("✅ Рассылка %s успешно создана!\n\n%s\n\n"+
+		"Проверить её можно командой /test_mailing %s",
+		mailing.Name, describeNewMailing(mailing, h.ChatLocation(ctx, msg.Chat.ID)), mailing.ID.Hex())
+	if mailing.Status == models.MailingPendingApproval {
+		response += "\n\n🔏 Сегмент защищён: рассылка будет отправлена только после подтверждения."
+	}
+	h.notifier.SendMessage(ctx, msg.Chat.ID, response)
 
-func (h *Handler) saveUserState(chatID string, status string, data map[string]interface{}) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.userStates[chatID] = UserState{
-		Status: status,
-		Data:   data,
+	if mailing.Status == models.MailingPendingApproval {
+		h.RequestApproval(ctx, mailing)
 	}
 }
 
-func (h *Handler) getUserState(chatID string) (UserState, bool) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	state, ok := h.userStates[chatID]
-	return state, ok
+// краткое описание рассылки для подтверждения
+func describeNewMailing(mailing *models.Mailing, loc *time.Location) string {
+	description := fmt.Sprintf("Сегменты: %s\n"+
+		"Дата отправки: %s",
+		describeTargeting(mailing),
+		describeSchedule(mailing, loc))
+	if mailing.Recurrence != "" {
+		description += "\nПовтор: " + describeRecurrence(mailing, loc)
+	}
+	if mailing.FileID != "" {
+		description += "\nВложение: " + describeFile(mailing)
+	}
+	if len(mailing.Buttons) > 0 {
+		description += fmt.Sprintf("\nКнопок: %d", len(mailing.Buttons))
+	}
+	return description
 }
 
-func (h *Handler) clearUserState(chatID string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	delete(h.userStates, chatID)
+// проверяет шаблон текста рассылки, при ошибке сообщает пользователю
+func (h *Handler) checkMailingTemplate(ctx context.Context, chatID, text string) bool {
+	if err := notifier.ValidateTemplate(text); err != nil {
+		h.notifier.SendMessage(ctx, chatID, fmt.Sprintf(
+			"Ошибка в шаблоне сообщения: %v\n\n"+
+				"Доступные подстановки: {{.FirstName}}, {{.LastName}}, {{.Segment}}, {{.Attr \"поле\"}}. "+
+				"Исправьте текст и отправьте его снова:", err))
+		return false
+	}
+	return true
 }
 
-func (h *Handler) checkUserState(msg *botgolang.Message) bool {
-	if state, exists := h.getUserState(msg.Chat.ID); exists {
-		switch state.Status {
-		case "awaiting_mailing_name":
-			h.processMailingName(msg, state)
-		case "awaiting_mailing_segment":
-			h.processMailingSegment(msg, state)
-		case "awaiting_mailing_date":
-			h.processMailingDate(msg, state)
-		case "awaiting_mailing_message":
-			h.processMailingMessage(msg, state)
-		default:
-			h.notifier.SendMessage(msg.Chat.ID, "Неизвестное состояние. Используйте /cancel для отмены.")
+// собирает рассылку из данных мастера /create_mailing
+func mailingFromState(state *models.UserState) *models.Mailing {
+	mailing := &models.Mailing{
+		Name:    state.Data["name"].(string),
+		Message: state.Data["message"].(string),
+		Status:  models.MailingDraft,
+	}
+	local, _ := state.Data["local_time"].(bool)
+	timezone, _ := state.Data["timezone"].(string)
+	SetMailingSchedule(mailing, state.Data["scheduled_at"].(time.Time), local, timezone)
+	// сегменты уже проверены на шаге 2
+	include, exclude, _ := ParseTargeting(state.Data["segment"].(string))
+	SetTargeting(mailing, include, exclude)
+	mailing.FileID, _ = state.Data["file_id"].(string)
+	mailing.FileType, _ = state.Data["file_type"].(string)
+	if buttons, ok := state.Data["buttons"].(string); ok && buttons != "" {
+		// кнопки уже проверены на шаге 6
+		mailing.Buttons, _ = parseButtons(buttons)
+	}
+	if recurrence, ok := state.Data["recurrence"].(string); ok {
+		mailing.Recurrence = recurrence
+		mailing.MaxOccurrences = dataInt(state.Data, "max_occurrences")
+		if endAt, ok := state.Data["recurrence_end"].(time.Time); ok {
+			mailing.RecurrenceEnd = &endAt
 		}
+	}
+	return mailing
+}
+
+func (h *Handler) checkUserState(ctx context.Context, msg *botgolang.Message) bool {
+	if state, exists := h.getUserState(ctx, msg.Chat.ID); exists {
+		h.processUserState(ctx, msg, state)
+		return true
+	}
+	return false
+}
+
+// передаёт сообщение обработчику текущего шага многошаговой команды
+func (h *Handler) processUserState(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	switch state.Status {
+	case "awaiting_mailing_name":
+		h.processMailingName(ctx, msg, state)
+	case "awaiting_mailing_segment":
+		h.processMailingSegment(ctx, msg, state)
+	case "awaiting_mailing_date":
+		h.processMailingDate(ctx, msg, state)
+	case "awaiting_mailing_date_confirm":
+		h.processMailingDateConfirm(ctx, msg, state)
+	case "awaiting_mailing_recurrence":
+		h.processMailingRecurrence(ctx, msg, state)
+	case "awaiting_mailing_recurrence_end":
+		h.processMailingRecurrenceEnd(ctx, msg, state)
+	case "awaiting_mailing_message":
+		h.processMailingMessage(ctx, msg, state)
+	case "awaiting_mailing_buttons":
+		h.processMailingButtons(ctx, msg, state)
+	case "awaiting_mailing_confirm":
+		h.processMailingConfirm(ctx, msg, state)
+	case "awaiting_edit_field":
+		h.processEditField(ctx, msg, state)
+	case "awaiting_edit_value":
+		h.processEditValue(ctx, msg, state)
+	case "awaiting_edit_date_confirm":
+		h.processEditDateConfirm(ctx, msg, state)
+	default:
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Неизвестное состояние. Используйте /cancel для отмены.")
+	}
+}
+
+var mailingStatusLabels = map[models.MailingStatus]string{
+	models.MailingDraft:           "📝 Черновик",
+	models.MailingPendingApproval: "⏳ Ждёт подтверждения",
+	models.MailingScheduled:       "🟢 Запланирована",
+	models.MailingSending:         "📤 Отправляется",
+	models.MailingSent:            "✅ Отправлена",
+	models.MailingPartiallyFailed: "⚠️ Отправлена частично",
+	models.MailingFailed:          "❌ Не отправлена",
+	models.MailingCancelled:       "🚫 Отменена",
+}
+
+func IsNoAnswer(text string) bool {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "нет", "no", "-":
 		return true
 	}
 	return false
 }
+
+func isYesAnswer(text string) bool {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "да", "yes", "+":
+		return true
+	}
+	return false
+}
+
+// описание правила повторения для вывода пользователю
+func describeRecurrence(mailing *models.Mailing, loc *time.Location) string {
+	spec := mailing.Recurrence
+	if i := strings.Index(spec, " "); i > 0 && strings.HasPrefix(spec, "CRON_TZ=") {
+		spec = spec[i+1:]
+	}
+
+	description := spec
+	if mailing.MaxOccurrences > 0 {
+		description += fmt.Sprintf(" (отправлено %d из %d)", mailing.Occurrences, mailing.MaxOccurrences)
+	}
+	if mailing.RecurrenceEnd != nil {
+		description += " до " + mailing.RecurrenceEnd.In(loc).Format("02.01.2006 15:04")
+	}
+	return description
+}
@@ -0,0 +1,393 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/internal/utils"
+	"github.com/g0shi4ek/VK_bot/models"
+	botgolang "github.com/mail-ru-im/bot-golang"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// состояния, в которых рассылку ещё можно менять
+var editableStatuses = []models.MailingStatus{models.MailingDraft, models.MailingPendingApproval, models.MailingScheduled}
+
+// поля рассылки, доступные для изменения в /edit_mailing
+var editFields = map[string]string{
+	"1":        "name",
+	"название": "name",
+	"2":        "segment",
+	"сегмент":  "segment",
+	"сегменты": "segment",
+	"3":        "scheduled_at",
+	"дата":     "scheduled_at",
+	"4":        "message",
+	"текст":    "message",
+}
+
+// /edit_mailing
+func (h *Handler) handleEditMailing(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	mailing, ok := h.loadMailing(ctx, msg.Chat.ID, args, "/edit_mailing [id]")
+	if !ok {
+		return
+	}
+	if !h.checkMailingOwner(ctx, msg.Chat.ID, user, mailing) {
+		return
+	}
+	if !IsEditable(mailing) {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			fmt.Sprintf("Рассылку в состоянии «%s» изменить нельзя.", mailingStatusLabels[mailing.Status]))
+		return
+	}
+
+	st := map[string]interface{}{"mailing_id": mailing.ID.Hex()}
+	h.saveUserState(ctx, msg.Chat.ID, "awaiting_edit_field", st)
+
+	loc := utils.LoadTimezone(user.Timezone)
+	h.notifier.SendMessage(ctx, msg.Chat.ID,
+		fmt.Sprintf("Редактирование рассылки %s\n\n"+
+			"1. Название: %s\n"+
+			"2. Сегменты: %s\n"+
+			"3. Дата: %s\n"+
+			"4. Текст: %s\n\n"+
+			"Что изменить? Введите номер или название поля:",
+			mailing.ID.Hex(),
+			mailing.Name,
+			describeTargeting(mailing),
+			describeSchedule(mailing, loc),
+			mailing.Message))
+}
+
+// обрабатывает выбор поля для изменения
+func (h *Handler) processEditField(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	field, ok := editFields[strings.ToLower(strings.TrimSpace(msg.Text))]
+	if !ok {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			"Неизвестное поле. Введите 1 (название), 2 (сегменты), 3 (дата) или 4 (текст):")
+		return
+	}
+
+	state.Data["field"] = field
+	state.Status = "awaiting_edit_value"
+	h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
+
+	prompts := map[string]string{
+		"name":         "Введите новое название рассылки:",
+		"segment":      targetingPrompt,
+		"scheduled_at": mailingDatePrompt,
+		"message":      "Введите новый текст сообщения или отправьте файл/изображение с подписью. Если отправить только текст, прежнее вложение будет удалено:",
+	}
+	h.notifier.SendMessage(ctx, msg.Chat.ID, prompts[field])
+}
+
+// обрабатывает новое значение поля и сохраняет рассылку
+func (h *Handler) processEditValue(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	mailingRepo := database.NewMailingRepository(h.db)
+
+	id, _ := primitive.ObjectIDFromHex(state.Data["mailing_id"].(string))
+	mailing, err := mailingRepo.GetByID(ctx, id)
+	if err != nil {
+		h.ClearUserState(ctx, msg.Chat.ID)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Рассылка не найдена.")
+		return
+	}
+
+	switch state.Data["field"].(string) {
+	case "name":
+		mailing.Name = msg.Text
+	case "segment":
+		include, exclude, ok := h.checkMailingTargeting(ctx, msg.Chat.ID, msg.Text)
+		if !ok {
+			return
+		}
+		SetTargeting(mailing, include, exclude)
+	case "scheduled_at":
+		text, local := SplitLocalTime(msg.Text)
+		scheduledAt, ok := h.parseMailingDate(ctx, msg.Chat.ID, text)
+		if !ok {
+			return
+		}
+
+		// как и при создании рассылки, разобранную дату сначала показываем пользователю
+		state.Data["scheduled_at"] = scheduledAt.UTC()
+		state.Data["local_time"] = local
+		state.Data["timezone"] = scheduledAt.Location().String()
+		state.Status = "awaiting_edit_date_confirm"
+		h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
+
+		h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf(
+			"🗓 Рассылка будет отправлена %s. Верно? Ответьте «да» или «нет»:",
+			describeParsedDate(scheduledAt, local)))
+		return
+	case "message":
+		if msg.Text == "" && msg.FileID == "" {
+			h.notifier.SendMessage(ctx, msg.Chat.ID, "Сообщение пустое. Введите текст или отправьте файл:")
+			return
+		}
+		if !h.checkMailingTemplate(ctx, msg.Chat.ID, msg.Text) {
+			return
+		}
+		mailing.Message = msg.Text
+		if !h.attachMailingFile(ctx, msg, mailing) {
+			return
+		}
+	}
+
+	h.saveEditedMailing(ctx, msg, state, mailingRepo, mailing)
+}
+
+// обрабатывает подтверждение новой даты рассылки
+func (h *Handler) processEditDateConfirm(ctx context.Context, msg *botgolang.Message, state *models.UserState) {
+	switch {
+	case IsNoAnswer(msg.Text):
+		state.Status = "awaiting_edit_value"
+		h.saveUserState(ctx, msg.Chat.ID, state.Status, state.Data)
+
+		h.notifier.SendMessage(ctx, msg.Chat.ID, mailingDatePrompt)
+		return
+	case !isYesAnswer(msg.Text):
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ответьте «да», если дата верна, или «нет», чтобы указать её заново.")
+		return
+	}
+
+	mailingRepo := database.NewMailingRepository(h.db)
+	id, _ := primitive.ObjectIDFromHex(state.Data["mailing_id"].(string))
+	mailing, err := mailingRepo.GetByID(ctx, id)
+	if err != nil {
+		h.ClearUserState(ctx, msg.Chat.ID)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Рассылка не найдена.")
+		return
+	}
+
+	local, _ := state.Data["local_time"].(bool)
+	timezone, _ := state.Data["timezone"].(string)
+	SetMailingSchedule(mailing, state.Data["scheduled_at"].(time.Time), local, timezone)
+	h.saveEditedMailing(ctx, msg, state, mailingRepo, mailing)
+}
+
+// сохраняет изменённую рассылку и завершает /edit_mailing
+func (h *Handler) saveEditedMailing(ctx context.Context, msg *botgolang.Message, state *models.UserState,
+	mailingRepo *database.MailingRepository, mailing *models.Mailing) {
+	// изменённую рассылку на защищённый сегмент нужно подтвердить заново
+	previous := mailing.Status
+	if err := h.SubmitMailing(ctx, mailing, time.Now()); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to resubmit mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+		h.ClearUserState(ctx, msg.Chat.ID)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при сохранении рассылки.")
+		return
+	}
+
+	// рассылка могла начать отправляться, пока пользователь вводил значение
+	err := mailingRepo.UpdateInStatus(ctx, mailing, previous)
+	h.ClearUserState(ctx, msg.Chat.ID)
+	if err == mongo.ErrNoDocuments {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Рассылка уже отправляется или была отменена, изменения не сохранены.")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to update mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при сохранении рассылки.")
+		return
+	}
+
+	if mailing.Status == models.MailingPendingApproval {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			fmt.Sprintf("✅ Рассылка %s обновлена и отправлена на подтверждение.", mailing.Name))
+		h.RequestApproval(ctx, mailing)
+		return
+	}
+	response := fmt.Sprintf("✅ Рассылка %s обновлена.", mailing.Name)
+	switch state.Data["field"] {
+	case "segment":
+		response += "\n" + h.describeAudience(ctx, segmenter.MailingAudience(mailing))
+	case "scheduled_at":
+		response += "\nДата отправки: " + describeSchedule(mailing, h.ChatLocation(ctx, msg.Chat.ID))
+	}
+	h.notifier.SendMessage(ctx, msg.Chat.ID, response)
+}
+
+// /mandatory_mailing
+func (h *Handler) handleMandatoryMailing(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	usage := "/mandatory_mailing [id] [да|нет]"
+	if len(args) != 2 || !isYesAnswer(args[1]) && !IsNoAnswer(args[1]) {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Используйте: "+usage)
+		return
+	}
+	mailing, ok := h.loadMailing(ctx, msg.Chat.ID, args, usage)
+	if !ok {
+		return
+	}
+	if !IsEditable(mailing) {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			fmt.Sprintf("Рассылку в состоянии «%s» изменить нельзя.", mailingStatusLabels[mailing.Status]))
+		return
+	}
+
+	mailing.Mandatory = isYesAnswer(args[1])
+	// обязательная рассылка уходит другим получателям, поэтому её нужно подтвердить заново
+	previous := mailing.Status
+	if err := h.SubmitMailing(ctx, mailing, time.Now()); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to resubmit mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при сохранении рассылки.")
+		return
+	}
+
+	mailingRepo := database.NewMailingRepository(h.db)
+	err := mailingRepo.UpdateInStatus(ctx, mailing, previous)
+	if err == mongo.ErrNoDocuments {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Состояние рассылки изменилось, попробуйте ещё раз.")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to update mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при сохранении рассылки.")
+		return
+	}
+
+	response := fmt.Sprintf("✅ Рассылка %s больше не обязательная.", mailing.Name)
+	if mailing.Mandatory {
+		response = fmt.Sprintf("❗ Рассылка %s обязательная: её получат и отписавшиеся, кнопки «Отписаться» не будет.", mailing.Name)
+	}
+	h.notifier.SendMessage(ctx, msg.Chat.ID, response+"\n"+h.describeAudience(ctx, segmenter.MailingAudience(mailing)))
+	if mailing.Status == models.MailingPendingApproval {
+		h.RequestApproval(ctx, mailing)
+	}
+}
+
+// /cancel_mailing
+func (h *Handler) handleCancelMailing(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	mailing, ok := h.loadMailing(ctx, msg.Chat.ID, args, "/cancel_mailing [id]")
+	if !ok {
+		return
+	}
+	if !h.checkMailingOwner(ctx, msg.Chat.ID, user, mailing) {
+		return
+	}
+
+	previous := mailing.Status
+	if err := mailing.Transition(models.MailingCancelled, time.Now()); err != nil {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			fmt.Sprintf("Рассылку в состоянии «%s» отменить нельзя.", mailingStatusLabels[previous]))
+		return
+	}
+
+	mailingRepo := database.NewMailingRepository(h.db)
+	err := mailingRepo.UpdateInStatus(ctx, mailing, previous)
+	if err == mongo.ErrNoDocuments {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Состояние рассылки изменилось, попробуйте ещё раз.")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to cancel mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при отмене рассылки.")
+		return
+	}
+
+	h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("🚫 Рассылка %s отменена.", mailing.Name))
+}
+
+// /delete_mailing
+func (h *Handler) handleDeleteMailing(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	mailing, ok := h.loadMailing(ctx, msg.Chat.ID, args, "/delete_mailing [id]")
+	if !ok {
+		return
+	}
+	if !h.checkMailingOwner(ctx, msg.Chat.ID, user, mailing) {
+		return
+	}
+
+	mailingRepo := database.NewMailingRepository(h.db)
+	err := mailingRepo.DeleteInStatus(ctx, mailing.ID,
+		models.MailingDraft, models.MailingPendingApproval, models.MailingScheduled, models.MailingSent,
+		models.MailingPartiallyFailed, models.MailingFailed, models.MailingCancelled)
+	if err == mongo.ErrNoDocuments {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Рассылка сейчас отправляется, удалить её нельзя.")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to delete mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при удалении рассылки.")
+		return
+	}
+
+	h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("🗑 Рассылка %s удалена.", mailing.Name))
+}
+
+// /test_mailing
+func (h *Handler) handleTestMailing(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	mailing, ok := h.loadMailing(ctx, msg.Chat.ID, args, "/test_mailing [id]")
+	if !ok {
+		return
+	}
+
+	h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf("🧪 Тестовая отправка рассылки %s:", mailing.Name))
+	h.sendPreview(ctx, msg.Chat.ID, mailing)
+}
+
+// отправляет рассылку только в чат chatID, подставляя в шаблон данные этого пользователя
+func (h *Handler) sendPreview(ctx context.Context, chatID string, mailing *models.Mailing) {
+	user, err := database.NewUserRepository(h.db).GetByChatID(ctx, chatID)
+	if err != nil {
+		// подтверждающий мог ещё не зарегистрироваться в боте
+		h.logger.ErrorContext(ctx, "Failed to load user for preview", "chat_id", chatID, "error", err)
+		user = &models.User{ChatID: chatID}
+	}
+
+	if err := h.notifier.SendPreview(ctx, mailing, user); err != nil {
+		h.logger.WarnContext(ctx, "Failed to send preview", "mailing_id", mailing.ID.Hex(), "chat_id", chatID, "error", err)
+		h.notifier.SendMessage(ctx, chatID, fmt.Sprintf("Не удалось отправить рассылку: %v", err))
+	}
+}
+
+// загружает рассылку по id из аргументов команды, при ошибке сообщает пользователю
+func (h *Handler) loadMailing(ctx context.Context, chatID string, args []string, usage string) (*models.Mailing, bool) {
+	if len(args) == 0 {
+		h.notifier.SendMessage(ctx, chatID, "Используйте: "+usage+"\nID рассылок показаны в /list_mailings")
+		return nil, false
+	}
+
+	id, err := primitive.ObjectIDFromHex(args[0])
+	if err != nil {
+		h.notifier.SendMessage(ctx, chatID, "Неверный id рассылки.")
+		return nil, false
+	}
+
+	mailingRepo := database.NewMailingRepository(h.db)
+	mailing, err := mailingRepo.GetByID(ctx, id)
+	if err != nil {
+		h.notifier.SendMessage(ctx, chatID, "Рассылка не найдена.")
+		return nil, false
+	}
+	return mailing, true
+}
+
+// checkMailingOwner разрешает менять рассылку только её автору и администраторам,
+// иначе сообщает пользователю об отказе
+func (h *Handler) checkMailingOwner(ctx context.Context, chatID string, user *models.User, mailing *models.Mailing) bool {
+	if canManageMailing(user, mailing) {
+		return true
+	}
+	h.logger.WarnContext(ctx, "Access denied to mailing", "chat_id", chatID, "mailing_id", mailing.ID.Hex())
+	h.notifier.SendMessage(ctx, chatID, "⛔ Изменять рассылку могут только её автор и администраторы.")
+	return false
+}
+
+// у рассылок, созданных до появления авторов, AuthorChatID пустой, их меняют только администраторы
+func canManageMailing(user *models.User, mailing *models.Mailing) bool {
+	return user.Role == models.RoleAdmin || mailing.AuthorChatID != "" && mailing.AuthorChatID == user.ChatID
+}
+
+func IsEditable(mailing *models.Mailing) bool {
+	for _, status := range editableStatuses {
+		if mailing.Status == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCanManageMailing(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    models.User
+		author  string
+		allowed bool
+	}{
+		{"author", models.User{ChatID: "editor", Role: models.RoleEditor}, "editor", true},
+		{"other editor", models.User{ChatID: "other", Role: models.RoleEditor}, "editor", false},
+		{"admin", models.User{ChatID: "admin", Role: models.RoleAdmin}, "editor", true},
+		// у старых рассылок автора нет
+		{"no author", models.User{ChatID: "", Role: models.RoleEditor}, "", false},
+		{"no author admin", models.User{ChatID: "admin", Role: models.RoleAdmin}, "", true},
+	}
+	for _, tt := range tests {
+		mailing := &models.Mailing{AuthorChatID: tt.author}
+		if got := canManageMailing(&tt.user, mailing); got != tt.allowed {
+			t.Errorf("%s: canManageMailing() = %v, want %v", tt.name, got, tt.allowed)
+		}
+	}
+}
+
+func TestCheckMailingOwner(t *testing.T) {
+	h, transport := newTestHandler()
+	ctx := context.Background()
+	mailing := &models.Mailing{ID: primitive.NewObjectID(), AuthorChatID: "author"}
+
+	if !h.checkMailingOwner(ctx, "author", &models.User{ChatID: "author", Role: models.RoleEditor}, mailing) {
+		t.Error("author is not allowed to manage own mailing")
+	}
+	if h.checkMailingOwner(ctx, "other", &models.User{ChatID: "other", Role: models.RoleEditor}, mailing) {
+		t.Error("other editor is allowed to manage mailing")
+	}
+	if messages := transport.MessagesTo("other"); len(messages) != 1 || messages[0].Text != "⛔ Изменять рассылку могут только её автор и администраторы." {
+		t.Errorf("messages to other = %+v, want access denied", messages)
+	}
+	if messages := transport.MessagesTo("author"); len(messages) != 0 {
+		t.Errorf("messages to author = %+v, want none", messages)
+	}
+}
+
+func TestEditDateConfirm(t *testing.T) {
+	h, transport := newTestHandler()
+	ctx := context.Background()
+	chatID := "editor"
+
+	data := map[string]interface{}{
+		"mailing_id":   primitive.NewObjectID().Hex(),
+		"field":        "scheduled_at",
+		"scheduled_at": time.Now().Add(time.Hour).UTC(),
+		"local_time":   false,
+		"timezone":     "Europe/Moscow",
+	}
+	h.saveUserState(ctx, chatID, "awaiting_edit_date_confirm", data)
+
+	// непонятный ответ не меняет ни рассылку, ни шаг
+	state, _ := h.getUserState(ctx, chatID)
+	h.processUserState(ctx, testMessage(chatID, "может быть"), state)
+	if state, _ := h.getUserState(ctx, chatID); state.Status != "awaiting_edit_date_confirm" {
+		t.Errorf("state after unclear answer = %q, want awaiting_edit_date_confirm", state.Status)
+	}
+
+	// после «нет» дату нужно ввести заново
+	state, _ = h.getUserState(ctx, chatID)
+	h.processUserState(ctx, testMessage(chatID, "нет"), state)
+	state, _ = h.getUserState(ctx, chatID)
+	if state.Status != "awaiting_edit_value" || state.Data["field"] != "scheduled_at" {
+		t.Errorf("state after no = %+v, want awaiting_edit_value for scheduled_at", state)
+	}
+
+	messages := transport.MessagesTo(chatID)
+	if len(messages) != 2 || messages[1].Text != mailingDatePrompt {
+		t.Errorf("messages = %+v, want the date prompt after no", messages)
+	}
+}
@@ -2,29 +2,63 @@ package bot
 
 import (
 	"context"
-	"log"
+	"unicode/utf8"
 
 	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/utils"
 	"github.com/g0shi4ek/VK_bot/models"
 	"github.com/mail-ru-im/bot-golang"
 )
 
 // Middleware
-func (h *Handler) withLogging(next func(*botgolang.Message, []string)) func(*botgolang.Message, []string) {
-	return func(msg *botgolang.Message, args []string) {
-		log.Printf("Received command from %s: %s", msg.Chat.ID, msg.Text)
-		next(msg, args)
+func (h *Handler) withLogging(next func(context.Context, *botgolang.Message, []string)) func(context.Context, *botgolang.Message, []string) {
+	return func(ctx context.Context, msg *botgolang.Message, args []string) {
+		// текст сообщения в журнал не пишется: в аргументах команд бывают тексты рассылок и персональные данные
+		command, _ := utils.ParseCommand(msg.Text)
+		h.logger.InfoContext(ctx, "Received command", "command", command, "chat_id", msg.Chat.ID, "text_len", utf8.RuneCountInString(msg.Text))
+		next(ctx, msg, args)
 	}
 }
 
-func (h *Handler) withAuth(next func(*botgolang.Message, *models.User, []string)) func(*botgolang.Message, []string) {
-	return func(msg *botgolang.Message, args []string) {
+func (h *Handler) withAuth(next func(context.Context, *botgolang.Message, *models.User, []string)) func(context.Context, *botgolang.Message, []string) {
+	return func(ctx context.Context, msg *botgolang.Message, args []string) {
 		userRepo := database.NewUserRepository(h.db)
-		user, err := userRepo.GetByChatID(context.Background(), msg.Chat.ID)
+		user, err := userRepo.GetByChatID(ctx, msg.Chat.ID)
 		if err != nil || user == nil {
-			h.notifier.SendMessage(msg.Chat.ID, "Пожалуйста, сначала зарегистрируйтесь с помощью команды /start")
+			h.notifier.SendMessage(ctx, msg.Chat.ID, "Пожалуйста, сначала зарегистрируйтесь с помощью команды /start")
 			return
 		}
-		next(msg, user, args)
+		next(ctx, msg, user, args)
 	}
-}
\ No newline at end of file
+}
+
+// withRole пропускает команду только пользователям с одной из указанных ролей,
+// администратору доступны все команды
+func (h *Handler) withRole(next func(context.Context, *botgolang.Message, *models.User, []string), roles ...models.Role) func(context.Context, *botgolang.Message, *models.User, []string) {
+	return func(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+		if !hasRole(user, roles...) {
+			command, _ := utils.ParseCommand(msg.Text)
+			h.logger.WarnContext(ctx, "Access denied", "chat_id", msg.Chat.ID, "role", user.Role, "command", command)
+			h.notifier.SendMessage(ctx, msg.Chat.ID, "⛔ Недостаточно прав для выполнения этой команды.")
+			return
+		}
+		next(ctx, msg, user, args)
+	}
+}
+
+func hasRole(user *models.User, roles ...models.Role) bool {
+	if user.Role == models.RoleAdmin {
+		return true
+	}
+	role := user.Role
+	// пользователи, зарегистрированные до появления ролей, считаются подписчиками
+	if role == "" {
+		role = models.RoleSubscriber
+	}
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/internal/notifier"
+	"github.com/g0shi4ek/VK_bot/models"
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+// newTestHandler возвращает Handler без базы данных: ответы бота запоминаются в MemoryTransport,
+// состояния многошаговых команд хранятся в памяти
+func newTestHandler() (*Handler, *notifier.MemoryTransport) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	transport := notifier.NewMemoryTransport()
+	h := &Handler{
+		notifier: notifier.NewNotifier(transport, nil, nil, notifier.Options{}, logger),
+		logger:   logger,
+		states:   newMemStates(),
+	}
+	return h, transport
+}
+
+func testMessage(chatID, text string) *botgolang.Message {
+	return &botgolang.Message{Chat: botgolang.Chat{ID: chatID}, Text: text}
+}
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role  models.Role
+		roles []models.Role
+		want  bool
+	}{
+		{models.RoleAdmin, []models.Role{models.RoleEditor}, true},
+		{models.RoleAdmin, nil, true},
+		{models.RoleEditor, []models.Role{models.RoleEditor}, true},
+		{models.RoleEditor, []models.Role{models.RoleAdmin}, false},
+		{models.RoleSubscriber, []models.Role{models.RoleEditor}, false},
+		{models.RoleSubscriber, []models.Role{models.RoleEditor, models.RoleSubscriber}, true},
+		// пользователи без роли зарегистрированы до появления ролей
+		{"", []models.Role{models.RoleSubscriber}, true},
+		{"", []models.Role{models.RoleEditor}, false},
+	}
+	for _, tt := range tests {
+		if got := hasRole(&models.User{Role: tt.role}, tt.roles...); got != tt.want {
+			t.Errorf("hasRole(%q, %v) = %v, want %v", tt.role, tt.roles, got, tt.want)
+		}
+	}
+}
+
+func TestWithRole(t *testing.T) {
+	h, transport := newTestHandler()
+	ctx := context.Background()
+
+	var called []string
+	command := h.withRole(func(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+		called = append(called, user.ChatID)
+	}, models.RoleEditor)
+
+	command(ctx, testMessage("editor", "/create_mailing"), &models.User{ChatID: "editor", Role: models.RoleEditor}, nil)
+	command(ctx, testMessage("admin", "/create_mailing"), &models.User{ChatID: "admin", Role: models.RoleAdmin}, nil)
+	command(ctx, testMessage("subscriber", "/create_mailing"), &models.User{ChatID: "subscriber", Role: models.RoleSubscriber}, nil)
+
+	if len(called) != 2 || called[0] != "editor" || called[1] != "admin" {
+		t.Errorf("command called for %v, want editor and admin", called)
+	}
+	if messages := transport.MessagesTo("subscriber"); len(messages) != 1 || messages[0].Text != "⛔ Недостаточно прав для выполнения этой команды." {
+		t.Errorf("messages to subscriber = %+v, want access denied", messages)
+	}
+	if messages := transport.MessagesTo("editor"); len(messages) != 0 {
+		t.Errorf("messages to editor = %+v, want none", messages)
+	}
+}
+
+func TestLoggingSkipsMessageText(t *testing.T) {
+	h, _ := newTestHandler()
+	var log bytes.Buffer
+	h.logger = slog.New(slog.NewTextHandler(&log, nil))
+	ctx := context.Background()
+	const text = "/create_mailing Иван Петров, пароль 1234"
+
+	noop := func(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {}
+	h.withLogging(func(ctx context.Context, msg *botgolang.Message, args []string) {})(ctx, testMessage("user", text), nil)
+	h.withRole(noop, models.RoleEditor)(ctx, testMessage("user", text), &models.User{ChatID: "user", Role: models.RoleSubscriber}, nil)
+
+	// журнал без скрытия данных (LOG_REDACT=false) тоже не должен содержать текст сообщения
+	if strings.Contains(log.String(), "Петров") {
+		t.Errorf("log contains message text:\n%s", log.String())
+	}
+	if !strings.Contains(log.String(), "command=create_mailing") || !strings.Contains(log.String(), "text_len=40") {
+		t.Errorf("log = %s, want command and text_len", log.String())
+	}
+}
+
+func TestGrantRoleValidation(t *testing.T) {
+	h, transport := newTestHandler()
+	ctx := context.Background()
+	admin := &models.User{ChatID: "admin", Role: models.RoleAdmin}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"user"}, "Используйте: /grant_role [chat_id] [admin|editor|subscriber]"},
+		{[]string{"user", "owner"}, "Неизвестная роль. Доступные роли: admin, editor, subscriber"},
+		// иначе можно случайно остаться без администратора
+		{[]string{"admin", "editor"}, "Нельзя понизить собственную роль."},
+	}
+	for _, tt := range tests {
+		transport.Reset()
+		h.handleGrantRole(ctx, testMessage("admin", "/grant_role"), admin, tt.args)
+		if messages := transport.MessagesTo("admin"); len(messages) != 1 || messages[0].Text != tt.want {
+			t.Errorf("/grant_role %v: messages = %+v, want %q", tt.args, messages, tt.want)
+		}
+	}
+
+	transport.Reset()
+	h.handleRevokeRole(ctx, testMessage("admin", "/revoke_role admin"), admin, []string{"admin"})
+	if messages := transport.MessagesTo("admin"); len(messages) != 1 || messages[0].Text != "Нельзя понизить собственную роль." {
+		t.Errorf("/revoke_role self: messages = %+v", messages)
+	}
+}
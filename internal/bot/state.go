@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"context"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+// StateStore хранит состояния многошаговых команд, чтобы они переживали
+// перезапуск бота и были общими для всех реплик
+type StateStore interface {
+	Get(ctx context.Context, chatID string) (*models.UserState, error)
+	Save(ctx context.Context, state *models.UserState) error
+	Delete(ctx context.Context, chatID string) error
+}
+
+// методы для работы с состояниями пользователей
+
+func (h *Handler) saveUserState(ctx context.Context, chatID string, status string, data map[string]interface{}) {
+	state := &models.UserState{
+		ChatID: chatID,
+		Status: status,
+		Data:   data,
+	}
+	if err := h.states.Save(ctx, state); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to save state", "chat_id", chatID, "error", err)
+	}
+}
+
+func (h *Handler) getUserState(ctx context.Context, chatID string) (*models.UserState, bool) {
+	state, err := h.states.Get(ctx, chatID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to get state", "chat_id", chatID, "error", err)
+		return nil, false
+	}
+	return state, state != nil
+}
+
+func (h *Handler) ClearUserState(ctx context.Context, chatID string) {
+	if err := h.states.Delete(ctx, chatID); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to clear state", "chat_id", chatID, "error", err)
+	}
+}
+
+// dataInt читает целое число из данных состояния: после загрузки из MongoDB
+// int может вернуться как int32 или int64
+func dataInt(data map[string]interface{}, key string) int {
+	switch v := data[key].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
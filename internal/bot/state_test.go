@@ -0,0 +1,44 @@
+package bot
+
+import (
+	"context"
+	"sync"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+// memStates - StateStore в памяти для тестов
+type memStates struct {
+	mu     sync.Mutex
+	states map[string]*models.UserState
+}
+
+func newMemStates() *memStates {
+	return &memStates{states: make(map[string]*models.UserState)}
+}
+
+func (s *memStates) Get(ctx context.Context, chatID string) (*models.UserState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[chatID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *state
+	return &copied, nil
+}
+
+func (s *memStates) Save(ctx context.Context, state *models.UserState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *state
+	s.states[state.ChatID] = &copied
+	return nil
+}
+
+func (s *memStates) Delete(ctx context.Context, chatID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, chatID)
+	return nil
+}
@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"context"
+
+	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/models"
+	botgolang "github.com/mail-ru-im/bot-golang"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// /unsubscribe
+func (h *Handler) handleUnsubscribe(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	if user.OptedOut {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Вы уже отписаны от рассылок. Подписаться снова: /subscribe")
+		return
+	}
+	h.notifier.SendMessage(ctx, msg.Chat.ID, h.setOptedOut(ctx, msg.Chat.ID, true))
+}
+
+// /subscribe
+func (h *Handler) handleSubscribe(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	if !user.OptedOut {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Вы и так получаете рассылки.")
+		return
+	}
+	h.notifier.SendMessage(ctx, msg.Chat.ID, h.setOptedOut(ctx, msg.Chat.ID, false))
+}
+
+// нажатие кнопки «Отписаться» под рассылкой
+func (h *Handler) handleUnsubscribeButton(ctx context.Context, payload *botgolang.EventPayload, args []string) string {
+	return h.setOptedOut(ctx, payload.From.ID, true)
+}
+
+// setOptedOut меняет подписку пользователя и возвращает текст ответа
+func (h *Handler) setOptedOut(ctx context.Context, chatID string, optedOut bool) string {
+	userRepo := database.NewUserRepository(h.db)
+	err := userRepo.SetOptedOut(ctx, chatID, optedOut)
+	if err == mongo.ErrNoDocuments {
+		return "Вы не зарегистрированы. Используйте /start для регистрации."
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to change subscription", "chat_id", chatID, "error", err)
+		return "Не удалось изменить подписку, попробуйте ещё раз."
+	}
+
+	if optedOut {
+		return "🔕 Вы отписались от рассылок. Важные уведомления всё равно будут приходить. Подписаться снова: /subscribe"
+	}
+	return "🔔 Вы снова подписаны на рассылки."
+}
@@ -0,0 +1,27 @@
+package bot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+func TestSubscriptionUnchanged(t *testing.T) {
+	h, transport := newTestHandler()
+	ctx := context.Background()
+
+	h.handleUnsubscribe(ctx, testMessage("user", "/unsubscribe"), &models.User{ChatID: "user", OptedOut: true}, nil)
+	h.handleSubscribe(ctx, testMessage("user", "/subscribe"), &models.User{ChatID: "user"}, nil)
+
+	messages := transport.MessagesTo("user")
+	want := []string{"Вы уже отписаны от рассылок. Подписаться снова: /subscribe", "Вы и так получаете рассылки."}
+	if len(messages) != len(want) {
+		t.Fatalf("messages = %+v, want %q", messages, want)
+	}
+	for i, text := range want {
+		if messages[i].Text != text {
+			t.Errorf("message %d = %q, want %q", i, messages[i].Text, text)
+		}
+	}
+}
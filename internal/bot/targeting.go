@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+// ParseTargeting разбирает сегменты рассылки: названия через запятую или пробел,
+// исключаемые сегменты с минусом, например "clients, workers, -contractors"
+func ParseTargeting(text string) (include, exclude []string, err error) {
+	seen := make(map[string]bool)
+	for _, name := range strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n'
+	}) {
+		excluded := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if excluded {
+			exclude = append(exclude, name)
+		} else {
+			include = append(include, name)
+		}
+	}
+
+	if len(include) == 0 {
+		return nil, nil, errors.New("не указан ни один сегмент получателей")
+	}
+	return include, exclude, nil
+}
+
+// SetTargeting задаёт сегменты рассылки, заменяя единственный сегмент старых рассылок
+func SetTargeting(mailing *models.Mailing, include, exclude []string) {
+	mailing.Segment = ""
+	mailing.Segments = include
+	mailing.ExcludeSegments = exclude
+}
+
+// describeTargeting описывает сегменты рассылки: "clients + workers, кроме contractors"
+func describeTargeting(mailing *models.Mailing) string {
+	description := strings.Join(mailing.IncludedSegments(), " + ")
+	if len(mailing.ExcludeSegments) > 0 {
+		description += ", кроме " + strings.Join(mailing.ExcludeSegments, ", ")
+	}
+	return description
+}
+
+// проверяет сегменты рассылки, при ошибке сообщает пользователю
+func (h *Handler) checkMailingTargeting(ctx context.Context, chatID, text string) (include, exclude []string, ok bool) {
+	include, exclude, err := ParseTargeting(text)
+	if err != nil {
+		h.notifier.SendMessage(ctx, chatID, "Ошибка: "+err.Error()+". "+targetingPrompt)
+		return nil, nil, false
+	}
+
+	for _, segment := range append(append([]string{}, include...), exclude...) {
+		if !h.checkMailingSegment(ctx, chatID, segment) {
+			return nil, nil, false
+		}
+	}
+	return include, exclude, true
+}
+
+// describeAudience сообщает, сколько пользователей получат рассылку
+func (h *Handler) describeAudience(ctx context.Context, audience segmenter.Audience) string {
+	count, err := h.segmenter.CountAudience(ctx, audience)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to count audience", "error", err)
+		return "Не удалось посчитать получателей."
+	}
+	if count == 0 {
+		return "⚠️ Сейчас в этих сегментах нет получателей."
+	}
+	return fmt.Sprintf("👥 Получателей сейчас: %d", count)
+}
+
+const targetingPrompt = "Укажите сегменты через запятую (или 'all' для всех пользователей). " +
+	"Сегменты, участникам которых рассылку отправлять не нужно, укажите с минусом, например: clients, workers, -contractors"
@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+func TestParseTargeting(t *testing.T) {
+	tests := []struct {
+		text    string
+		include []string
+		exclude []string
+	}{
+		{"all", []string{"all"}, nil},
+		{"clients, workers, -contractors", []string{"clients", "workers"}, []string{"contractors"}},
+		{"clients workers\n-contractors -interns", []string{"clients", "workers"}, []string{"contractors", "interns"}},
+		// повторы и одинокий минус пропускаются
+		{"clients,,clients, - ,-workers,-workers", []string{"clients"}, []string{"workers"}},
+		// сегмент, указанный первым, определяет, включается он или исключается
+		{"clients, -clients, workers", []string{"clients", "workers"}, nil},
+	}
+	for _, tt := range tests {
+		include, exclude, err := ParseTargeting(tt.text)
+		if err != nil {
+			t.Errorf("ParseTargeting(%q) error: %v", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(include, tt.include) || !reflect.DeepEqual(exclude, tt.exclude) {
+			t.Errorf("ParseTargeting(%q) = %v, %v, want %v, %v", tt.text, include, exclude, tt.include, tt.exclude)
+		}
+	}
+}
+
+func TestParseTargetingWithoutInclude(t *testing.T) {
+	for _, text := range []string{"", " , ", "-contractors", "-a -b"} {
+		if _, _, err := ParseTargeting(text); err == nil {
+			t.Errorf("ParseTargeting(%q) returned no error", text)
+		}
+	}
+}
+
+func TestSetTargeting(t *testing.T) {
+	// у рассылок, созданных до исключения сегментов, был единственный сегмент
+	mailing := &models.Mailing{Segment: "clients"}
+	SetTargeting(mailing, []string{"workers", "managers"}, []string{"contractors"})
+
+	if mailing.Segment != "" {
+		t.Errorf("Segment = %q, want empty", mailing.Segment)
+	}
+	if got := mailing.IncludedSegments(); !reflect.DeepEqual(got, []string{"workers", "managers"}) {
+		t.Errorf("IncludedSegments() = %v", got)
+	}
+	if !reflect.DeepEqual(mailing.ExcludeSegments, []string{"contractors"}) {
+		t.Errorf("ExcludeSegments = %v", mailing.ExcludeSegments)
+	}
+}
+
+func TestDescribeTargeting(t *testing.T) {
+	tests := []struct {
+		mailing *models.Mailing
+		want    string
+	}{
+		{&models.Mailing{Segment: "clients"}, "clients"},
+		{&models.Mailing{Segments: []string{"clients", "workers"}}, "clients + workers"},
+		{&models.Mailing{Segments: []string{"all"}, ExcludeSegments: []string{"contractors", "interns"}}, "all, кроме contractors, interns"},
+	}
+	for _, tt := range tests {
+		if got := describeTargeting(tt.mailing); got != tt.want {
+			t.Errorf("describeTargeting(%+v) = %q, want %q", tt.mailing, got, tt.want)
+		}
+	}
+}
+
+func TestCheckMailingTargetingWithoutInclude(t *testing.T) {
+	h, transport := newTestHandler()
+
+	if _, _, ok := h.checkMailingTargeting(context.Background(), "editor", "-contractors"); ok {
+		t.Fatal("checkMailingTargeting accepted targeting without included segments")
+	}
+	want := "Ошибка: не указан ни один сегмент получателей. " + targetingPrompt
+	if messages := transport.MessagesTo("editor"); len(messages) != 1 || messages[0].Text != want {
+		t.Errorf("messages = %+v, want %q", messages, want)
+	}
+}
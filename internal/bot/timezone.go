@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/scheduler"
+	"github.com/g0shi4ek/VK_bot/internal/utils"
+	"github.com/g0shi4ek/VK_bot/models"
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+// окончания даты, после которых рассылка отправляется по часовому поясу каждого получателя
+var localTimeSuffixes = []string{"по местному времени", "по местному", "local time", "local"}
+
+// /timezone
+func (h *Handler) handleTimezone(ctx context.Context, msg *botgolang.Message, user *models.User, args []string) {
+	if len(args) == 0 {
+		h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf(
+			"🕰 Ваш часовой пояс: %s\n\n"+
+				"Изменить: /timezone [пояс], например:\n"+
+				"/timezone Владивосток\n"+
+				"/timezone Asia/Yekaterinburg\n"+
+				"/timezone UTC+10 или /timezone МСК+7",
+			utils.DescribeTimezone(user.Timezone)))
+		return
+	}
+
+	timezone, err := utils.ParseTimezone(strings.Join(args, " "))
+	if err != nil {
+		h.notifier.SendMessage(ctx, msg.Chat.ID,
+			"Неизвестный часовой пояс. Укажите город, название вроде Asia/Vladivostok или смещение вроде UTC+10.")
+		return
+	}
+
+	userRepo := database.NewUserRepository(h.db)
+	if err := userRepo.SetTimezone(ctx, msg.Chat.ID, timezone); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to set timezone", "chat_id", msg.Chat.ID, "error", err)
+		h.notifier.SendMessage(ctx, msg.Chat.ID, "Ошибка при сохранении часового пояса.")
+		return
+	}
+
+	h.notifier.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf(
+		"✅ Часовой пояс: %s. Даты рассылок теперь указываются и показываются по нему.",
+		utils.DescribeTimezone(timezone)))
+}
+
+// ChatLocation возвращает часовой пояс пользователя с этим chat id
+func (h *Handler) ChatLocation(ctx context.Context, chatID string) *time.Location {
+	user, err := database.NewUserRepository(h.db).GetByChatID(ctx, chatID)
+	if err != nil {
+		return utils.LoadTimezone("")
+	}
+	return utils.LoadTimezone(user.Timezone)
+}
+
+// SplitLocalTime отделяет от даты пометку «по местному времени»
+func SplitLocalTime(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	lower := strings.ToLower(text)
+	for _, suffix := range localTimeSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return strings.TrimSpace(text[:len(text)-len(suffix)]), true
+		}
+	}
+	return text, false
+}
+
+// SetMailingSchedule задаёт дату отправки, указанную в часовом поясе timezone.
+// Для local рассылка уйдёт в это время по часовому поясу каждого получателя
+func SetMailingSchedule(mailing *models.Mailing, at time.Time, local bool, timezone string) {
+	mailing.Timezone = timezone
+	mailing.LocalTime = local
+	mailing.SentZones = nil
+	if !local {
+		mailing.OccurrenceAt = nil
+		mailing.ScheduledAt = at.UTC()
+		return
+	}
+
+	occurrenceAt := at.UTC()
+	mailing.OccurrenceAt = &occurrenceAt
+	mailing.ScheduledAt = scheduler.FirstWave(at, timezone)
+}
+
+// describeSchedule возвращает дату отправки в часовом поясе loc
+func describeSchedule(mailing *models.Mailing, loc *time.Location) string {
+	if mailing.LocalTime && mailing.OccurrenceAt != nil {
+		wallClock := mailing.OccurrenceAt.In(utils.LoadTimezone(mailing.Timezone))
+		return wallClock.Format("02.01.2006 15:04") + " по местному времени получателей"
+	}
+	return mailing.ScheduledAt.In(loc).Format("02.01.2006 15:04")
+}
+
+// describeParsedDate возвращает разобранную дату отправки с днём недели и часовым поясом
+func describeParsedDate(at time.Time, local bool) string {
+	if local {
+		return utils.FormatDateTime(at) + " по местному времени получателей"
+	}
+	return utils.FormatDateTime(at) + " (" + utils.DescribeTimezone(at.Location().String()) + ")"
+}
@@ -0,0 +1,121 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// сколько ждать все проверки, прежде чем считать незавершённые неудачными
+const checkTimeout = 5 * time.Second
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check проверяет компонент бота и возвращает ошибку, если он не работает
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker выполняет набор проверок и отдаёт их результат в JSON
+type Checker struct {
+	checks []namedCheck
+	logger *slog.Logger
+}
+
+func NewChecker(logger *slog.Logger) *Checker {
+	return &Checker{logger: logger}
+}
+
+// Add добавляет проверку под именем, с которым она попадёт в ответ
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// CheckResult - результат одной проверки
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Report - результат всех проверок; Status равен ok, только если прошли все проверки
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Run выполняет проверки параллельно
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(c.checks))}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, nc := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := runCheck(ctx, nc.check)
+			result := CheckResult{Status: StatusOK, DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[nc.name] = result
+			if err != nil {
+				report.Status = StatusFail
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// runCheck не даёт зависшей проверке задержать ответ дольше таймаута
+func runCheck(ctx context.Context, check Check) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Handler отвечает 200, если все проверки прошли, и 503, если нет
+func (c *Checker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+			c.logger.WarnContext(r.Context(), "Health check failed", "path", r.URL.Path, "checks", report.Checks)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			c.logger.WarnContext(r.Context(), "Failed to write health response", "error", err)
+		}
+	})
+}
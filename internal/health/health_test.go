@@ -0,0 +1,112 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestChecker(checks map[string]Check) *Checker {
+	checker := NewChecker(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for name, check := range checks {
+		checker.Add(name, check)
+	}
+	return checker
+}
+
+func ok(ctx context.Context) error { return nil }
+
+func TestRun(t *testing.T) {
+	checker := newTestChecker(map[string]Check{
+		"scheduler": ok,
+		"mongodb":   func(ctx context.Context) error { return errors.New("connection refused") },
+	})
+
+	report := checker.Run(context.Background())
+	if report.Status != StatusFail {
+		t.Errorf("status = %s, want fail", report.Status)
+	}
+	if result := report.Checks["scheduler"]; result.Status != StatusOK || result.Error != "" {
+		t.Errorf("scheduler = %+v, want ok", result)
+	}
+	if result := report.Checks["mongodb"]; result.Status != StatusFail || result.Error != "connection refused" {
+		t.Errorf("mongodb = %+v, want fail with error", result)
+	}
+
+	if report := newTestChecker(map[string]Check{"scheduler": ok}).Run(context.Background()); report.Status != StatusOK {
+		t.Errorf("status = %s, want ok", report.Status)
+	}
+}
+
+func TestRunStuckCheck(t *testing.T) {
+	// зависшая проверка не ждёт отмены контекста, но ответ не должен её дожидаться
+	stuck := make(chan struct{})
+	defer close(stuck)
+	checker := newTestChecker(map[string]Check{
+		"updates":   func(ctx context.Context) error { <-stuck; return nil },
+		"scheduler": ok,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report := checker.Run(ctx)
+	if result := report.Checks["updates"]; report.Status != StatusFail || result.Error != context.Canceled.Error() {
+		t.Errorf("report = %+v, want updates failed with %v", report, context.Canceled)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	healthy := true
+	liveness := newTestChecker(map[string]Check{"scheduler": ok})
+	readiness := newTestChecker(map[string]Check{"mongodb": func(ctx context.Context) error {
+		if !healthy {
+			return errors.New("ping failed")
+		}
+		return nil
+	}})
+	handler := NewServer("", liveness, readiness, slog.New(slog.NewTextHandler(io.Discard, nil))).server.Handler
+
+	tests := []struct {
+		method  string
+		path    string
+		healthy bool
+		status  int
+	}{
+		{http.MethodGet, "/healthz", false, http.StatusOK},
+		{http.MethodGet, "/readyz", true, http.StatusOK},
+		{http.MethodGet, "/readyz", false, http.StatusServiceUnavailable},
+		{http.MethodPost, "/readyz", true, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/metrics", true, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		healthy = tt.healthy
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s (healthy %v): status = %d, want %d", tt.method, tt.path, tt.healthy, rec.Code, tt.status)
+			continue
+		}
+		if rec.Code != http.StatusOK && rec.Code != http.StatusServiceUnavailable {
+			continue
+		}
+		if cache := rec.Header().Get("Cache-Control"); cache != "no-store" {
+			t.Errorf("%s: Cache-Control = %q, want no-store", tt.path, cache)
+		}
+		var report Report
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		want := StatusFail
+		if tt.status == http.StatusOK {
+			want = StatusOK
+		}
+		if report.Status != want {
+			t.Errorf("%s: report status = %s, want %s", tt.path, report.Status, want)
+		}
+	}
+}
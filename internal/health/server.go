@@ -0,0 +1,43 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Server отдаёт проверки для оркестратора: /healthz - бот жив, /readyz - бот готов принимать работу
+type Server struct {
+	server *http.Server
+	logger *slog.Logger
+}
+
+func NewServer(addr string, liveness, readiness *Checker, logger *slog.Logger) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", liveness.Handler())
+	mux.Handle("GET /readyz", readiness.Handler())
+
+	return &Server{
+		logger: logger,
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Start принимает запросы, пока сервер не остановят через Shutdown
+func (s *Server) Start() error {
+	s.logger.Info("Starting health server", "addr", s.server.Addr)
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
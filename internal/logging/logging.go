@@ -0,0 +1,155 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options - настройки журнала
+type Options struct {
+	// Level - debug, info, warn или error
+	Level string
+	// Format - text или json
+	Format string
+	// Redact скрывает chat id, имена пользователей и тексты сообщений
+	Redact bool
+}
+
+// New создаёт журнал, который добавляет к записям correlation id из контекста
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	handlerOpts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			// параметры запросов к Bot API содержат токен бота, поэтому убираются всегда
+			attr = scrubError(attr)
+			if opts.Redact {
+				attr = redact(groups, attr)
+			}
+			return attr
+		},
+	}
+
+	var handler slog.Handler
+	switch opts.Format {
+	case FormatText, "":
+		handler = slog.NewTextHandler(w, handlerOpts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// ParseLevel разбирает уровень журнала, пустой уровень - info
+func ParseLevel(text string) (slog.Level, error) {
+	var level slog.Level
+	if text == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(text)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", text)
+	}
+	return level, nil
+}
+
+type correlationKey struct{}
+
+// NewCorrelationID возвращает случайный id для связи записей об одном обновлении или запуске рассылки
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithCorrelationID сохраняет id в контексте; записи журнала с этим контекстом получат поле correlation_id
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID возвращает id из контекста или пустую строку
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// contextHandler добавляет к записи correlation id из контекста
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := CorrelationID(ctx); id != "" {
+		record.AddAttrs(slog.String("correlation_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// redact скрывает персональные данные по названию поля:
+// chat id заменяется хешем, чтобы записи об одном пользователе можно было связать,
+// от текстов сообщений остаётся только длина
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	key := attr.Key
+	switch {
+	case key == "chat_id" || strings.HasSuffix(key, "_chat_id"):
+		return slog.String(key, hashValue(attr.Value.String()))
+	case key == "text" || key == "caption" || key == "first_name" || key == "last_name" || key == "attribute_value":
+		return slog.String(key, fmt.Sprintf("[redacted, %d chars]", len([]rune(attr.Value.String()))))
+	}
+	return attr
+}
+
+// ссылка с параметрами запроса: всё после "?" до пробела или кавычки
+var urlQuery = regexp.MustCompile(`(https?://[^\s?"']*)\?[^\s"']*`)
+
+// ScrubURLs убирает из текста параметры запросов в ссылках. bot-golang включает в ошибки
+// полный адрес запроса к Bot API, а в его параметрах передаются токен бота, chat id и текст сообщения
+func ScrubURLs(text string) string {
+	return urlQuery.ReplaceAllString(text, "$1?[redacted]")
+}
+
+// scrubError убирает параметры запросов из ошибок и из поля error
+func scrubError(attr slog.Attr) slog.Attr {
+	if err, ok := attr.Value.Any().(error); ok && attr.Value.Kind() == slog.KindAny {
+		return slog.String(attr.Key, ScrubURLs(err.Error()))
+	}
+	if attr.Key == "error" && attr.Value.Kind() == slog.KindString {
+		return slog.String(attr.Key, ScrubURLs(attr.Value.String()))
+	}
+	return attr
+}
+
+func hashValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
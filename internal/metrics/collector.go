@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// сколько ждать базу при сборе метрик, чтобы не задерживать Prometheus
+const collectTimeout = 5 * time.Second
+
+// состояния рассылок, которые показываются даже при нулевом количестве
+var mailingStatuses = []models.MailingStatus{
+	models.MailingDraft,
+	models.MailingPendingApproval,
+	models.MailingScheduled,
+	models.MailingSending,
+	models.MailingSent,
+	models.MailingPartiallyFailed,
+	models.MailingFailed,
+	models.MailingCancelled,
+}
+
+var (
+	mailingsDesc = prometheus.NewDesc("vkbot_mailings",
+		"Mailings by status; scheduled and pending_approval are waiting to be sent.", []string{"status"}, nil)
+	wizardStatesDesc = prometheus.NewDesc("vkbot_wizard_states",
+		"Multi-step commands in progress by current step.", []string{"step"}, nil)
+)
+
+// statusCounter считает рассылки по состояниям и многошаговые команды по текущему шагу
+type statusCounter interface {
+	CountMailings(ctx context.Context) (map[models.MailingStatus]int, error)
+	CountWizardStates(ctx context.Context) (map[string]int, error)
+}
+
+type dbCounter struct {
+	db *database.Database
+}
+
+func (c *dbCounter) CountMailings(ctx context.Context) (map[models.MailingStatus]int, error) {
+	return database.NewMailingRepository(c.db).CountByStatus(ctx)
+}
+
+func (c *dbCounter) CountWizardStates(ctx context.Context) (map[string]int, error) {
+	return database.NewUserStateRepository(c.db).CountByStatus(ctx)
+}
+
+// dbCollector считает рассылки и незавершённые многошаговые команды в базе в момент запроса метрик,
+// поэтому значения одинаковы на всех экземплярах бота
+type dbCollector struct {
+	counter statusCounter
+	logger  *slog.Logger
+}
+
+func newDBCollector(db *database.Database, logger *slog.Logger) *dbCollector {
+	return &dbCollector{counter: &dbCounter{db: db}, logger: logger}
+}
+
+func (c *dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- mailingsDesc
+	ch <- wizardStatesDesc
+}
+
+func (c *dbCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	mailings, err := c.counter.CountMailings(ctx)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "Failed to count mailings for metrics", "error", err)
+	} else {
+		for _, status := range mailingStatuses {
+			ch <- prometheus.MustNewConstMetric(mailingsDesc, prometheus.GaugeValue,
+				float64(mailings[status]), string(status))
+		}
+	}
+
+	states, err := c.counter.CountWizardStates(ctx)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "Failed to count user states for metrics", "error", err)
+		return
+	}
+	for step, count := range states {
+		ch <- prometheus.MustNewConstMetric(wizardStatesDesc, prometheus.GaugeValue, float64(count), step)
+	}
+}
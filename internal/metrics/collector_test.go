@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeCounter struct {
+	mailings    map[models.MailingStatus]int
+	mailingsErr error
+	states      map[string]int
+}
+
+func (c *fakeCounter) CountMailings(ctx context.Context) (map[models.MailingStatus]int, error) {
+	return c.mailings, c.mailingsErr
+}
+
+func (c *fakeCounter) CountWizardStates(ctx context.Context) (map[string]int, error) {
+	return c.states, nil
+}
+
+func TestDBCollector(t *testing.T) {
+	collector := &dbCollector{
+		counter: &fakeCounter{
+			mailings: map[models.MailingStatus]int{models.MailingScheduled: 3, models.MailingSent: 10},
+			states:   map[string]int{"awaiting_mailing_name": 2},
+		},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	// состояния без рассылок показываются нулём, чтобы по ним можно было строить графики
+	expected := `
+# HELP vkbot_mailings Mailings by status; scheduled and pending_approval are waiting to be sent.
+# TYPE vkbot_mailings gauge
+vkbot_mailings{status="cancelled"} 0
+vkbot_mailings{status="draft"} 0
+vkbot_mailings{status="failed"} 0
+vkbot_mailings{status="partially_failed"} 0
+vkbot_mailings{status="pending_approval"} 0
+vkbot_mailings{status="scheduled"} 3
+vkbot_mailings{status="sending"} 0
+vkbot_mailings{status="sent"} 10
+# HELP vkbot_wizard_states Multi-step commands in progress by current step.
+# TYPE vkbot_wizard_states gauge
+vkbot_wizard_states{step="awaiting_mailing_name"} 2
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDBCollectorError(t *testing.T) {
+	collector := &dbCollector{
+		counter: &fakeCounter{
+			mailingsErr: errors.New("server selection timeout"),
+			states:      map[string]int{"awaiting_mailing_date": 1},
+		},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	// без ответа базы рассылки не показываются вовсе, а не нулями
+	if count := testutil.CollectAndCount(collector, "vkbot_mailings"); count != 0 {
+		t.Errorf("collected %d mailing series, want none", count)
+	}
+	if count := testutil.CollectAndCount(collector, "vkbot_wizard_states"); count != 1 {
+		t.Errorf("collected %d wizard state series, want 1", count)
+	}
+}
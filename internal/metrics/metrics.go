@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Updates - обновления от VK Teams: type - message или callback,
+	// command - команда, шаг мастера (wizard), обычный текст (text) или ключ кнопки
+	Updates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vkbot_updates_total",
+		Help: "Received VK Teams updates by type and command.",
+	}, []string{"type", "command"})
+
+	// MailingMessages - сообщения рассылок по результату доставки
+	MailingMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vkbot_mailing_messages_total",
+		Help: "Mailing messages by mailing, recipient segment and delivery status (sent or failed).",
+	}, []string{"mailing", "segment", "status"})
+
+	BotAPIDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "vkbot_bot_api_request_duration_seconds",
+		Help:    "Duration of VK Teams Bot API requests by method and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "outcome"})
+
+	// SchedulerTickDuration - длительность проверки планировщика вместе с отправкой наступивших рассылок
+	SchedulerTickDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "vkbot_scheduler_tick_duration_seconds",
+		Help:    "Duration of scheduler runs, including sending due mailings.",
+		Buckets: []float64{0.05, 0.1, 0.5, 1, 5, 15, 30, 60, 300, 900},
+	})
+
+	// MailingSendLag - насколько позже ScheduledAt рассылка начала отправляться
+	MailingSendLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "vkbot_mailing_send_lag_seconds",
+		Help:    "Delay between the scheduled time of a mailing and the start of sending.",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 900, 3600},
+	})
+)
+
+// ObserveBotAPI записывает длительность запроса к Bot API, начатого в start
+func ObserveBotAPI(method string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	BotAPIDuration.WithLabelValues(method, outcome).Observe(time.Since(start).Seconds())
+}
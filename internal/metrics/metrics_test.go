@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveBotAPI(t *testing.T) {
+	before := testutil.CollectAndCount(BotAPIDuration)
+
+	ObserveBotAPI("test/ok", time.Now(), nil)
+	ObserveBotAPI("test/ok", time.Now(), nil)
+	ObserveBotAPI("test/error", time.Now(), errors.New("timeout"))
+
+	// одна серия на метод и исход запроса
+	if count := testutil.CollectAndCount(BotAPIDuration); count != before+2 {
+		t.Errorf("collected %d series, want %d", count, before+2)
+	}
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Server отдаёт метрики Prometheus по адресу /metrics; на нём же можно разместить
+// другие служебные обработчики через Handle
+type Server struct {
+	mux    *http.ServeMux
+	server *http.Server
+	logger *slog.Logger
+}
+
+func NewServer(addr string, db *database.Database, logger *slog.Logger) *Server {
+	prometheus.MustRegister(newDBCollector(db, logger))
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	return &Server{
+		mux:    mux,
+		logger: logger,
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Handle регистрирует служебный обработчик, вызывается до Start
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start принимает запросы, пока сервер не остановят через Shutdown
+func (s *Server) Start() error {
+	s.logger.Info("Starting metrics server", "addr", s.server.Addr)
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
@@ -2,53 +2,378 @@ package notifier
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/g0shi4ek/VK_bot/database"
-	botgolang "github.com/mail-ru-im/bot-golang"
+	"github.com/g0shi4ek/VK_bot/internal/metrics"
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Options - настройки параллельной отправки
+type Options struct {
+	// количество одновременных отправок при рассылке
+	Workers int
+	// общий лимит сообщений в секунду и допустимый всплеск, 0 - без ограничения
+	Rate  float64
+	Burst int
+	// лимит сообщений в секунду в один чат
+	ChatRate  float64
+	ChatBurst int
+	// повторы отправки при временных ошибках
+	Retry RetryPolicy
+}
+
 type Notifier struct {
-	bot *botgolang.Bot
-	db  *database.Database
+	transport Transport
+	store     Store
+	limiter   *rateLimiter
+	workers   int
+	retry     RetryPolicy
+	logger    *slog.Logger
 }
 
-func NewNotifier(bot *botgolang.Bot, db *database.Database) *Notifier {
+func NewNotifier(transport Transport, db *database.Database, segmenter *segmenter.Segmenter, opts Options, logger *slog.Logger) *Notifier {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.Retry.MaxAttempts < 1 {
+		opts.Retry.MaxAttempts = 1
+	}
 	return &Notifier{
-		bot: bot,
-		db:  db,
+		transport: transport,
+		store:     &mongoStore{db: db, segmenter: segmenter},
+		limiter:   newRateLimiter(opts.Rate, opts.Burst, opts.ChatRate, opts.ChatBurst),
+		workers:   opts.Workers,
+		retry:     opts.Retry,
+		logger:    logger,
 	}
 }
 
-func (n *Notifier) SendMessage(chatID, text string) error {
-	message := n.bot.NewTextMessage(chatID, text)
-	err := message.Send()
+// SendMessage отправляет ответ пользователю. Отмена ctx не прерывает отправку,
+// из контекста берётся только correlation id для журнала
+func (n *Notifier) SendMessage(ctx context.Context, chatID, text string) error {
+	_, err := n.sendMessage(context.WithoutCancel(ctx), chatID, text)
+	return err
+}
+
+// SendKeyboard отправляет текст с кнопками
+func (n *Notifier) SendKeyboard(ctx context.Context, chatID, text string, keyboard Keyboard) error {
+	_, err := n.sendContent(context.WithoutCancel(ctx), chatID, Content{Text: text, Keyboard: keyboard})
+	return err
+}
+
+// sendMessage отправляет текст с учётом лимитов и возвращает id сообщения в VK Teams
+func (n *Notifier) sendMessage(ctx context.Context, chatID, text string) (string, error) {
+	return n.sendContent(ctx, chatID, Content{Text: text})
+}
+
+// Content - содержимое сообщения: текст или файл с подписью и кнопки
+type Content struct {
+	Text     string
+	FileID   string
+	Keyboard Keyboard
+}
+
+func mailingContent(mailing *models.Mailing) Content {
+	content := Content{
+		Text:   mailing.Message,
+		FileID: mailing.FileID,
+	}
+	for i, button := range mailing.Buttons {
+		content.Keyboard = append(content.Keyboard, []KeyboardButton{{
+			Text:         button.Text,
+			URL:          button.URL,
+			CallbackData: ButtonCallbackData(mailing.ID, i),
+		}})
+	}
+	// от обязательных рассылок отписаться нельзя
+	if !mailing.Mandatory {
+		content.Keyboard = append(content.Keyboard, []KeyboardButton{{
+			Text:         "Отписаться",
+			CallbackData: unsubscribeCallbackData,
+		}})
+	}
+	return content
+}
+
+// unsubscribeCallbackData - данные callback кнопки «Отписаться» под рассылками
+const unsubscribeCallbackData = "unsubscribe"
+
+// ErrOptedOut - получатель отписался от рассылок
+var ErrOptedOut = errors.New("recipient opted out")
+
+// ErrMailingCancelled - рассылка отменена или удалена, повторно отправлять её нельзя
+var ErrMailingCancelled = errors.New("mailing cancelled")
+
+// ButtonCallbackData возвращает данные callback для кнопки-отклика рассылки
+func ButtonCallbackData(mailingID primitive.ObjectID, index int) string {
+	return fmt.Sprintf("btn:%s:%d", mailingID.Hex(), index)
+}
+
+// previewCallbackData - данные callback кнопок-откликов в тестовой отправке, нажатия не учитываются
+const previewCallbackData = "preview"
+
+// SendPreview отправляет рассылку в том виде, в каком её получит user, только в чат user.
+// Доставка не записывается, а нажатия кнопок-откликов не попадают в статистику рассылки
+func (n *Notifier) SendPreview(ctx context.Context, mailing *models.Mailing, user *models.User) error {
+	text, err := RenderMessage(mailing.Message, user, recipientSegment(mailing, user))
 	if err != nil {
-		log.Printf("Failed to send message to chat %s: %v", chatID, err)
 		return err
 	}
 
-	log.Printf("Message sent to chat %s: %s", chatID, text)
-	return nil
+	content := mailingContent(mailing)
+	content.Text = text
+	for _, row := range content.Keyboard {
+		for i := range row {
+			row[i].CallbackData = previewCallbackData
+		}
+	}
+
+	_, err = n.sendContent(ctx, user.ChatID, content)
+	return err
 }
 
-func (n *Notifier) SendMessageToSegment(segment, text string) error {
-	// Получение пользователей по сегменту
-	log.Println("aaaaaaaaaaaaaaaaa")
-	ctx := context.Background()
-	userRepo := database.NewUserRepository(n.db)
-	users, err := userRepo.ListBySegment(ctx, segment)
+// sendContent отправляет сообщение с учётом лимитов и возвращает id сообщения в VK Teams
+func (n *Notifier) sendContent(ctx context.Context, chatID string, content Content) (string, error) {
+	if err := n.limiter.Wait(ctx, chatID); err != nil {
+		return "", err
+	}
+
+	var (
+		messageID string
+		err       error
+	)
+	if content.FileID != "" {
+		messageID, err = n.transport.SendFile(ctx, chatID, content.FileID, content.Text, content.Keyboard)
+	} else {
+		messageID, err = n.transport.SendText(ctx, chatID, content.Text, content.Keyboard)
+	}
 	if err != nil {
-		return err
+		n.logger.WarnContext(ctx, "Failed to send message", "chat_id", chatID, "error", err)
+		return "", err
+	}
+
+	n.logger.DebugContext(ctx, "Message sent", "chat_id", chatID, "message_id", messageID, "text_len", utf8.RuneCountInString(content.Text))
+	return messageID, nil
+}
+
+// SendResult - итог отправки рассылки
+type SendResult struct {
+	Total  int
+	Sent   int
+	Failed int
+}
+
+// SendMailing отправляет рассылку получателям audience.
+// Получатели, которым текущая отправка уже была доставлена (например, до падения
+// другого экземпляра бота), пропускаются
+func (n *Notifier) SendMailing(ctx context.Context, mailing *models.Mailing, audience segmenter.Audience) (SendResult, error) {
+	// Получение пользователей по сегментам, состав динамических сегментов вычисляется сейчас
+	users, err := n.store.Audience(ctx, audience)
+	if err != nil {
+		return SendResult{}, err
+	}
+
+	delivered, err := n.store.SentChatIDs(ctx, mailing.ID, mailing.Occurrences)
+	if err != nil {
+		return SendResult{}, err
+	}
+
+	tmpl, err := parseMessageTemplate(mailing.Message)
+	if err != nil {
+		return SendResult{}, fmt.Errorf("invalid message template: %w", err)
+	}
+
+	jobs := make(chan *models.User)
+	var (
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		sent, failed int
+	)
+
+	// Отправка пользователям пулом воркеров
+	for i := 0; i < n.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range jobs {
+				status := n.deliver(ctx, mailing, tmpl, user)
+				if status != "" {
+					metrics.MailingMessages.WithLabelValues(mailing.ID.Hex(), recipientSegment(mailing, user), string(status)).Inc()
+				}
+				mu.Lock()
+				switch status {
+				case models.DeliverySent:
+					sent++
+				case models.DeliveryFailed:
+					failed++
+				}
+				mu.Unlock()
+			}
+		}()
 	}
 
-	// Отправка пользователю
+	skipped := 0
+send:
 	for _, user := range users {
-		if err := n.SendMessage(user.ChatID, text); err != nil {
-			log.Printf("Failed to send message to user %s: %v", user.ChatID, err)
+		if delivered[user.ChatID] {
+			skipped++
 			continue
 		}
+		select {
+		case jobs <- user:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	sent += skipped
+
+	n.logger.InfoContext(ctx, "Mailing sent", "mailing_id", mailing.ID.Hex(),
+		"sent", sent, "failed", failed, "skipped", skipped, "total", len(users))
+	return SendResult{Total: len(users), Sent: sent, Failed: failed}, ctx.Err()
+}
+
+// deliver отправляет сообщение одному получателю и записывает результат в журнал доставки.
+// Получатели, которым не удалось отправить из-за временных ошибок, попадают в dead letter.
+// Возвращает пустой статус, если рассылка была прервана до попытки отправки
+func (n *Notifier) deliver(ctx context.Context, mailing *models.Mailing, tmpl *messageTemplate, user *models.User) models.DeliveryStatus {
+	delivery := &models.Delivery{
+		MailingID:  mailing.ID,
+		Occurrence: mailing.Occurrences,
+		UserID:     user.ID,
+		ChatID:     user.ChatID,
+		Status:     models.DeliverySent,
+	}
+
+	content := mailingContent(mailing)
+	text, err := tmpl.render(newTemplateData(user, recipientSegment(mailing, user)))
+	if err != nil {
+		n.logger.ErrorContext(ctx, "Failed to render message", "mailing_id", mailing.ID.Hex(), "chat_id", user.ChatID, "error", err)
+		delivery.Status = models.DeliveryFailed
+		delivery.Error = err.Error()
+		delivery.Permanent = true
+		if err := n.store.CreateDelivery(context.WithoutCancel(ctx), delivery); err != nil {
+			n.logger.ErrorContext(ctx, "Failed to save delivery", "mailing_id", mailing.ID.Hex(), "chat_id", user.ChatID, "error", err)
+		}
+		return delivery.Status
+	}
+	content.Text = text
+
+	var messageID string
+	attempts, err := n.sendWithRetry(ctx, func() error {
+		var err error
+		messageID, err = n.sendContent(ctx, user.ChatID, content)
+		return err
+	})
+	if attempts == 1 && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return ""
+	}
+	delivery.Attempts = attempts
+	delivery.MessageID = messageID
+
+	// результат уже известен, поэтому сохраняем его даже при отмене рассылки
+	saveCtx := context.WithoutCancel(ctx)
+	if err != nil {
+		n.logger.WarnContext(ctx, "Failed to deliver mailing message", "mailing_id", mailing.ID.Hex(),
+			"chat_id", user.ChatID, "attempts", attempts, "error", err)
+		delivery.Status = models.DeliveryFailed
+		delivery.Error = err.Error()
+		delivery.Permanent = !isTransient(err)
+
+		// при отмене рассылки или потере аренды недоставленным получателям не нужен dead letter
+		if !delivery.Permanent && ctx.Err() == nil {
+			letter := &models.DeadLetter{
+				MailingID:  mailing.ID,
+				Occurrence: mailing.Occurrences,
+				UserID:     user.ID,
+				ChatID:     user.ChatID,
+				Text:       content.Text,
+				FileID:     content.FileID,
+				Error:      err.Error(),
+				Attempts:   attempts,
+			}
+			if err := n.store.CreateDeadLetter(saveCtx, letter); err != nil {
+				n.logger.ErrorContext(ctx, "Failed to save dead letter", "mailing_id", mailing.ID.Hex(), "chat_id", user.ChatID, "error", err)
+			}
+		}
 	}
 
-	return nil
+	if err := n.store.CreateDelivery(saveCtx, delivery); err != nil {
+		n.logger.ErrorContext(ctx, "Failed to save delivery", "mailing_id", mailing.ID.Hex(), "chat_id", user.ChatID, "error", err)
+	}
+	return delivery.Status
+}
+
+// Redrive повторно отправляет сообщение из dead letter. При успехе запись удаляется,
+// иначе в ней обновляются ошибка и число попыток
+func (n *Notifier) Redrive(ctx context.Context, letter *models.DeadLetter) error {
+	// рассылку могли отменить или удалить, пока сообщение лежало в недоставленных
+	mailing, err := n.store.GetMailing(ctx, letter.MailingID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if err != nil || mailing.Status == models.MailingCancelled {
+		if err := n.store.DeleteDeadLetter(ctx, letter.ID); err != nil {
+			n.logger.ErrorContext(ctx, "Failed to delete dead letter", "dead_letter_id", letter.ID.Hex(), "error", err)
+		}
+		return ErrMailingCancelled
+	}
+
+	// кнопки не хранятся в dead letter, берём их из рассылки
+	content := Content{Text: letter.Text, FileID: letter.FileID, Keyboard: mailingContent(mailing).Keyboard}
+
+	// пользователь мог отписаться, пока сообщение лежало в недоставленных
+	if !mailing.Mandatory {
+		user, err := n.store.GetUserByChatID(ctx, letter.ChatID)
+		if err == nil && user.OptedOut {
+			if err := n.store.DeleteDeadLetter(ctx, letter.ID); err != nil {
+				n.logger.ErrorContext(ctx, "Failed to delete dead letter", "dead_letter_id", letter.ID.Hex(), "error", err)
+			}
+			return ErrOptedOut
+		}
+	}
+
+	var messageID string
+	attempts, err := n.sendWithRetry(ctx, func() error {
+		var err error
+		messageID, err = n.sendContent(ctx, letter.ChatID, content)
+		return err
+	})
+
+	delivery := &models.Delivery{
+		MailingID:  letter.MailingID,
+		Occurrence: letter.Occurrence,
+		UserID:     letter.UserID,
+		ChatID:     letter.ChatID,
+		Status:     models.DeliverySent,
+		Attempts:   attempts,
+		MessageID:  messageID,
+	}
+	if err != nil {
+		delivery.Status = models.DeliveryFailed
+		delivery.Error = err.Error()
+		delivery.Permanent = !isTransient(err)
+
+		letter.Error = err.Error()
+		letter.Attempts += attempts
+		if err := n.store.UpdateDeadLetter(ctx, letter); err != nil {
+			n.logger.ErrorContext(ctx, "Failed to update dead letter", "dead_letter_id", letter.ID.Hex(), "error", err)
+		}
+	} else if err := n.store.DeleteDeadLetter(ctx, letter.ID); err != nil {
+		n.logger.ErrorContext(ctx, "Failed to delete dead letter", "dead_letter_id", letter.ID.Hex(), "error", err)
+	}
+
+	if err := n.store.CreateDelivery(ctx, delivery); err != nil {
+		n.logger.ErrorContext(ctx, "Failed to save delivery", "mailing_id", letter.MailingID.Hex(), "chat_id", letter.ChatID, "error", err)
+	}
+	return err
 }
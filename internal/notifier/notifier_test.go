@@ -0,0 +1,498 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errUnavailable = errors.New("error status from API: 503 Service Unavailable")
+	errForbidden   = errors.New("error status from API: 403 Forbidden")
+)
+
+func TestSendMailing(t *testing.T) {
+	n, transport, store := newTestNotifier()
+	ctx := context.Background()
+
+	store.addUser(&models.User{ChatID: "anna", FirstName: "Анна", Segments: []string{"team"}})
+	store.addUser(&models.User{ChatID: "boris", FirstName: "Борис", Segments: []string{"team", "clients"}})
+	store.addUser(&models.User{ChatID: "client", Segments: []string{"clients"}})
+	mailing := store.addMailing(&models.Mailing{Name: "hello", Message: "Привет, {{.FirstName}}!", Segment: "team"})
+
+	result, err := n.SendMailing(ctx, mailing, segmenter.MailingAudience(mailing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SendResult{Total: 2, Sent: 2}); result != want {
+		t.Errorf("SendMailing() = %+v, want %+v", result, want)
+	}
+
+	for chatID, text := range map[string]string{"anna": "Привет, Анна!", "boris": "Привет, Борис!"} {
+		messages := transport.MessagesTo(chatID)
+		if len(messages) != 1 || messages[0].Text != text {
+			t.Errorf("messages to %s = %+v, want one %q", chatID, messages, text)
+		}
+		deliveries := store.deliveriesTo(chatID)
+		if len(deliveries) != 1 || deliveries[0].Status != models.DeliverySent || deliveries[0].Attempts != 1 ||
+			deliveries[0].MessageID != messages[0].ID {
+			t.Errorf("deliveries to %s = %+v, want one sent", chatID, deliveries)
+		}
+	}
+	if len(transport.Messages()) != 2 {
+		t.Errorf("sent %d messages, want 2", len(transport.Messages()))
+	}
+}
+
+func TestSendMailingFile(t *testing.T) {
+	n, transport, store := newTestNotifier()
+
+	store.addUser(&models.User{ChatID: "anna", Segments: []string{"team"}})
+	mailing := store.addMailing(&models.Mailing{Name: "file", Message: "отчёт", FileID: "file-1", Segment: "team"})
+
+	if _, err := n.SendMailing(context.Background(), mailing, segmenter.MailingAudience(mailing)); err != nil {
+		t.Fatal(err)
+	}
+	messages := transport.MessagesTo("anna")
+	if len(messages) != 1 || messages[0].FileID != "file-1" || messages[0].Text != "отчёт" {
+		t.Errorf("messages = %+v, want file with caption", messages)
+	}
+}
+
+func TestSendMessageLogsLengthOnly(t *testing.T) {
+	n, _, _ := newTestNotifier()
+	var log bytes.Buffer
+	n.logger = slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	if err := n.SendMessage(context.Background(), "anna", "Привет, Анна!"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(log.String(), "Анна") || !strings.Contains(log.String(), "text_len=13") {
+		t.Errorf("log = %s, want text_len=13 without message text", log.String())
+	}
+}
+
+func TestMemoryTransportFailChat(t *testing.T) {
+	transport := NewMemoryTransport()
+	ctx := context.Background()
+
+	transport.FailChat("down", errUnavailable)
+	if _, err := transport.SendText(ctx, "down", "text", nil); err != errUnavailable {
+		t.Errorf("SendText() error = %v, want %v", err, errUnavailable)
+	}
+	transport.FailChat("down", nil)
+	id, err := transport.SendText(ctx, "down", "text", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := transport.EditText(ctx, "down", id, "edited"); err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Delete(ctx, "down", id); err != nil {
+		t.Fatal(err)
+	}
+	if messages := transport.MessagesTo("down"); len(messages) != 1 || messages[0].Text != "edited" || !messages[0].Deleted {
+		t.Errorf("messages = %+v, want one edited and deleted", messages)
+	}
+	if err := transport.Delete(ctx, "down", "missing"); err == nil {
+		t.Error("Delete() of missing message succeeded")
+	}
+}
+
+func TestSendMailingRetriesTransientErrors(t *testing.T) {
+	n, transport, store := newTestNotifier()
+
+	store.addUser(&models.User{ChatID: "flaky", Segments: []string{"team"}})
+	mailing := store.addMailing(&models.Mailing{Name: "retry", Message: "text", Segment: "team"})
+	transport.FailChatTimes("flaky", errUnavailable, 2)
+
+	result, err := n.SendMailing(context.Background(), mailing, segmenter.MailingAudience(mailing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SendResult{Total: 1, Sent: 1}); result != want {
+		t.Errorf("SendMailing() = %+v, want %+v", result, want)
+	}
+
+	deliveries := store.deliveriesTo("flaky")
+	if len(deliveries) != 1 || deliveries[0].Status != models.DeliverySent || deliveries[0].Attempts != 3 {
+		t.Errorf("deliveries = %+v, want one sent after 3 attempts", deliveries)
+	}
+	if letters := store.letters(); len(letters) != 0 {
+		t.Errorf("dead letters = %+v, want none", letters)
+	}
+}
+
+func TestSendMailingDeadLetters(t *testing.T) {
+	n, transport, store := newTestNotifier()
+	ctx := context.Background()
+
+	store.addUser(&models.User{ChatID: "down", Segments: []string{"team"}})
+	store.addUser(&models.User{ChatID: "blocked", Segments: []string{"team"}})
+	store.addUser(&models.User{ChatID: "ok", Segments: []string{"team"}})
+	mailing := store.addMailing(&models.Mailing{Name: "dead", Message: "text", Segment: "team"})
+	transport.FailChat("down", errUnavailable)
+	transport.FailChat("blocked", errForbidden)
+
+	result, err := n.SendMailing(ctx, mailing, segmenter.MailingAudience(mailing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SendResult{Total: 3, Sent: 1, Failed: 2}); result != want {
+		t.Errorf("SendMailing() = %+v, want %+v", result, want)
+	}
+
+	if got := store.deliveriesTo("down"); len(got) != 1 || got[0].Status != models.DeliveryFailed || got[0].Attempts != 3 || got[0].Permanent {
+		t.Errorf("deliveries to down = %+v, want one transient failure after 3 attempts", got)
+	}
+	if got := store.deliveriesTo("blocked"); len(got) != 1 || got[0].Status != models.DeliveryFailed || got[0].Attempts != 1 || !got[0].Permanent {
+		t.Errorf("deliveries to blocked = %+v, want one permanent failure after 1 attempt", got)
+	}
+
+	// повторять имеет смысл только временные ошибки
+	letters := store.letters()
+	if len(letters) != 1 || letters[0].ChatID != "down" || letters[0].Attempts != 3 || letters[0].Text != "text" {
+		t.Fatalf("dead letters = %+v, want one for down", letters)
+	}
+
+	// пока чат недоступен, dead letter остаётся с увеличенным числом попыток
+	letter := letters[0]
+	if err := n.Redrive(ctx, &letter); !errors.Is(err, errUnavailable) {
+		t.Fatalf("Redrive() error = %v, want %v", err, errUnavailable)
+	}
+	if letters := store.letters(); len(letters) != 1 || letters[0].Attempts != 6 {
+		t.Fatalf("dead letters after failed redrive = %+v, want one with 6 attempts", letters)
+	}
+
+	// после восстановления чата сообщение доставляется повторно, а dead letter удаляется
+	transport.FailChat("down", nil)
+	if err := n.Redrive(ctx, &letter); err != nil {
+		t.Fatalf("Redrive() error: %v", err)
+	}
+	if messages := transport.MessagesTo("down"); len(messages) != 1 || messages[0].Text != "text" {
+		t.Errorf("messages to down = %+v, want one", messages)
+	}
+	if letters := store.letters(); len(letters) != 0 {
+		t.Errorf("dead letters after redrive = %+v, want none", letters)
+	}
+}
+
+// cancellingTransport отменяет рассылку при первой отправке, как при потере аренды
+type cancellingTransport struct {
+	*MemoryTransport
+	cancel context.CancelFunc
+}
+
+func (t *cancellingTransport) SendText(ctx context.Context, chatID, text string, keyboard Keyboard) (string, error) {
+	t.cancel()
+	return "", errUnavailable
+}
+
+func TestSendMailingCancelledSkipsDeadLetters(t *testing.T) {
+	n, transport, store := newTestNotifier()
+
+	for _, chatID := range []string{"a", "b", "c"} {
+		store.addUser(&models.User{ChatID: chatID, Segments: []string{"team"}})
+	}
+	mailing := store.addMailing(&models.Mailing{Name: "cancelled", Message: "text", Segment: "team"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n.transport = &cancellingTransport{MemoryTransport: transport, cancel: cancel}
+
+	result, err := n.SendMailing(ctx, mailing, segmenter.MailingAudience(mailing))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SendMailing() error = %v, want context.Canceled", err)
+	}
+	if result.Sent != 0 {
+		t.Errorf("SendMailing() = %+v, want nothing sent", result)
+	}
+	// отправку прервали сами, повторять её для оставшихся получателей не нужно
+	if letters := store.letters(); len(letters) != 0 {
+		t.Errorf("dead letters = %+v, want none", letters)
+	}
+}
+
+func TestRedriveCancelledMailing(t *testing.T) {
+	n, transport, store := newTestNotifier()
+	ctx := context.Background()
+
+	user := store.addUser(&models.User{ChatID: "user", Segments: []string{"team"}})
+	cancelled := store.addMailing(&models.Mailing{Name: "cancelled", Message: "text", Segment: "team", Status: models.MailingCancelled})
+
+	// отменённая и удалённая рассылки
+	for _, mailingID := range []primitive.ObjectID{cancelled.ID, primitive.NewObjectID()} {
+		letter := &models.DeadLetter{MailingID: mailingID, UserID: user.ID, ChatID: user.ChatID, Text: "text"}
+		if err := store.CreateDeadLetter(ctx, letter); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := n.Redrive(ctx, letter); !errors.Is(err, ErrMailingCancelled) {
+			t.Errorf("Redrive() error = %v, want ErrMailingCancelled", err)
+		}
+		if letters := store.letters(); len(letters) != 0 {
+			t.Errorf("dead letters = %+v, want deleted", letters)
+		}
+	}
+	if messages := transport.Messages(); len(messages) != 0 {
+		t.Errorf("messages = %+v, want none", messages)
+	}
+}
+
+func TestSendMailingOptedOut(t *testing.T) {
+	n, transport, store := newTestNotifier()
+	ctx := context.Background()
+
+	store.addUser(&models.User{ChatID: "subscribed", Segments: []string{"all"}})
+	store.addUser(&models.User{ChatID: "opted-out", Segments: []string{"all"}, OptedOut: true})
+
+	news := store.addMailing(&models.Mailing{Name: "news", Message: "новости", Segment: "all"})
+	if result, err := n.SendMailing(ctx, news, segmenter.MailingAudience(news)); err != nil || result.Total != 1 {
+		t.Fatalf("SendMailing(news) = %+v, %v, want one recipient", result, err)
+	}
+	if messages := transport.MessagesTo("opted-out"); len(messages) != 0 {
+		t.Errorf("messages to opted out user = %+v, want none", messages)
+	}
+
+	// обязательные уведомления приходят всем
+	notice := store.addMailing(&models.Mailing{Name: "notice", Message: "важно", Segment: "all", Mandatory: true})
+	if result, err := n.SendMailing(ctx, notice, segmenter.MailingAudience(notice)); err != nil || result.Total != 2 {
+		t.Fatalf("SendMailing(notice) = %+v, %v, want two recipients", result, err)
+	}
+	messages := transport.MessagesTo("opted-out")
+	if len(messages) != 1 || messages[0].Text != "важно" || len(messages[0].Keyboard) != 0 {
+		t.Errorf("messages to opted out user = %+v, want the notice without unsubscribe button", messages)
+	}
+}
+
+func TestRedriveOptedOut(t *testing.T) {
+	n, transport, store := newTestNotifier()
+	ctx := context.Background()
+
+	// пользователь отписался, пока сообщение лежало в недоставленных
+	user := store.addUser(&models.User{ChatID: "user", Segments: []string{"all"}, OptedOut: true})
+	news := store.addMailing(&models.Mailing{Name: "news", Message: "новости", Segment: "all"})
+	notice := store.addMailing(&models.Mailing{Name: "notice", Message: "важно", Segment: "all", Mandatory: true})
+
+	letter := &models.DeadLetter{MailingID: news.ID, UserID: user.ID, ChatID: user.ChatID, Text: "новости"}
+	if err := store.CreateDeadLetter(ctx, letter); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Redrive(ctx, letter); !errors.Is(err, ErrOptedOut) {
+		t.Errorf("Redrive(news) error = %v, want ErrOptedOut", err)
+	}
+	if letters := store.letters(); len(letters) != 0 {
+		t.Errorf("dead letters = %+v, want deleted", letters)
+	}
+	if messages := transport.Messages(); len(messages) != 0 {
+		t.Errorf("messages = %+v, want none", messages)
+	}
+
+	letter = &models.DeadLetter{MailingID: notice.ID, UserID: user.ID, ChatID: user.ChatID, Text: "важно"}
+	if err := store.CreateDeadLetter(ctx, letter); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Redrive(ctx, letter); err != nil {
+		t.Fatalf("Redrive(notice) error = %v", err)
+	}
+	if messages := transport.MessagesTo("user"); len(messages) != 1 || messages[0].Text != "важно" {
+		t.Errorf("messages = %+v, want the notice", messages)
+	}
+	if letters := store.letters(); len(letters) != 0 {
+		t.Errorf("dead letters = %+v, want deleted", letters)
+	}
+}
+
+func TestSendMailingDeliveryLog(t *testing.T) {
+	n, transport, store := newTestNotifier()
+
+	anna := store.addUser(&models.User{ChatID: "anna", Segments: []string{"team", "clients"}})
+	store.addUser(&models.User{ChatID: "boris", Segments: []string{"team"}})
+	mailing := store.addMailing(&models.Mailing{Name: "log", Message: "{{index .Segments 1}}", Segment: "team", Occurrences: 3})
+
+	result, err := n.SendMailing(context.Background(), mailing, segmenter.MailingAudience(mailing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SendResult{Total: 2, Sent: 1, Failed: 1}); result != want {
+		t.Errorf("SendMailing() = %+v, want %+v", result, want)
+	}
+
+	sent := store.deliveriesTo("anna")
+	if len(sent) != 1 || sent[0].MailingID != mailing.ID || sent[0].UserID != anna.ID || sent[0].Occurrence != 3 ||
+		sent[0].Status != models.DeliverySent || sent[0].CreatedAt.IsZero() {
+		t.Errorf("deliveries to anna = %+v, want one sent for occurrence 3", sent)
+	}
+
+	// у boris один сегмент, и шаблон не может быть подставлен: повторять такую отправку бесполезно
+	failed := store.deliveriesTo("boris")
+	if len(failed) != 1 || failed[0].Status != models.DeliveryFailed || !failed[0].Permanent || failed[0].Error == "" || failed[0].Attempts != 0 {
+		t.Errorf("deliveries to boris = %+v, want one permanent failure without attempts", failed)
+	}
+	if messages := transport.MessagesTo("boris"); len(messages) != 0 {
+		t.Errorf("messages to boris = %+v, want none", messages)
+	}
+}
+
+// concurrentTransport считает, сколько отправок выполняется одновременно
+type concurrentTransport struct {
+	*MemoryTransport
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+}
+
+func (t *concurrentTransport) SendText(ctx context.Context, chatID, text string, keyboard Keyboard) (string, error) {
+	t.mu.Lock()
+	t.active++
+	t.maxSeen = max(t.maxSeen, t.active)
+	t.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	t.mu.Lock()
+	t.active--
+	t.mu.Unlock()
+	return t.MemoryTransport.SendText(ctx, chatID, text, keyboard)
+}
+
+func TestSendMailingWorkers(t *testing.T) {
+	n, transport, store := newTestNotifier()
+	concurrent := &concurrentTransport{MemoryTransport: transport}
+	n.transport = concurrent
+
+	for i := 0; i < 10; i++ {
+		store.addUser(&models.User{ChatID: fmt.Sprintf("user-%d", i), Segments: []string{"team"}})
+	}
+	mailing := store.addMailing(&models.Mailing{Name: "workers", Message: "text", Segment: "team"})
+
+	result, err := n.SendMailing(context.Background(), mailing, segmenter.MailingAudience(mailing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SendResult{Total: 10, Sent: 10}); result != want {
+		t.Errorf("SendMailing() = %+v, want %+v", result, want)
+	}
+	// newTestNotifier запускает два воркера
+	if concurrent.maxSeen != 2 {
+		t.Errorf("max concurrent sends = %d, want 2", concurrent.maxSeen)
+	}
+}
+
+func TestSendMailingSkipsDelivered(t *testing.T) {
+	n, transport, store := newTestNotifier()
+	ctx := context.Background()
+
+	delivered := store.addUser(&models.User{ChatID: "delivered", Segments: []string{"team"}})
+	failedBefore := store.addUser(&models.User{ChatID: "failed-before", Segments: []string{"team"}})
+	store.addUser(&models.User{ChatID: "new", Segments: []string{"team"}})
+	mailing := store.addMailing(&models.Mailing{Name: "resume", Message: "text", Segment: "team", Occurrences: 2})
+
+	// так выглядит отправка, прерванная падением другого экземпляра бота
+	for _, delivery := range []*models.Delivery{
+		{MailingID: mailing.ID, Occurrence: 2, UserID: delivered.ID, ChatID: delivered.ChatID, Status: models.DeliverySent},
+		{MailingID: mailing.ID, Occurrence: 2, UserID: failedBefore.ID, ChatID: failedBefore.ChatID, Status: models.DeliveryFailed},
+		// доставка предыдущей отправки повторяющейся рассылки не в счёт
+		{MailingID: mailing.ID, Occurrence: 1, ChatID: "new", Status: models.DeliverySent},
+	} {
+		if err := store.CreateDelivery(ctx, delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := n.SendMailing(ctx, mailing, segmenter.MailingAudience(mailing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SendResult{Total: 3, Sent: 3}); result != want {
+		t.Errorf("SendMailing() = %+v, want %+v", result, want)
+	}
+
+	if messages := transport.MessagesTo("delivered"); len(messages) != 0 {
+		t.Errorf("messages to delivered = %+v, want none", messages)
+	}
+	if deliveries := store.deliveriesTo("delivered"); len(deliveries) != 1 {
+		t.Errorf("deliveries to delivered = %+v, want only the earlier one", deliveries)
+	}
+	for _, chatID := range []string{"failed-before", "new"} {
+		if messages := transport.MessagesTo(chatID); len(messages) != 1 {
+			t.Errorf("messages to %s = %+v, want one", chatID, messages)
+		}
+	}
+}
+
+func TestMailingContentKeyboard(t *testing.T) {
+	mailing := &models.Mailing{
+		ID:      primitive.NewObjectID(),
+		Message: "text",
+		Buttons: []models.Button{{Text: "Да"}, {Text: "Сайт", URL: "https://example.com"}},
+	}
+
+	content := mailingContent(mailing)
+	want := Keyboard{
+		{{Text: "Да", CallbackData: ButtonCallbackData(mailing.ID, 0)}},
+		{{Text: "Сайт", URL: "https://example.com", CallbackData: ButtonCallbackData(mailing.ID, 1)}},
+		{{Text: "Отписаться", CallbackData: unsubscribeCallbackData}},
+	}
+	if fmt.Sprint(content.Keyboard) != fmt.Sprint(want) {
+		t.Errorf("keyboard = %+v, want %+v", content.Keyboard, want)
+	}
+
+	// от обязательной рассылки отписаться нельзя
+	mailing.Mandatory = true
+	if keyboard := mailingContent(mailing).Keyboard; len(keyboard) != 2 {
+		t.Errorf("mandatory keyboard = %+v, want only mailing buttons", keyboard)
+	}
+}
+
+func TestSendPreview(t *testing.T) {
+	n, transport, store := newTestNotifier()
+	author := &models.User{ChatID: "author", FirstName: "Анна", Segments: []string{"team"}}
+	store.addUser(author)
+	store.addUser(&models.User{ChatID: "member", Segments: []string{"team"}})
+	mailing := store.addMailing(&models.Mailing{
+		Name:    "preview",
+		Message: "Привет, {{.FirstName}}!",
+		FileID:  "file-1",
+		Segment: "team",
+		Buttons: []models.Button{{Text: "Да"}, {Text: "Сайт", URL: "https://example.com"}},
+	})
+
+	if err := n.SendPreview(context.Background(), mailing, author); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := transport.Messages()
+	if len(messages) != 1 || messages[0].ChatID != "author" || messages[0].Text != "Привет, Анна!" || messages[0].FileID != "file-1" {
+		t.Fatalf("messages = %+v, want one personalized preview to author", messages)
+	}
+	// нажатия в тестовой отправке не попадают в статистику рассылки
+	for _, row := range messages[0].Keyboard {
+		for _, button := range row {
+			if button.CallbackData != previewCallbackData {
+				t.Errorf("button %+v has callback %q, want %q", button, button.CallbackData, previewCallbackData)
+			}
+		}
+	}
+	if len(messages[0].Keyboard) != 3 || messages[0].Keyboard[1][0].URL != "https://example.com" {
+		t.Errorf("keyboard = %+v, want mailing buttons and unsubscribe", messages[0].Keyboard)
+	}
+	if deliveries := store.deliveriesTo("author"); len(deliveries) != 0 {
+		t.Errorf("deliveries = %+v, preview must not be recorded", deliveries)
+	}
+	// клавиатура рассылки не должна меняться после тестовой отправки
+	if data := mailingContent(mailing).Keyboard[0][0].CallbackData; data != ButtonCallbackData(mailing.ID, 0) {
+		t.Errorf("mailing button callback = %q after preview", data)
+	}
+}
@@ -0,0 +1,153 @@
+package notifier
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket - ограничитель скорости: rate токенов в секунду, не больше burst подряд
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve забирает токен и возвращает, сколько нужно подождать до его появления
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel возвращает токен, забранный reserve, если отправка так и не состоялась
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// full сообщает, что бакет давно не использовался и его можно удалить
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// rateLimiter ограничивает скорость отправки глобально и для каждого чата отдельно
+type rateLimiter struct {
+	global *tokenBucket
+
+	mu        sync.Mutex
+	chats     map[string]*tokenBucket
+	chatRate  float64
+	chatBurst int
+}
+
+// после такого количества бакетов чатов удаляем неиспользуемые
+const maxIdleChatBuckets = 1000
+
+func newRateLimiter(rate float64, burst int, chatRate float64, chatBurst int) *rateLimiter {
+	l := &rateLimiter{
+		chats:     make(map[string]*tokenBucket),
+		chatRate:  chatRate,
+		chatBurst: chatBurst,
+	}
+	if rate > 0 {
+		l.global = newTokenBucket(rate, burst)
+	}
+	return l
+}
+
+// Wait блокируется, пока отправка в чат не станет разрешена, или пока не отменён ctx.
+// При отмене забранные токены возвращаются, чтобы неотправленное сообщение не тормозило следующие
+func (l *rateLimiter) Wait(ctx context.Context, chatID string) error {
+	now := time.Now()
+	delay := time.Duration(0)
+	if l.global != nil {
+		delay = l.global.reserve(now)
+	}
+	chat := l.chatBucket(chatID, now)
+	if chat != nil {
+		if d := chat.reserve(now); d > delay {
+			delay = d
+		}
+	}
+
+	cancel := func() error {
+		if l.global != nil {
+			l.global.cancel()
+		}
+		if chat != nil {
+			chat.cancel()
+		}
+		return ctx.Err()
+	}
+
+	if delay <= 0 {
+		if ctx.Err() != nil {
+			return cancel()
+		}
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return cancel()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (l *rateLimiter) chatBucket(chatID string, now time.Time) *tokenBucket {
+	if l.chatRate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.chats[chatID]
+	if !ok {
+		if len(l.chats) >= maxIdleChatBuckets {
+			for id, b := range l.chats {
+				if b.full(now) {
+					delete(l.chats, id)
+				}
+			}
+		}
+		bucket = newTokenBucket(l.chatRate, l.chatBurst)
+		l.chats[chatID] = bucket
+	}
+	return bucket
+}
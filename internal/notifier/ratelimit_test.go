@@ -0,0 +1,97 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(10, 2)
+	bucket.last = now
+
+	// всплеск проходит сразу, дальше по токену в 100ms
+	for i := 0; i < 2; i++ {
+		if delay := bucket.reserve(now); delay != 0 {
+			t.Fatalf("reserve %d: delay = %v, want 0", i, delay)
+		}
+	}
+	if delay := bucket.reserve(now); delay != 100*time.Millisecond {
+		t.Errorf("delay = %v, want 100ms", delay)
+	}
+	if delay := bucket.reserve(now); delay != 200*time.Millisecond {
+		t.Errorf("delay = %v, want 200ms", delay)
+	}
+
+	// через секунду бакет снова полон, но не больше burst
+	later := now.Add(time.Second)
+	if !bucket.full(later) {
+		t.Error("bucket is not full after a second")
+	}
+	for i := 0; i < 2; i++ {
+		if delay := bucket.reserve(later); delay != 0 {
+			t.Fatalf("reserve %d after refill: delay = %v, want 0", i, delay)
+		}
+	}
+	if delay := bucket.reserve(later); delay == 0 {
+		t.Error("reserve over burst after refill has no delay")
+	}
+}
+
+func TestRateLimiterPerChat(t *testing.T) {
+	limiter := newRateLimiter(0, 0, 1, 1)
+
+	if err := limiter.Wait(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	// второе сообщение в тот же чат ждёт секунду, в другой чат уходит сразу
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait(a) error = %v, want context.DeadlineExceeded", err)
+	}
+	if err := limiter.Wait(context.Background(), "b"); err != nil {
+		t.Errorf("Wait(b) error = %v", err)
+	}
+}
+
+func TestRateLimiterReturnsTokenOnCancel(t *testing.T) {
+	limiter := newRateLimiter(1, 1, 1, 1)
+
+	if err := limiter.Wait(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := limiter.Wait(ctx, "a")
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Wait() error = %v, want context.DeadlineExceeded", err)
+		}
+	}
+
+	// отменённые ожидания не должны отодвигать следующую отправку
+	now := time.Now()
+	if delay := limiter.global.reserve(now); delay > time.Second {
+		t.Errorf("global delay after cancelled waits = %v, want at most 1s", delay)
+	}
+	if delay := limiter.chatBucket("a", now).reserve(now); delay > time.Second {
+		t.Errorf("chat delay after cancelled waits = %v, want at most 1s", delay)
+	}
+}
+
+func TestRateLimiterCancelledContext(t *testing.T) {
+	limiter := newRateLimiter(1, 1, 0, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := limiter.Wait(ctx, "a"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() error = %v, want context.Canceled", err)
+	}
+	// токен не потрачен, и отправка проходит без ожидания
+	if delay := limiter.global.reserve(time.Now()); delay != 0 {
+		t.Errorf("delay = %v, want 0", delay)
+	}
+}
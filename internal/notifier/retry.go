@@ -0,0 +1,75 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// RetryPolicy - повтор отправки при временных ошибках с экспоненциальной задержкой
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// delay возвращает задержку перед попыткой attempt (начиная с 1) со случайным разбросом
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	// случайная задержка от d/2 до d, чтобы воркеры не повторяли запросы одновременно
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// isTransient сообщает, имеет ли смысл повторять отправку.
+// bot-golang не оборачивает ошибки, поэтому разбираем их текст
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	// отправку прервали сами: рассылку отменили или потеряли её аренду, повторять нечего
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	text := err.Error()
+	switch {
+	case strings.Contains(text, "cannot make request to bot api"),
+		strings.Contains(text, "cannot read body"):
+		// сетевые ошибки и обрыв соединения
+		return true
+	case strings.Contains(text, "error status from API: 5"),
+		strings.Contains(text, "error status from API: 429"):
+		// ошибки сервера и превышение лимитов
+		return true
+	}
+	return false
+}
+
+// sendWithRetry повторяет send, пока ошибка временная и не исчерпаны попытки.
+// Возвращает количество сделанных попыток
+func (n *Notifier) sendWithRetry(ctx context.Context, send func() error) (int, error) {
+	var err error
+	attempt := 0
+	for attempt < n.retry.MaxAttempts {
+		attempt++
+		if err = send(); err == nil || !isTransient(err) {
+			return attempt, err
+		}
+		if attempt == n.retry.MaxAttempts {
+			break
+		}
+
+		timer := time.NewTimer(n.retry.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+	}
+	return attempt, err
+}
@@ -0,0 +1,109 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("cannot make request to bot api: dial tcp: i/o timeout"), true},
+		{errors.New("cannot read body: unexpected EOF"), true},
+		{errors.New("error status from API: 502 Bad Gateway"), true},
+		{errors.New("error status from API: 429 Too Many Requests"), true},
+		{errors.New("error status from API: 403 Forbidden"), false},
+		{errors.New("error status from API: 400 Bad Request"), false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{fmt.Errorf("wait: %w", context.Canceled), false},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 1; attempt <= 10; attempt++ {
+		want := policy.BaseDelay << (attempt - 1)
+		if want > policy.MaxDelay {
+			want = policy.MaxDelay
+		}
+		if got := policy.delay(attempt); got < want/2 || got > want {
+			t.Errorf("delay(%d) = %v, want between %v and %v", attempt, got, want/2, want)
+		}
+	}
+}
+
+func newRetryNotifier(maxAttempts int) *Notifier {
+	return NewNotifier(NewMemoryTransport(), nil, nil, Options{
+		Retry: RetryPolicy{MaxAttempts: maxAttempts, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestSendWithRetry(t *testing.T) {
+	transient := errors.New("error status from API: 503 Service Unavailable")
+	permanent := errors.New("error status from API: 403 Forbidden")
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{"success", nil, 1, nil},
+		{"transient then success", []error{transient, transient}, 3, nil},
+		{"transient until the end", []error{transient, transient, transient, transient}, 3, transient},
+		{"permanent", []error{permanent}, 1, permanent},
+		{"transient then permanent", []error{transient, permanent}, 2, permanent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newRetryNotifier(3)
+			calls := 0
+			attempts, err := n.sendWithRetry(context.Background(), func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if attempts != tt.wantAttempts || calls != tt.wantAttempts {
+				t.Errorf("attempts = %d, calls = %d, want %d", attempts, calls, tt.wantAttempts)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendWithRetryStopsOnCancel(t *testing.T) {
+	n := newRetryNotifier(5)
+	n.retry.BaseDelay, n.retry.MaxDelay = time.Hour, time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	attempts, err := n.sendWithRetry(ctx, func() error {
+		calls++
+		cancel()
+		return errors.New("cannot make request to bot api: connection reset")
+	})
+	if attempts != 1 || calls != 1 {
+		t.Errorf("attempts = %d, calls = %d, want 1", attempts, calls)
+	}
+	if err == nil {
+		t.Error("want error")
+	}
+}
@@ -0,0 +1,63 @@
+package notifier
+
+import (
+	"context"
+
+	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Store - данные, с которыми работает Notifier при отправке рассылок
+type Store interface {
+	// Audience возвращает получателей рассылки
+	Audience(ctx context.Context, audience segmenter.Audience) ([]*models.User, error)
+	// SentChatIDs возвращает чаты, которым отправка occurrence рассылки уже доставлена
+	SentChatIDs(ctx context.Context, mailingID primitive.ObjectID, occurrence int) (map[string]bool, error)
+	CreateDelivery(ctx context.Context, delivery *models.Delivery) error
+	// GetMailing возвращает mongo.ErrNoDocuments, если рассылки нет
+	GetMailing(ctx context.Context, id primitive.ObjectID) (*models.Mailing, error)
+	GetUserByChatID(ctx context.Context, chatID string) (*models.User, error)
+	CreateDeadLetter(ctx context.Context, letter *models.DeadLetter) error
+	UpdateDeadLetter(ctx context.Context, letter *models.DeadLetter) error
+	DeleteDeadLetter(ctx context.Context, id primitive.ObjectID) error
+}
+
+// mongoStore - Store поверх репозиториев MongoDB
+type mongoStore struct {
+	db        *database.Database
+	segmenter *segmenter.Segmenter
+}
+
+func (s *mongoStore) Audience(ctx context.Context, audience segmenter.Audience) ([]*models.User, error) {
+	return s.segmenter.GetAudience(ctx, audience)
+}
+
+func (s *mongoStore) SentChatIDs(ctx context.Context, mailingID primitive.ObjectID, occurrence int) (map[string]bool, error) {
+	return database.NewDeliveryRepository(s.db).SentChatIDs(ctx, mailingID, occurrence)
+}
+
+func (s *mongoStore) CreateDelivery(ctx context.Context, delivery *models.Delivery) error {
+	return database.NewDeliveryRepository(s.db).Create(ctx, delivery)
+}
+
+func (s *mongoStore) GetMailing(ctx context.Context, id primitive.ObjectID) (*models.Mailing, error) {
+	return database.NewMailingRepository(s.db).GetByID(ctx, id)
+}
+
+func (s *mongoStore) GetUserByChatID(ctx context.Context, chatID string) (*models.User, error) {
+	return database.NewUserRepository(s.db).GetByChatID(ctx, chatID)
+}
+
+func (s *mongoStore) CreateDeadLetter(ctx context.Context, letter *models.DeadLetter) error {
+	return database.NewDeadLetterRepository(s.db).Create(ctx, letter)
+}
+
+func (s *mongoStore) UpdateDeadLetter(ctx context.Context, letter *models.DeadLetter) error {
+	return database.NewDeadLetterRepository(s.db).Update(ctx, letter)
+}
+
+func (s *mongoStore) DeleteDeadLetter(ctx context.Context, id primitive.ObjectID) error {
+	return database.NewDeadLetterRepository(s.db).Delete(ctx, id)
+}
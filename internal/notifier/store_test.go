@@ -0,0 +1,187 @@
+package notifier
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/internal/utils"
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memStore - Store в памяти для тестов. Сегменты только обычные: пользователь входит
+// в сегмент, если он указан в User.Segments
+type memStore struct {
+	mu          sync.Mutex
+	users       []*models.User
+	mailings    map[primitive.ObjectID]*models.Mailing
+	deliveries  []*models.Delivery
+	deadLetters map[primitive.ObjectID]*models.DeadLetter
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		mailings:    make(map[primitive.ObjectID]*models.Mailing),
+		deadLetters: make(map[primitive.ObjectID]*models.DeadLetter),
+	}
+}
+
+// newTestNotifier возвращает Notifier с MemoryTransport и хранилищем в памяти
+func newTestNotifier() (*Notifier, *MemoryTransport, *memStore) {
+	transport := NewMemoryTransport()
+	store := newMemStore()
+	n := NewNotifier(transport, nil, nil, Options{
+		Workers: 2,
+		Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	n.store = store
+	return n, transport, store
+}
+
+func (s *memStore) addUser(user *models.User) *models.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user.ID = primitive.NewObjectID()
+	s.users = append(s.users, user)
+	return user
+}
+
+func (s *memStore) addMailing(mailing *models.Mailing) *models.Mailing {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mailing.ID = primitive.NewObjectID()
+	s.mailings[mailing.ID] = mailing
+	return mailing
+}
+
+// deliveriesTo возвращает записи журнала доставки в чат
+func (s *memStore) deliveriesTo(chatID string) []models.Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deliveries []models.Delivery
+	for _, delivery := range s.deliveries {
+		if delivery.ChatID == chatID {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+	return deliveries
+}
+
+func (s *memStore) letters() []models.DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	letters := make([]models.DeadLetter, 0, len(s.deadLetters))
+	for _, letter := range s.deadLetters {
+		letters = append(letters, *letter)
+	}
+	return letters
+}
+
+func (s *memStore) Audience(ctx context.Context, audience segmenter.Audience) ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inAny := func(user *models.User, segments []string) bool {
+		for _, segment := range segments {
+			if slices.Contains(user.Segments, segment) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var users []*models.User
+	for _, user := range s.users {
+		if !inAny(user, audience.Include) || inAny(user, audience.Exclude) {
+			continue
+		}
+		if user.OptedOut && !audience.Mandatory {
+			continue
+		}
+		timezone := user.Timezone
+		if timezone == "" {
+			timezone = utils.DefaultTimezone
+		}
+		if len(audience.Timezones) > 0 && !slices.Contains(audience.Timezones, timezone) {
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (s *memStore) SentChatIDs(ctx context.Context, mailingID primitive.ObjectID, occurrence int) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chatIDs := make(map[string]bool)
+	for _, delivery := range s.deliveries {
+		if delivery.MailingID == mailingID && delivery.Occurrence == occurrence && delivery.Status == models.DeliverySent {
+			chatIDs[delivery.ChatID] = true
+		}
+	}
+	return chatIDs, nil
+}
+
+func (s *memStore) CreateDelivery(ctx context.Context, delivery *models.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivery.CreatedAt = time.Now().UTC()
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+func (s *memStore) GetMailing(ctx context.Context, id primitive.ObjectID) (*models.Mailing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mailing, ok := s.mailings[id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return mailing, nil
+}
+
+func (s *memStore) GetUserByChatID(ctx context.Context, chatID string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.ChatID == chatID {
+			return user, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (s *memStore) CreateDeadLetter(ctx context.Context, letter *models.DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if letter.ID.IsZero() {
+		letter.ID = primitive.NewObjectID()
+	}
+	letter.CreatedAt = time.Now().UTC()
+	letter.UpdatedAt = letter.CreatedAt
+	s.deadLetters[letter.ID] = letter
+	return nil
+}
+
+func (s *memStore) UpdateDeadLetter(ctx context.Context, letter *models.DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.deadLetters[letter.ID]; !ok {
+		return mongo.ErrNoDocuments
+	}
+	letter.UpdatedAt = time.Now().UTC()
+	s.deadLetters[letter.ID] = letter
+	return nil
+}
+
+func (s *memStore) DeleteDeadLetter(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deadLetters, id)
+	return nil
+}
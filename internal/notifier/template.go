@@ -0,0 +1,116 @@
+package notifier
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+// TemplateData - данные получателя, доступные в шаблоне сообщения:
+// {{.FirstName}}, {{.LastName}}, {{.Segment}}, {{.Segments}}, {{.Attr "department"}}
+type TemplateData struct {
+	FirstName  string
+	LastName   string
+	ChatID     string
+	Segment    string   // сегмент рассылки, через который пользователь её получает
+	Segments   []string // сегменты получателя
+	Attributes map[string]string
+}
+
+// Attr возвращает произвольное поле пользователя или пустую строку
+func (d TemplateData) Attr(key string) string {
+	return d.Attributes[key]
+}
+
+func newTemplateData(user *models.User, segment string) TemplateData {
+	return TemplateData{
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		ChatID:     user.ChatID,
+		Segment:    segment,
+		Segments:   user.Segments,
+		Attributes: user.Attributes,
+	}
+}
+
+// recipientSegment выбирает для {{.Segment}} сегмент рассылки, в котором состоит получатель.
+// Членство в динамических сегментах не хранится у пользователя, для них берётся первый сегмент рассылки
+func recipientSegment(mailing *models.Mailing, user *models.User) string {
+	included := mailing.IncludedSegments()
+	for _, segment := range included {
+		for _, userSegment := range user.Segments {
+			if segment == userSegment {
+				return segment
+			}
+		}
+	}
+	if len(included) > 0 {
+		return included[0]
+	}
+	return ""
+}
+
+// messageTemplate - текст сообщения, который подставляет данные получателя.
+// Текст без {{ отправляется как есть
+type messageTemplate struct {
+	text string
+	tmpl *template.Template
+}
+
+func parseMessageTemplate(text string) (*messageTemplate, error) {
+	t := &messageTemplate{text: text}
+	if !strings.Contains(text, "{{") {
+		return t, nil
+	}
+
+	tmpl, err := template.New("message").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	t.tmpl = tmpl
+	return t, nil
+}
+
+func (t *messageTemplate) render(data TemplateData) (string, error) {
+	if t.tmpl == nil {
+		return t.text, nil
+	}
+
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// ValidateTemplate проверяет шаблон сообщения: синтаксис и обращения только к известным полям
+func ValidateTemplate(text string) error {
+	t, err := parseMessageTemplate(text)
+	if err != nil {
+		return err
+	}
+
+	sample := TemplateData{
+		FirstName:  "Иван",
+		LastName:   "Иванов",
+		ChatID:     "sample@example.com",
+		Segment:    "all",
+		Segments:   []string{"all"},
+		Attributes: map[string]string{},
+	}
+	if _, err := t.render(sample); err != nil {
+		return fmt.Errorf("template: %w", err)
+	}
+	return nil
+}
+
+// RenderMessage подставляет в текст рассылки данные получателя
+func RenderMessage(text string, user *models.User, segment string) (string, error) {
+	t, err := parseMessageTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	return t.render(newTemplateData(user, segment))
+}
@@ -0,0 +1,94 @@
+package notifier
+
+import (
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+func TestRenderMessage(t *testing.T) {
+	user := &models.User{
+		ChatID:     "anna@example.com",
+		FirstName:  "Анна",
+		LastName:   "Петрова",
+		Segments:   []string{"team", "moscow"},
+		Attributes: map[string]string{"department": "продажи"},
+	}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Без подстановок", "Без подстановок"},
+		// текст без {{ не разбирается как шаблон
+		{"Скидка 50% }}", "Скидка 50% }}"},
+		{"Привет, {{.FirstName}} {{.LastName}}!", "Привет, Анна Петрова!"},
+		{"Сегмент {{.Segment}}", "Сегмент team"},
+		{"Отдел: {{.Attr \"department\"}}", "Отдел: продажи"},
+		{"Город: {{.Attr \"city\"}}.", "Город: ."},
+		{"{{range .Segments}}[{{.}}]{{end}}", "[team][moscow]"},
+		{"{{if .FirstName}}{{.FirstName}}{{else}}коллега{{end}}", "Анна"},
+	}
+	for _, tt := range tests {
+		got, err := RenderMessage(tt.text, user, "team")
+		if err != nil {
+			t.Errorf("RenderMessage(%q) error: %v", tt.text, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("RenderMessage(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	// пустые поля пользователя не ломают подстановку
+	got, err := RenderMessage("{{if .FirstName}}{{.FirstName}}{{else}}коллега{{end}}, {{.Attr \"x\"}}", &models.User{}, "")
+	if err != nil || got != "коллега, " {
+		t.Errorf("RenderMessage() for empty user = %q, %v", got, err)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	for _, text := range []string{
+		"Привет",
+		"Привет, {{.FirstName}}",
+		"{{.Attr \"department\"}} {{.Segment}} {{.ChatID}}",
+	} {
+		if err := ValidateTemplate(text); err != nil {
+			t.Errorf("ValidateTemplate(%q) error: %v", text, err)
+		}
+	}
+
+	for _, text := range []string{
+		"Привет, {{.FirstName",
+		"{{.Unknown}}",
+		"{{.Attr}}",
+		"{{end}}",
+	} {
+		if err := ValidateTemplate(text); err == nil {
+			t.Errorf("ValidateTemplate(%q) succeeded, want error", text)
+		}
+	}
+}
+
+func TestRecipientSegment(t *testing.T) {
+	mailing := &models.Mailing{Segments: []string{"clients", "team"}}
+	tests := []struct {
+		segments []string
+		want     string
+	}{
+		{[]string{"team"}, "team"},
+		{[]string{"team", "clients"}, "clients"},
+		// участник динамического сегмента получает первый сегмент рассылки
+		{nil, "clients"},
+	}
+	for _, tt := range tests {
+		if got := recipientSegment(mailing, &models.User{Segments: tt.segments}); got != tt.want {
+			t.Errorf("recipientSegment(%v) = %q, want %q", tt.segments, got, tt.want)
+		}
+	}
+
+	// рассылка, созданная до появления нескольких сегментов
+	if got := recipientSegment(&models.Mailing{Segment: "all"}, &models.User{}); got != "all" {
+		t.Errorf("recipientSegment() for legacy mailing = %q, want all", got)
+	}
+}
@@ -0,0 +1,275 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/internal/logging"
+	"github.com/g0shi4ek/VK_bot/internal/metrics"
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+// KeyboardButton - кнопка под сообщением: ссылка или кнопка с данными для callback
+type KeyboardButton struct {
+	Text         string
+	URL          string
+	CallbackData string
+}
+
+// Keyboard - строки кнопок под сообщением, nil - без клавиатуры
+type Keyboard [][]KeyboardButton
+
+// Transport - канал доставки сообщений в мессенджер
+type Transport interface {
+	// SendText отправляет текст и возвращает id сообщения
+	SendText(ctx context.Context, chatID, text string, keyboard Keyboard) (string, error)
+	// SendFile отправляет ранее загруженный файл с подписью и возвращает id сообщения
+	SendFile(ctx context.Context, chatID, fileID, caption string, keyboard Keyboard) (string, error)
+	EditText(ctx context.Context, chatID, messageID, text string) error
+	Delete(ctx context.Context, chatID, messageID string) error
+}
+
+// BotTransport отправляет сообщения через Bot API VK Teams
+type BotTransport struct {
+	bot *botgolang.Bot
+}
+
+func NewBotTransport(bot *botgolang.Bot) *BotTransport {
+	return &BotTransport{bot: bot}
+}
+
+func (t *BotTransport) SendText(ctx context.Context, chatID, text string, keyboard Keyboard) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	message := t.bot.NewTextMessage(chatID, text)
+	attachKeyboard(message, keyboard)
+	start := time.Now()
+	err := message.Send()
+	metrics.ObserveBotAPI("messages/sendText", start, err)
+	if err != nil {
+		return "", botError(err)
+	}
+	return message.ID, nil
+}
+
+func (t *BotTransport) SendFile(ctx context.Context, chatID, fileID, caption string, keyboard Keyboard) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	message := t.bot.NewFileMessageByFileID(chatID, fileID)
+	message.Text = caption
+	attachKeyboard(message, keyboard)
+	start := time.Now()
+	err := message.Send()
+	metrics.ObserveBotAPI("messages/sendFile", start, err)
+	if err != nil {
+		return "", botError(err)
+	}
+	return message.ID, nil
+}
+
+func (t *BotTransport) EditText(ctx context.Context, chatID, messageID, text string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	message := t.bot.NewTextMessage(chatID, text)
+	message.ID = messageID
+	start := time.Now()
+	err := message.Edit()
+	metrics.ObserveBotAPI("messages/editText", start, err)
+	return botError(err)
+}
+
+func (t *BotTransport) Delete(ctx context.Context, chatID, messageID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	message := t.bot.NewMessage(chatID)
+	message.ID = messageID
+	start := time.Now()
+	err := message.Delete()
+	metrics.ObserveBotAPI("messages/deleteMessages", start, err)
+	return botError(err)
+}
+
+// botError убирает из ошибки bot-golang адрес запроса с параметрами: в них токен бота,
+// chat id и текст сообщения, а ошибка попадает в журнал доставки и в недоставленные
+func botError(err error) error {
+	if err == nil {
+		return nil
+	}
+	text := logging.ScrubURLs(err.Error())
+	if text == err.Error() {
+		return err
+	}
+	return errors.New(text)
+}
+
+func attachKeyboard(message *botgolang.Message, keyboard Keyboard) {
+	if len(keyboard) == 0 {
+		return
+	}
+
+	markup := botgolang.NewKeyboard()
+	for _, row := range keyboard {
+		buttons := make([]botgolang.Button, 0, len(row))
+		for _, button := range row {
+			if button.URL != "" {
+				buttons = append(buttons, botgolang.NewURLButton(button.Text, button.URL))
+			} else {
+				buttons = append(buttons, botgolang.NewCallbackButton(button.Text, button.CallbackData))
+			}
+		}
+		markup.AddRow(buttons...)
+	}
+	message.AttachInlineKeyboard(markup)
+}
+
+// RecordedMessage - сообщение, сохранённое MemoryTransport
+type RecordedMessage struct {
+	ID       string
+	ChatID   string
+	Text     string
+	FileID   string
+	Keyboard Keyboard
+	Deleted  bool
+	SentAt   time.Time
+}
+
+// MemoryTransport ничего не отправляет, а запоминает сообщения.
+// Используется в тестах и для запуска без доступа к VK Teams
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []*RecordedMessage
+	failures map[string]*chatFailure
+	nextID   int
+}
+
+// chatFailure - ошибка отправок в чат и сколько раз её вернуть, 0 - всегда
+type chatFailure struct {
+	err   error
+	times int
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		failures: make(map[string]*chatFailure),
+	}
+}
+
+// FailChat заставляет все отправки в чат завершаться ошибкой err, nil снимает ошибку
+func (t *MemoryTransport) FailChat(chatID string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		delete(t.failures, chatID)
+		return
+	}
+	t.failures[chatID] = &chatFailure{err: err}
+}
+
+// FailChatTimes заставляет следующие times отправок в чат завершиться ошибкой err
+func (t *MemoryTransport) FailChatTimes(chatID string, err error, times int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if times < 1 {
+		delete(t.failures, chatID)
+		return
+	}
+	t.failures[chatID] = &chatFailure{err: err, times: times}
+}
+
+// Messages возвращает копию всех отправленных сообщений
+func (t *MemoryTransport) Messages() []RecordedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	messages := make([]RecordedMessage, 0, len(t.messages))
+	for _, message := range t.messages {
+		messages = append(messages, *message)
+	}
+	return messages
+}
+
+// MessagesTo возвращает сообщения, отправленные в чат
+func (t *MemoryTransport) MessagesTo(chatID string) []RecordedMessage {
+	var messages []RecordedMessage
+	for _, message := range t.Messages() {
+		if message.ChatID == chatID {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = nil
+}
+
+func (t *MemoryTransport) SendText(ctx context.Context, chatID, text string, keyboard Keyboard) (string, error) {
+	return t.record(ctx, &RecordedMessage{ChatID: chatID, Text: text, Keyboard: keyboard})
+}
+
+func (t *MemoryTransport) SendFile(ctx context.Context, chatID, fileID, caption string, keyboard Keyboard) (string, error) {
+	return t.record(ctx, &RecordedMessage{ChatID: chatID, Text: caption, FileID: fileID, Keyboard: keyboard})
+}
+
+func (t *MemoryTransport) EditText(ctx context.Context, chatID, messageID, text string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	message, err := t.find(chatID, messageID)
+	if err != nil {
+		return err
+	}
+	message.Text = text
+	return nil
+}
+
+func (t *MemoryTransport) Delete(ctx context.Context, chatID, messageID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	message, err := t.find(chatID, messageID)
+	if err != nil {
+		return err
+	}
+	message.Deleted = true
+	return nil
+}
+
+func (t *MemoryTransport) record(ctx context.Context, message *RecordedMessage) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if failure := t.failures[message.ChatID]; failure != nil {
+		if failure.times == 1 {
+			delete(t.failures, message.ChatID)
+		} else if failure.times > 1 {
+			failure.times--
+		}
+		return "", failure.err
+	}
+
+	t.nextID++
+	message.ID = strconv.Itoa(t.nextID)
+	message.SentAt = time.Now()
+	t.messages = append(t.messages, message)
+	return message.ID, nil
+}
+
+func (t *MemoryTransport) find(chatID, messageID string) (*RecordedMessage, error) {
+	for _, message := range t.messages {
+		if message.ChatID == chatID && message.ID == messageID {
+			return message, nil
+		}
+	}
+	return nil, fmt.Errorf("message %s not found in chat %s", messageID, chatID)
+}
@@ -0,0 +1,212 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/internal/notifier"
+	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFirstWave(t *testing.T) {
+	tests := []struct {
+		name     string
+		at       time.Time
+		timezone string
+		want     time.Time
+	}{
+		{
+			// 10:00 по Москве наступит раньше всего в UTC+14, за 11 часов до Москвы
+			name:     "moscow",
+			at:       time.Date(2026, 3, 10, 7, 0, 0, 0, time.UTC),
+			timezone: "Europe/Moscow",
+			want:     time.Date(2026, 3, 9, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "default timezone",
+			at:       time.Date(2026, 3, 10, 7, 0, 0, 0, time.UTC),
+			timezone: "",
+			want:     time.Date(2026, 3, 9, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "vladivostok",
+			at:       time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC),
+			timezone: "Asia/Vladivostok",
+			want:     time.Date(2026, 3, 9, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			// летом в Берлине UTC+2
+			name:     "berlin summer time",
+			at:       time.Date(2026, 7, 1, 7, 0, 0, 0, time.UTC),
+			timezone: "Europe/Berlin",
+			want:     time.Date(2026, 6, 30, 19, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "earliest timezone",
+			at:       time.Date(2026, 3, 9, 20, 0, 0, 0, time.UTC),
+			timezone: "Etc/GMT-14",
+			want:     time.Date(2026, 3, 9, 20, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FirstWave(tt.at, tt.timezone)
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("FirstWave(%v, %q) = %v, want %v", tt.at, tt.timezone, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeTimezones - часовые пояса участников сегментов
+type fakeTimezones map[string][]string
+
+func (f fakeTimezones) AudienceTimezones(ctx context.Context, audience segmenter.Audience) ([]string, error) {
+	if f == nil {
+		return nil, errors.New("segments unavailable")
+	}
+	var timezones []string
+	for _, segment := range audience.Include {
+		for _, timezone := range f[segment] {
+			if !slices.Contains(timezones, timezone) {
+				timezones = append(timezones, timezone)
+			}
+		}
+	}
+	return timezones, nil
+}
+
+func TestDueTimezones(t *testing.T) {
+	timezones := fakeTimezones{"team": {"Asia/Vladivostok", "Europe/Moscow", "Europe/Kaliningrad"}, "clients": {"America/New_York"}}
+	s := &Scheduler{segmenter: timezones, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	ctx := context.Background()
+
+	// 10:00 по Москве: во Владивостоке это 00:00 UTC, в Москве 07:00 UTC, в Калининграде 08:00 UTC
+	occurrence := time.Date(2026, 3, 10, 7, 0, 0, 0, time.UTC)
+	utc := func(hour, minute int) time.Time {
+		return time.Date(2026, 3, 10, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		sentZones []string
+		now       time.Time
+		wantDue   []string
+		wantNext  time.Time
+	}{
+		{
+			name:     "before first wave",
+			now:      utc(0, 0).Add(-time.Minute),
+			wantNext: utc(0, 0),
+		},
+		{
+			name:     "first wave",
+			now:      utc(0, 0),
+			wantDue:  []string{"Asia/Vladivostok"},
+			wantNext: utc(7, 0),
+		},
+		{
+			// проверка опоздала, и время наступило сразу в двух поясах
+			name:      "late check",
+			sentZones: []string{"Asia/Vladivostok"},
+			now:       utc(8, 30),
+			wantDue:   []string{"Europe/Kaliningrad", "Europe/Moscow"},
+		},
+		{
+			name:      "second wave",
+			sentZones: []string{"Asia/Vladivostok"},
+			now:       utc(7, 0),
+			wantDue:   []string{"Europe/Moscow"},
+			wantNext:  utc(8, 0),
+		},
+		{
+			name:      "last wave",
+			sentZones: []string{"Asia/Vladivostok", "Europe/Moscow"},
+			now:       utc(8, 0),
+			wantDue:   []string{"Europe/Kaliningrad"},
+		},
+		{
+			name:      "all sent",
+			sentZones: []string{"Asia/Vladivostok", "Europe/Moscow", "Europe/Kaliningrad"},
+			now:       utc(9, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailing := &models.Mailing{
+				ID:           primitive.NewObjectID(),
+				Segment:      "team",
+				LocalTime:    true,
+				Timezone:     "Europe/Moscow",
+				ScheduledAt:  FirstWave(occurrence, "Europe/Moscow"),
+				OccurrenceAt: &occurrence,
+				SentZones:    tt.sentZones,
+			}
+
+			due, next, err := s.dueTimezones(ctx, mailing, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(due)
+			if !slices.Equal(due, tt.wantDue) {
+				t.Errorf("due = %v, want %v", due, tt.wantDue)
+			}
+			if !next.Equal(tt.wantNext) {
+				t.Errorf("next = %v, want %v", next, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestDueTimezonesError(t *testing.T) {
+	s := &Scheduler{segmenter: fakeTimezones(nil), logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	mailing := &models.Mailing{ID: primitive.NewObjectID(), Segment: "team", LocalTime: true, ScheduledAt: time.Now()}
+	if _, _, err := s.dueTimezones(context.Background(), mailing, time.Now()); err == nil {
+		t.Error("dueTimezones() succeeded without recipient timezones")
+	}
+}
+
+func TestSendOutcome(t *testing.T) {
+	tests := []struct {
+		result notifier.SendResult
+		err    error
+		want   models.MailingStatus
+	}{
+		{notifier.SendResult{Total: 2, Sent: 2}, nil, models.MailingSent},
+		{notifier.SendResult{Total: 2, Sent: 1, Failed: 1}, nil, models.MailingPartiallyFailed},
+		{notifier.SendResult{Total: 2, Failed: 2}, nil, models.MailingFailed},
+		{notifier.SendResult{}, nil, models.MailingSent},
+		// отправку прервали на середине
+		{notifier.SendResult{Total: 2, Sent: 1}, context.Canceled, models.MailingPartiallyFailed},
+		{notifier.SendResult{Total: 2, Failed: 1}, context.Canceled, models.MailingFailed},
+		// отправка не началась: например, не удалось получить аудиторию, её нужно повторить
+		{notifier.SendResult{}, errors.New("connection refused"), models.MailingScheduled},
+		{notifier.SendResult{Total: 2}, context.Canceled, models.MailingScheduled},
+	}
+	for _, tt := range tests {
+		if got := sendOutcome(tt.result, tt.err); got != tt.want {
+			t.Errorf("sendOutcome(%+v, %v) = %q, want %q", tt.result, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSendRetryDelay(t *testing.T) {
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute,
+		32 * time.Minute, time.Hour, time.Hour, time.Hour, time.Hour}
+	for retries, wantDelay := range want {
+		delay, ok := sendRetryDelay(retries)
+		if !ok || delay != wantDelay {
+			t.Errorf("sendRetryDelay(%d) = %v, %v, want %v", retries, delay, ok, wantDelay)
+		}
+	}
+	if _, ok := sendRetryDelay(maxSendRetries); ok {
+		t.Errorf("sendRetryDelay(%d) allows another retry", maxSendRetries)
+	}
+}
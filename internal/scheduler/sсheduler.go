@@ -2,34 +2,83 @@ package scheduler
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/logging"
+	"github.com/g0shi4ek/VK_bot/internal/metrics"
 	"github.com/g0shi4ek/VK_bot/internal/notifier"
 	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/internal/utils"
+	"github.com/g0shi4ek/VK_bot/models"
 	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// как часто планировщик проверяет наступившие рассылки
+const tickInterval = 30 * time.Second
+
+// через сколько повторить LocalTime-рассылку, если не удалось определить часовые пояса получателей
+const timezonesRetryDelay = time.Minute
+
+// повторы рассылки, отправка которой не началась из-за ошибки: задержка удваивается
+// от sendRetryBaseDelay до sendRetryMaxDelay, после maxSendRetries попыток рассылка считается неудавшейся
+const (
+	sendRetryBaseDelay = time.Minute
+	sendRetryMaxDelay  = time.Hour
+	maxSendRetries     = 10
+)
+
+// сколько проверок подряд можно пропустить, прежде чем планировщик считается неработающим
+const missedTicks = 3
+
 type Scheduler struct {
 	cron      *cron.Cron
 	db        *database.Database
 	notifier  *notifier.Notifier
-	segmenter *segmenter.Segmenter
+	segmenter audienceTimezones
+	logger    *slog.Logger
+	// идентификатор экземпляра бота для аренды рассылок
+	owner string
+	lease time.Duration
+	// время начала последней проверки в UnixNano и идёт ли она сейчас
+	lastTick atomic.Int64
+	ticking  atomic.Bool
+}
+
+// audienceTimezones возвращает часовые пояса получателей рассылки, реализуется segmenter.Segmenter
+type audienceTimezones interface {
+	AudienceTimezones(ctx context.Context, audience segmenter.Audience) ([]string, error)
 }
 
-func NewScheduler(db *database.Database, notifier *notifier.Notifier, segmenter *segmenter.Segmenter) *Scheduler {
+func NewScheduler(db *database.Database, notifier *notifier.Notifier, segmenter *segmenter.Segmenter,
+	lease time.Duration, logger *slog.Logger) *Scheduler {
+	if lease < time.Second {
+		lease = 2 * time.Minute
+	}
+	cronLog := cronLogger{logger}
 	return &Scheduler{
-		cron:      cron.New(),
+		cron:      cron.New(cron.WithLogger(cronLog), cron.WithChain(cron.SkipIfStillRunning(cronLog))),
 		db:        db,
 		notifier:  notifier,
 		segmenter: segmenter,
+		logger:    logger,
+		owner:     instanceID(),
+		lease:     lease,
 	}
 }
 
 func (s *Scheduler) Start() {
+	// до первой проверки отсчитываем интервал от запуска
+	s.lastTick.Store(time.Now().UnixNano())
+
 	// фоновый процесс на отправку отложенных сообщений
-	s.cron.AddFunc("@every 30s", s.processScheduledMailings)
+	s.cron.AddFunc("@every "+tickInterval.String(), s.processScheduledMailings)
 	s.cron.Start()
 }
 
@@ -37,25 +86,329 @@ func (s *Scheduler) Stop() {
 	s.cron.Stop()
 }
 
+// CheckHealth возвращает ошибку, если планировщик не запущен или давно не проверял рассылки.
+// Долгая отправка рассылки не считается ошибкой: следующие проверки пропускаются, пока она идёт
+func (s *Scheduler) CheckHealth(ctx context.Context) error {
+	lastTick := s.lastTick.Load()
+	if lastTick == 0 {
+		return errors.New("scheduler is not started")
+	}
+	if s.ticking.Load() {
+		return nil
+	}
+	if since := time.Since(time.Unix(0, lastTick)); since > missedTicks*tickInterval {
+		return fmt.Errorf("scheduler has not run for %s, expected every %s", since.Truncate(time.Second), tickInterval)
+	}
+	return nil
+}
+
 func (s *Scheduler) processScheduledMailings() {
-	ctx := context.Background()
+	start := time.Now()
+	s.lastTick.Store(start.UnixNano())
+	s.ticking.Store(true)
+	defer func() {
+		s.ticking.Store(false)
+		metrics.SchedulerTickDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	ctx := logging.WithCorrelationID(context.Background(), logging.NewCorrelationID())
 	mailingRepo := database.NewMailingRepository(s.db)
+	s.expireUnapproved(ctx, mailingRepo)
 
-	// Получение сообщений, которые должны быть отправлены сейчас
-	mailings, err := mailingRepo.GetPendingMailings(ctx, time.Now())
-	if err != nil {
-		return
+	// Забираем рассылки по одной, пока есть те, время которых наступило
+	for {
+		mailing, err := mailingRepo.ClaimDue(ctx, time.Now(), s.owner, s.lease)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Cannot claim mailing", "error", err)
+			return
+		}
+		if mailing == nil {
+			return
+		}
+		s.sendClaimed(ctx, mailingRepo, mailing)
 	}
+}
 
-	for _, mailing := range mailings {
-		if err := s.notifier.SendMessageToSegment(mailing.Segment, mailing.Message); err != nil {
-			log.Printf("Cannot send message")
-		} else {
-			mailing.IsSent = true
-			log.Printf("send message")
-			if err := mailingRepo.Update(ctx, mailing); err != nil {
-				continue
+// expireUnapproved не даёт отправить рассылки, которые не подтвердили к дате отправки:
+// они возвращаются в черновик, а автор получает уведомление
+func (s *Scheduler) expireUnapproved(ctx context.Context, mailingRepo *database.MailingRepository) {
+	for {
+		mailing, err := mailingRepo.ExpireUnapproved(ctx, time.Now())
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Cannot expire unapproved mailings", "error", err)
+			return
+		}
+		if mailing == nil {
+			return
+		}
+
+		s.logger.InfoContext(ctx, "Mailing was not approved in time", "mailing_id", mailing.ID.Hex())
+		if mailing.AuthorChatID == "" {
+			continue
+		}
+		s.notifier.SendMessage(ctx, mailing.AuthorChatID, fmt.Sprintf(
+			"⚠️ Рассылка %s не отправлена: её не подтвердили до даты отправки.\n"+
+				"Рассылка возвращена в черновики. Укажите новую дату через /edit_mailing %s, "+
+				"и она снова уйдёт на подтверждение.",
+			mailing.Name, mailing.ID.Hex()))
+	}
+}
+
+// sendClaimed отправляет забранную рассылку, продлевая аренду, пока идёт отправка
+func (s *Scheduler) sendClaimed(ctx context.Context, mailingRepo *database.MailingRepository, mailing *models.Mailing) {
+	// у каждого запуска рассылки свой correlation id, по нему в журнале видна вся отправка
+	ctx = logging.WithCorrelationID(ctx, logging.NewCorrelationID())
+	lag := time.Since(mailing.ScheduledAt)
+	metrics.MailingSendLag.Observe(lag.Seconds())
+	s.logger.InfoContext(ctx, "Sending mailing", "mailing_id", mailing.ID.Hex(),
+		"occurrence", mailing.Occurrences, "lag", lag.Truncate(time.Second))
+
+	sendCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	renewDone := make(chan struct{})
+	go func() {
+		defer close(renewDone)
+		ticker := time.NewTicker(s.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sendCtx.Done():
+				return
+			case <-ticker.C:
+				if err := mailingRepo.RenewLease(sendCtx, mailing.ID, s.owner, s.lease); err != nil {
+					// аренду забрал другой экземпляр, продолжать отправку нельзя
+					s.logger.WarnContext(ctx, "Lost lease on mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+					cancel()
+					return
+				}
 			}
 		}
+	}()
+
+	audience := segmenter.MailingAudience(mailing)
+	var nextWave time.Time
+	if mailing.LocalTime {
+		var err error
+		audience.Timezones, nextWave, err = s.dueTimezones(ctx, mailing, time.Now())
+		if err != nil {
+			// без часовых поясов неизвестно, кому пора отправлять: откладываем рассылку,
+			// иначе она завершилась бы без единой доставки
+			s.logger.ErrorContext(ctx, "Cannot resolve timezones of mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+			cancel()
+			<-renewDone
+			s.reschedule(ctx, mailingRepo, mailing, time.Now().Add(timezonesRetryDelay))
+			return
+		}
+	}
+
+	var (
+		result notifier.SendResult
+		err    error
+	)
+	// у LocalTime-рассылки первая проверка может прийтись на время, когда ни в одном поясе ещё не пора отправлять
+	if !mailing.LocalTime || len(audience.Timezones) > 0 {
+		result, err = s.notifier.SendMailing(sendCtx, mailing, audience)
+	}
+	cancel()
+	<-renewDone
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Cannot send mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+	}
+
+	outcome := sendOutcome(result, err)
+	if outcome == models.MailingScheduled {
+		// ни одному получателю отправить не пытались, например, не удалось прочитать аудиторию
+		if delay, ok := sendRetryDelay(mailing.SendRetries); ok {
+			mailing.SendRetries++
+			s.logger.WarnContext(ctx, "Mailing send did not start, retrying later", "mailing_id", mailing.ID.Hex(),
+				"retries", mailing.SendRetries, "delay", delay)
+			s.reschedule(ctx, mailingRepo, mailing, time.Now().Add(delay))
+			return
+		}
+		outcome = models.MailingFailed
+	}
+	if mailing.LocalTime {
+		mailing.SentZones = append(mailing.SentZones, audience.Timezones...)
+		if !nextWave.IsZero() {
+			// ждём, когда наступит время в следующем часовом поясе
+			s.reschedule(ctx, mailingRepo, mailing, nextWave)
+			return
+		}
+		outcome = s.occurrenceOutcome(ctx, mailing)
+	}
+
+	s.completeOccurrence(ctx, mailing, outcome, time.Now())
+	s.logger.InfoContext(ctx, "Mailing run finished", "mailing_id", mailing.ID.Hex(), "status", mailing.Status)
+	if err := mailingRepo.CompleteClaimed(ctx, mailing, s.owner); err != nil {
+		s.logger.ErrorContext(ctx, "Cannot complete mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+	}
+}
+
+// reschedule возвращает забранную рассылку в очередь на время at и снимает аренду
+func (s *Scheduler) reschedule(ctx context.Context, mailingRepo *database.MailingRepository, mailing *models.Mailing, at time.Time) {
+	mailing.ScheduledAt = at.UTC()
+	if err := mailing.Transition(models.MailingScheduled, time.Now()); err != nil {
+		s.logger.ErrorContext(ctx, "Cannot reschedule mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+	}
+	if err := mailingRepo.CompleteClaimed(ctx, mailing, s.owner); err != nil {
+		s.logger.ErrorContext(ctx, "Cannot complete mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+	}
+}
+
+// dueTimezones возвращает часовые пояса получателей LocalTime-рассылки, в которых время отправки уже наступило,
+// и время ближайшей следующей волны (нулевое, если волн больше нет)
+func (s *Scheduler) dueTimezones(ctx context.Context, mailing *models.Mailing, now time.Time) ([]string, time.Time, error) {
+	timezones, err := s.segmenter.AudienceTimezones(ctx, segmenter.MailingAudience(mailing))
+	if err != nil {
+		return nil, time.Time{}, err
 	}
+
+	sent := make(map[string]bool, len(mailing.SentZones))
+	for _, timezone := range mailing.SentZones {
+		sent[timezone] = true
+	}
+
+	wallClock := mailing.ScheduledAt
+	if mailing.OccurrenceAt != nil {
+		wallClock = *mailing.OccurrenceAt
+	}
+	wallClock = wallClock.In(utils.LoadTimezone(mailing.Timezone))
+
+	var (
+		due  []string
+		next time.Time
+	)
+	for _, timezone := range timezones {
+		if sent[timezone] {
+			continue
+		}
+		at := utils.SameWallClock(wallClock, utils.LoadTimezone(timezone))
+		if !at.After(now) {
+			due = append(due, timezone)
+		} else if next.IsZero() || at.Before(next) {
+			next = at.UTC()
+		}
+	}
+	return due, next, nil
+}
+
+// occurrenceOutcome определяет итог отправки LocalTime-рассылки по всем волнам
+func (s *Scheduler) occurrenceOutcome(ctx context.Context, mailing *models.Mailing) models.MailingStatus {
+	counts, err := database.NewDeliveryRepository(s.db).CountOccurrence(ctx, mailing.ID, mailing.Occurrences)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Cannot count deliveries of mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+		return models.MailingPartiallyFailed
+	}
+	return sendOutcome(notifier.SendResult{
+		Sent:   counts[models.DeliverySent],
+		Failed: counts[models.DeliveryFailed],
+	}, nil)
+}
+
+// instanceID возвращает уникальный идентификатор процесса
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+}
+
+// sendOutcome определяет итоговое состояние рассылки по результату отправки.
+// MailingScheduled означает, что отправка завершилась ошибкой раньше, чем началась, и её нужно повторить
+func sendOutcome(result notifier.SendResult, err error) models.MailingStatus {
+	switch {
+	case result.Failed == 0 && err == nil:
+		return models.MailingSent
+	case result.Sent == 0 && result.Failed == 0:
+		return models.MailingScheduled
+	case result.Sent == 0:
+		return models.MailingFailed
+	default:
+		return models.MailingPartiallyFailed
+	}
+}
+
+// sendRetryDelay возвращает, через сколько повторить рассылку, отправка которой retries раз подряд
+// не началась из-за ошибки, или false, если повторять больше не нужно
+func sendRetryDelay(retries int) (time.Duration, bool) {
+	if retries >= maxSendRetries {
+		return 0, false
+	}
+	delay := sendRetryBaseDelay
+	for i := 0; i < retries && delay < sendRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, sendRetryMaxDelay), true
+}
+
+// completeOccurrence завершает отправку: разовая рассылка переходит в итоговое состояние outcome,
+// у повторяющейся переносится дата на следующее срабатывание
+func (s *Scheduler) completeOccurrence(ctx context.Context, mailing *models.Mailing, outcome models.MailingStatus, now time.Time) {
+	mailing.Occurrences++
+	mailing.SendRetries = 0
+	if next, ok := s.nextOccurrence(ctx, mailing, now); ok {
+		if mailing.LocalTime {
+			mailing.OccurrenceAt = &next
+			mailing.SentZones = nil
+			next = FirstWave(next, mailing.Timezone)
+		}
+		mailing.ScheduledAt = next
+		outcome = models.MailingScheduled
+	}
+
+	if err := mailing.Transition(outcome, now); err != nil {
+		s.logger.ErrorContext(ctx, "Cannot complete mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+	}
+}
+
+// nextOccurrence возвращает время следующей отправки повторяющейся рассылки
+func (s *Scheduler) nextOccurrence(ctx context.Context, mailing *models.Mailing, now time.Time) (time.Time, bool) {
+	if mailing.Recurrence == "" {
+		return time.Time{}, false
+	}
+
+	if mailing.MaxOccurrences > 0 && mailing.Occurrences >= mailing.MaxOccurrences {
+		return time.Time{}, false
+	}
+
+	// пропускаем срабатывания, которые пришлись на время простоя бота
+	next := mailing.ScheduledAt
+	if mailing.OccurrenceAt != nil {
+		next = *mailing.OccurrenceAt
+	}
+	for !next.After(now) {
+		var err error
+		next, err = utils.NextOccurrence(mailing.Recurrence, next)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Invalid recurrence for mailing", "mailing_id", mailing.ID.Hex(), "error", err)
+			return time.Time{}, false
+		}
+	}
+
+	if mailing.RecurrenceEnd != nil && next.After(*mailing.RecurrenceEnd) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// FirstWave возвращает момент, когда дата и время at по часовому поясу timezone раньше всего наступят
+// где-либо: с него планировщик начинает отправку LocalTime-рассылки
+func FirstWave(at time.Time, timezone string) time.Time {
+	wallClock := at.In(utils.LoadTimezone(timezone))
+	return utils.SameWallClock(wallClock, utils.LoadTimezone(utils.EarliestTimezone)).UTC()
+}
+
+// cronLogger пишет сообщения cron, например о пропущенной проверке, в журнал бота
+type cronLogger struct {
+	logger *slog.Logger
+}
+
+func (l cronLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Debug("cron: "+msg, keysAndValues...)
+}
+
+func (l cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.logger.Error("cron: "+msg, append(keysAndValues, "error", err)...)
 }
@@ -0,0 +1,189 @@
+package segmenter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+// допустимые названия произвольных полей пользователя
+var attributeKey = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// IsAttributeKey проверяет название произвольного поля пользователя
+func IsAttributeKey(key string) bool {
+	return attributeKey.MatchString(key)
+}
+
+// формат даты в правилах; в документе сегмента дата хранится как 2006-01-02
+const ruleDateLayout = "02.01.2006"
+
+// ParseRule разбирает правило динамического сегмента. Условия объединяются через «и»/«and»
+// и «или»/«or» (у «и» приоритет выше), «не»/«not» отрицает условие:
+//
+//	сегмент clients и не сегмент workers
+//	зарегистрирован после 01.01.2024 или department = IT
+func ParseRule(text string) (*models.SegmentRule, error) {
+	tokens := strings.Fields(text)
+	if len(tokens) == 0 {
+		return nil, errors.New("empty rule")
+	}
+
+	var anyOf []models.SegmentRule
+	for _, group := range splitTokens(tokens, "или", "or") {
+		var all []models.SegmentRule
+		for _, factor := range splitTokens(group, "и", "and") {
+			rule, err := parseFactor(factor)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, *rule)
+		}
+		if len(all) == 1 {
+			anyOf = append(anyOf, all[0])
+		} else {
+			anyOf = append(anyOf, models.SegmentRule{All: all})
+		}
+	}
+
+	if len(anyOf) == 1 {
+		return &anyOf[0], nil
+	}
+	return &models.SegmentRule{Any: anyOf}, nil
+}
+
+// splitTokens делит токены по словам-разделителям
+func splitTokens(tokens []string, separators ...string) [][]string {
+	var groups [][]string
+	var current []string
+	for _, token := range tokens {
+		if isWord(token, separators...) {
+			groups = append(groups, current)
+			current = nil
+			continue
+		}
+		current = append(current, token)
+	}
+	return append(groups, current)
+}
+
+func isWord(token string, words ...string) bool {
+	token = strings.ToLower(token)
+	for _, word := range words {
+		if token == word {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFactor(tokens []string) (*models.SegmentRule, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("missing condition")
+	}
+
+	if isWord(tokens[0], "не", "not") {
+		rule, err := parseFactor(tokens[1:])
+		if err != nil {
+			return nil, err
+		}
+		return &models.SegmentRule{Not: rule}, nil
+	}
+
+	switch {
+	case isWord(tokens[0], "сегмент", "segment"):
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("expected segment name in %q", strings.Join(tokens, " "))
+		}
+		return &models.SegmentRule{Field: "segment", Op: models.RuleEq, Value: tokens[1]}, nil
+
+	case isWord(tokens[0], "зарегистрирован", "registered"):
+		if len(tokens) != 3 {
+			return nil, fmt.Errorf("expected 'registered after|before DD.MM.YYYY' in %q", strings.Join(tokens, " "))
+		}
+		var op models.RuleOp
+		switch {
+		case isWord(tokens[1], "после", "after"):
+			op = models.RuleAfter
+		case isWord(tokens[1], "до", "before"):
+			op = models.RuleBefore
+		default:
+			return nil, fmt.Errorf("expected 'after' or 'before' instead of %q", tokens[1])
+		}
+		date, err := time.Parse(ruleDateLayout, tokens[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q, expected DD.MM.YYYY", tokens[2])
+		}
+		return &models.SegmentRule{Field: "registered", Op: op, Value: date.Format(time.DateOnly)}, nil
+	}
+
+	return parseComparison(tokens)
+}
+
+// parseComparison разбирает условие «поле = значение» или «поле != значение»
+func parseComparison(tokens []string) (*models.SegmentRule, error) {
+	text := strings.Join(tokens, " ")
+	op, sep := models.RuleEq, "="
+	if strings.Contains(text, "!=") {
+		op, sep = models.RuleNe, "!="
+	}
+	key, value, ok := strings.Cut(text, sep)
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if !ok || key == "" || value == "" {
+		return nil, fmt.Errorf("unknown condition %q", text)
+	}
+
+	if isWord(key, "роль", "role") {
+		return &models.SegmentRule{Field: "role", Op: op, Value: value}, nil
+	}
+	if !IsAttributeKey(key) {
+		return nil, fmt.Errorf("invalid attribute name %q", key)
+	}
+	return &models.SegmentRule{Field: "attributes." + key, Op: op, Value: value}, nil
+}
+
+// DescribeRule возвращает правило в том же виде, в каком его вводят в /define_segment
+func DescribeRule(rule *models.SegmentRule) string {
+	switch {
+	case len(rule.Any) > 0:
+		return joinRules(rule.Any, " или ")
+	case len(rule.All) > 0:
+		return joinRules(rule.All, " и ")
+	case rule.Not != nil:
+		return "не " + DescribeRule(rule.Not)
+	}
+
+	switch rule.Field {
+	case "segment":
+		if rule.Op == models.RuleNe {
+			return "не сегмент " + rule.Value
+		}
+		return "сегмент " + rule.Value
+	case "registered":
+		date := rule.Value
+		if t, err := time.Parse(time.DateOnly, rule.Value); err == nil {
+			date = t.Format(ruleDateLayout)
+		}
+		if rule.Op == models.RuleBefore {
+			return "зарегистрирован до " + date
+		}
+		return "зарегистрирован после " + date
+	}
+
+	op := "="
+	if rule.Op == models.RuleNe {
+		op = "!="
+	}
+	return fmt.Sprintf("%s %s %s", strings.TrimPrefix(rule.Field, "attributes."), op, rule.Value)
+}
+
+func joinRules(rules []models.SegmentRule, sep string) string {
+	parts := make([]string, len(rules))
+	for i := range rules {
+		parts[i] = DescribeRule(&rules[i])
+	}
+	return strings.Join(parts, sep)
+}
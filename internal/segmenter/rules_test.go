@@ -0,0 +1,120 @@
+package segmenter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/models"
+)
+
+func TestParseRule(t *testing.T) {
+	segment := func(name string) models.SegmentRule {
+		return models.SegmentRule{Field: "segment", Op: models.RuleEq, Value: name}
+	}
+
+	tests := []struct {
+		input string
+		want  models.SegmentRule
+	}{
+		{"сегмент clients", segment("clients")},
+		{"segment clients", segment("clients")},
+		{"не сегмент workers", models.SegmentRule{Not: &models.SegmentRule{Field: "segment", Op: models.RuleEq, Value: "workers"}}},
+		{"зарегистрирован после 01.01.2024", models.SegmentRule{Field: "registered", Op: models.RuleAfter, Value: "2024-01-01"}},
+		{"registered before 31.12.2025", models.SegmentRule{Field: "registered", Op: models.RuleBefore, Value: "2025-12-31"}},
+		{"department = IT", models.SegmentRule{Field: "attributes.department", Op: models.RuleEq, Value: "IT"}},
+		{"city != Москва", models.SegmentRule{Field: "attributes.city", Op: models.RuleNe, Value: "Москва"}},
+		{"position = senior developer", models.SegmentRule{Field: "attributes.position", Op: models.RuleEq, Value: "senior developer"}},
+		{"роль = admin", models.SegmentRule{Field: "role", Op: models.RuleEq, Value: "admin"}},
+		{"сегмент clients и не сегмент workers", models.SegmentRule{All: []models.SegmentRule{
+			segment("clients"),
+			{Not: &models.SegmentRule{Field: "segment", Op: models.RuleEq, Value: "workers"}},
+		}}},
+		{"сегмент a OR сегмент b", models.SegmentRule{Any: []models.SegmentRule{segment("a"), segment("b")}}},
+		// у «и» приоритет выше, чем у «или»
+		{"сегмент a или сегмент b и сегмент c", models.SegmentRule{Any: []models.SegmentRule{
+			segment("a"),
+			{All: []models.SegmentRule{segment("b"), segment("c")}},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseRule(tt.input)
+			if err != nil {
+				t.Fatalf("ParseRule(%q) error: %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ParseRule(%q) = %+v, want %+v", tt.input, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRuleInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"   ",
+		"сегмент",
+		"сегмент a b",
+		"сегмент a и",
+		"или сегмент a",
+		"не",
+		"зарегистрирован 01.01.2024",
+		"зарегистрирован около 01.01.2024",
+		"зарегистрирован после 2024-01-01",
+		"зарегистрирован после 32.01.2024",
+		"department",
+		"department =",
+		"= IT",
+		"bad-key = 1",
+	} {
+		t.Run(input, func(t *testing.T) {
+			if got, err := ParseRule(input); err == nil {
+				t.Errorf("ParseRule(%q) = %+v, want error", input, got)
+			}
+		})
+	}
+}
+
+func TestDescribeRule(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"segment clients", "сегмент clients"},
+		{"not segment workers", "не сегмент workers"},
+		{"registered after 01.01.2024", "зарегистрирован после 01.01.2024"},
+		{"зарегистрирован до 31.12.2025", "зарегистрирован до 31.12.2025"},
+		{"department=IT", "department = IT"},
+		{"city != Москва", "city != Москва"},
+		{"role = admin", "role = admin"},
+		{"сегмент a and сегмент b or не сегмент c", "сегмент a и сегмент b или не сегмент c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			rule, err := ParseRule(tt.input)
+			if err != nil {
+				t.Fatalf("ParseRule(%q) error: %v", tt.input, err)
+			}
+			got := DescribeRule(rule)
+			if got != tt.want {
+				t.Errorf("DescribeRule(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+
+			// описание правила снова разбирается в то же правило
+			again, err := ParseRule(got)
+			if err != nil {
+				t.Fatalf("ParseRule(%q) error: %v", got, err)
+			}
+			if !reflect.DeepEqual(again, rule) {
+				t.Errorf("ParseRule(DescribeRule(%q)) = %+v, want %+v", tt.input, again, rule)
+			}
+		})
+	}
+}
+
+func TestDescribeRuleNeSegment(t *testing.T) {
+	rule := &models.SegmentRule{Field: "segment", Op: models.RuleNe, Value: "workers"}
+	if got, want := DescribeRule(rule), "не сегмент workers"; got != want {
+		t.Errorf("DescribeRule() = %q, want %q", got, want)
+	}
+}
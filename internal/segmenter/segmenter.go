@@ -2,21 +2,43 @@ package segmenter
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/utils"
 	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// ErrStaticSegment - сегмент с таким названием уже существует и заполняется пользователями вручную
+var ErrStaticSegment = errors.New("segment is not dynamic")
+
+// ErrDynamicSegment - в динамический сегмент нельзя вступить или выйти из него вручную
+var ErrDynamicSegment = errors.New("segment is dynamic")
+
+// ErrUnknownSegment - сегмента с таким названием нет
+var ErrUnknownSegment = errors.New("segment not found")
+
+// segmentFinder ищет сегмент по названию; если сегмента нет, возвращает nil без ошибки
+type segmentFinder interface {
+	GetByName(ctx context.Context, name string) (*models.Segment, error)
+}
+
 type Segmenter struct {
-	db *database.Database
+	db       *database.Database
+	segments segmentFinder
+	logger   *slog.Logger
 }
 
-func NewSegmenter(db *database.Database) *Segmenter {
+func NewSegmenter(db *database.Database, logger *slog.Logger) *Segmenter {
 	return &Segmenter{
-		db: db,
+		db:       db,
+		segments: database.NewSegmentRepository(db),
+		logger:   logger,
 	}
 }
 
@@ -38,12 +60,17 @@ func (s *Segmenter) CreateSegmentIfNotExists(ctx context.Context, segmentName st
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	err = segmentRepo.Create(ctx, &segment)
-	log.Println("new segment")
-	return err
+	if err := segmentRepo.Create(ctx, &segment); err != nil {
+		return err
+	}
+	s.logger.InfoContext(ctx, "Segment created", "segment", segmentName)
+	return nil
 }
 
 func (s *Segmenter) AddUserToSegment(ctx context.Context, userID primitive.ObjectID, segment string) error {
+	if err := s.checkStatic(ctx, segment); err != nil {
+		return err
+	}
 
 	userRepo := database.NewUserRepository(s.db)
 	user, err := userRepo.GetByID(ctx, userID)
@@ -63,14 +90,9 @@ func (s *Segmenter) AddUserToSegment(ctx context.Context, userID primitive.Objec
 }
 
 func (s *Segmenter) RemoveUserFromSegment(ctx context.Context, userID primitive.ObjectID, segment string) error {
-	segmentRepo := database.NewSegmentRepository(s.db)
-	seg, err := segmentRepo.GetByName(ctx, segment)
-	if err != nil {
+	if err := s.checkStatic(ctx, segment); err != nil {
 		return err
 	}
-	if seg == nil {
-		segmentRepo.Create(ctx, seg)
-	}
 
 	userRepo := database.NewUserRepository(s.db)
 	user, err := userRepo.GetByID(ctx, userID)
@@ -90,7 +112,349 @@ func (s *Segmenter) RemoveUserFromSegment(ctx context.Context, userID primitive.
 	return userRepo.Update(ctx, user)
 }
 
+// GetUsersInSegment возвращает пользователей сегмента. Состав динамического сегмента
+// вычисляется по его правилу в момент вызова
 func (s *Segmenter) GetUsersInSegment(ctx context.Context, segment string) ([]*models.User, error) {
+	filter, err := s.SegmentFilter(ctx, segment)
+	if err != nil {
+		return nil, err
+	}
+	userRepo := database.NewUserRepository(s.db)
+	return userRepo.ListByFilter(ctx, filter)
+}
+
+// Audience - получатели рассылки: участники хотя бы одного из сегментов Include,
+// не входящие ни в один из сегментов Exclude
+type Audience struct {
+	Include []string
+	Exclude []string
+	// обязательная рассылка приходит и пользователям, которые отписались
+	Mandatory bool
+	// если задано, только пользователи из этих часовых поясов
+	Timezones []string
+}
+
+// MailingAudience возвращает получателей рассылки
+func MailingAudience(mailing *models.Mailing) Audience {
+	return Audience{
+		Include:   mailing.IncludedSegments(),
+		Exclude:   mailing.ExcludeSegments,
+		Mandatory: mailing.Mandatory,
+	}
+}
+
+// GetAudience возвращает получателей рассылки. Пользователь из нескольких сегментов попадает в список один раз
+func (s *Segmenter) GetAudience(ctx context.Context, audience Audience) ([]*models.User, error) {
+	filter, err := s.AudienceFilter(ctx, audience)
+	if err != nil {
+		return nil, err
+	}
+	userRepo := database.NewUserRepository(s.db)
+	return userRepo.ListByFilter(ctx, filter)
+}
+
+// CountAudience возвращает количество получателей рассылки
+func (s *Segmenter) CountAudience(ctx context.Context, audience Audience) (int64, error) {
+	filter, err := s.AudienceFilter(ctx, audience)
+	if err != nil {
+		return 0, err
+	}
+	userRepo := database.NewUserRepository(s.db)
+	return userRepo.CountByFilter(ctx, filter)
+}
+
+// CountMembers возвращает количество участников каждого сегмента: обычные сегменты считаются
+// одной агрегацией, динамические - по их правилам
+func (s *Segmenter) CountMembers(ctx context.Context, segments []*models.Segment) (map[string]int, error) {
+	userRepo := database.NewUserRepository(s.db)
+	counts, err := userRepo.CountBySegment(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, segment := range segments {
+		if segment.Rule == nil {
+			continue
+		}
+		filter, err := s.SegmentFilter(ctx, segment.Name)
+		if err != nil {
+			// правило могло сломаться, например, если удалили сегмент, на который оно ссылается
+			s.logger.WarnContext(ctx, "Cannot resolve segment", "segment", segment.Name, "error", err)
+			continue
+		}
+		count, err := userRepo.CountByFilter(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		counts[segment.Name] = int(count)
+	}
+	return counts, nil
+}
+
+// AudienceFilter возвращает запрос к коллекции пользователей для аудитории рассылки
+func (s *Segmenter) AudienceFilter(ctx context.Context, audience Audience) (bson.M, error) {
+	if len(audience.Include) == 0 {
+		return nil, errors.New("no segments to include")
+	}
+
+	included, err := s.segmentFilters(ctx, audience.Include)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"$or": included}
+
+	if len(audience.Exclude) > 0 {
+		excluded, err := s.segmentFilters(ctx, audience.Exclude)
+		if err != nil {
+			return nil, err
+		}
+		filter["$nor"] = excluded
+	}
+	if !audience.Mandatory {
+		filter["opted_out"] = bson.M{"$ne": true}
+	}
+	if len(audience.Timezones) > 0 {
+		timezones := bson.A{}
+		for _, timezone := range audience.Timezones {
+			timezones = append(timezones, timezone)
+			// у пользователей, не указавших часовой пояс, время московское
+			if timezone == utils.DefaultTimezone {
+				timezones = append(timezones, "", nil)
+			}
+		}
+		filter["timezone"] = bson.M{"$in": timezones}
+	}
+	return filter, nil
+}
+
+// AudienceTimezones возвращает часовые пояса получателей рассылки, всегда включая часовой пояс по умолчанию
+func (s *Segmenter) AudienceTimezones(ctx context.Context, audience Audience) ([]string, error) {
+	audience.Timezones = nil
+	filter, err := s.AudienceFilter(ctx, audience)
+	if err != nil {
+		return nil, err
+	}
+	timezones, err := database.NewUserRepository(s.db).DistinctTimezones(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, timezone := range timezones {
+		if timezone == utils.DefaultTimezone {
+			return timezones, nil
+		}
+	}
+	return append(timezones, utils.DefaultTimezone), nil
+}
+
+func (s *Segmenter) segmentFilters(ctx context.Context, segments []string) (bson.A, error) {
+	filters := make(bson.A, 0, len(segments))
+	for _, segment := range segments {
+		filter, err := s.SegmentFilter(ctx, segment)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// Contains проверяет, входит ли пользователь в сегмент
+func (s *Segmenter) Contains(ctx context.Context, userID primitive.ObjectID, segment string) (bool, error) {
+	filter, err := s.SegmentFilter(ctx, segment)
+	if err != nil {
+		return false, err
+	}
 	userRepo := database.NewUserRepository(s.db)
-	return userRepo.ListBySegment(ctx, segment)
+	count, err := userRepo.CountByFilter(ctx, bson.M{"$and": bson.A{bson.M{"_id": userID}, filter}})
+	return count > 0, err
+}
+
+// DefineSegment создаёт динамический сегмент или меняет правило существующего
+func (s *Segmenter) DefineSegment(ctx context.Context, name string, rule *models.SegmentRule) error {
+	segmentRepo := database.NewSegmentRepository(s.db)
+	seg, err := segmentRepo.GetByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	if seg != nil && seg.Rule == nil {
+		return ErrStaticSegment
+	}
+
+	// проверяем, что правило ссылается на существующие сегменты и не зацикливается
+	if _, err := s.ruleFilter(ctx, rule, map[string]bool{name: true}); err != nil {
+		return err
+	}
+
+	if seg == nil {
+		return segmentRepo.Create(ctx, &models.Segment{Name: name, Rule: rule})
+	}
+	seg.Rule = rule
+	return segmentRepo.Update(ctx, seg)
+}
+
+// SegmentFilter возвращает запрос к коллекции пользователей, выбирающий участников сегмента
+func (s *Segmenter) SegmentFilter(ctx context.Context, segment string) (bson.M, error) {
+	return s.segmentFilter(ctx, segment, map[string]bool{})
+}
+
+func (s *Segmenter) segmentFilter(ctx context.Context, segment string, visiting map[string]bool) (bson.M, error) {
+	seg, err := s.segments.GetByName(ctx, segment)
+	if err != nil {
+		return nil, err
+	}
+	if seg == nil || seg.Rule == nil {
+		return bson.M{"segments": segment}, nil
+	}
+
+	if visiting[segment] {
+		return nil, fmt.Errorf("segment %s refers to itself", segment)
+	}
+	visiting[segment] = true
+	defer delete(visiting, segment)
+
+	return s.ruleFilter(ctx, seg.Rule, visiting)
+}
+
+// ruleFilter переводит правило сегмента в запрос MongoDB
+func (s *Segmenter) ruleFilter(ctx context.Context, rule *models.SegmentRule, visiting map[string]bool) (bson.M, error) {
+	switch {
+	case len(rule.All) > 0:
+		filters, err := s.rulesFilters(ctx, rule.All, visiting)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$and": filters}, nil
+	case len(rule.Any) > 0:
+		filters, err := s.rulesFilters(ctx, rule.Any, visiting)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$or": filters}, nil
+	case rule.Not != nil:
+		filter, err := s.ruleFilter(ctx, rule.Not, visiting)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$nor": bson.A{filter}}, nil
+	}
+
+	switch rule.Field {
+	case "segment":
+		seg, err := s.segments.GetByName(ctx, rule.Value)
+		if err != nil {
+			return nil, err
+		}
+		if seg == nil {
+			return nil, fmt.Errorf("segment %s not found", rule.Value)
+		}
+		filter, err := s.segmentFilter(ctx, rule.Value, visiting)
+		if err != nil {
+			return nil, err
+		}
+		if rule.Op == models.RuleNe {
+			return bson.M{"$nor": bson.A{filter}}, nil
+		}
+		return filter, nil
+
+	case "registered":
+		loc := utils.LoadTimezone(utils.DefaultTimezone)
+		date, err := time.ParseInLocation(time.DateOnly, rule.Value, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date in rule: %w", err)
+		}
+		// «после 01.01» - с начала следующего дня, «до 01.01» - до начала этого дня
+		if rule.Op == models.RuleAfter {
+			return bson.M{"created_at": bson.M{"$gte": date.AddDate(0, 0, 1).UTC()}}, nil
+		}
+		return bson.M{"created_at": bson.M{"$lt": date.UTC()}}, nil
+
+	case "role":
+		// пользователи, зарегистрированные до появления ролей, считаются подписчиками
+		if rule.Value == string(models.RoleSubscriber) {
+			roles := bson.A{models.RoleSubscriber, "", nil}
+			if rule.Op == models.RuleNe {
+				return bson.M{"role": bson.M{"$nin": roles}}, nil
+			}
+			return bson.M{"role": bson.M{"$in": roles}}, nil
+		}
+
+	case "":
+		return nil, errors.New("empty rule")
+	}
+
+	if rule.Op == models.RuleNe {
+		return bson.M{rule.Field: bson.M{"$ne": rule.Value}}, nil
+	}
+	return bson.M{rule.Field: rule.Value}, nil
+}
+
+func (s *Segmenter) rulesFilters(ctx context.Context, rules []models.SegmentRule, visiting map[string]bool) (bson.A, error) {
+	filters := make(bson.A, 0, len(rules))
+	for i := range rules {
+		filter, err := s.ruleFilter(ctx, &rules[i], visiting)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// CheckSegments проверяет, что сегменты существуют; сегмент all есть всегда.
+// Для несуществующего сегмента возвращает ошибку, оборачивающую ErrUnknownSegment
+func (s *Segmenter) CheckSegments(ctx context.Context, segments ...string) error {
+	for _, segment := range segments {
+		if segment == "all" {
+			continue
+		}
+		seg, err := s.segments.GetByName(ctx, segment)
+		if err != nil {
+			return err
+		}
+		if seg == nil {
+			return fmt.Errorf("%w: %s", ErrUnknownSegment, segment)
+		}
+	}
+	return nil
+}
+
+// CheckMembership проверяет, что пользователя можно вручную добавить в сегменты:
+// они существуют и не формируются по правилу
+func (s *Segmenter) CheckMembership(ctx context.Context, segments ...string) error {
+	if err := s.CheckSegments(ctx, segments...); err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if err := s.checkStatic(ctx, segment); err != nil {
+			return fmt.Errorf("%w: %s", err, segment)
+		}
+	}
+	return nil
+}
+
+// HasDynamic сообщает, есть ли среди сегментов формируемые по правилу
+func (s *Segmenter) HasDynamic(ctx context.Context, segments ...string) (bool, error) {
+	for _, segment := range segments {
+		err := s.checkStatic(ctx, segment)
+		if errors.Is(err, ErrDynamicSegment) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// checkStatic возвращает ErrDynamicSegment, если сегмент формируется по правилу
+func (s *Segmenter) checkStatic(ctx context.Context, segment string) error {
+	seg, err := s.segments.GetByName(ctx, segment)
+	if err != nil {
+		return err
+	}
+	if seg != nil && seg.Rule != nil {
+		return ErrDynamicSegment
+	}
+	return nil
 }
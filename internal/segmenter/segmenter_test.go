@@ -0,0 +1,123 @@
+package segmenter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"github.com/g0shi4ek/VK_bot/internal/utils"
+	"github.com/g0shi4ek/VK_bot/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMailingAudience(t *testing.T) {
+	tests := []struct {
+		mailing *models.Mailing
+		want    Audience
+	}{
+		// рассылки, созданные до исключения сегментов
+		{&models.Mailing{Segment: "clients"}, Audience{Include: []string{"clients"}}},
+		{
+			&models.Mailing{Segment: "old", Segments: []string{"clients", "workers"}, ExcludeSegments: []string{"contractors"}},
+			Audience{Include: []string{"clients", "workers"}, Exclude: []string{"contractors"}},
+		},
+		{
+			&models.Mailing{Segments: []string{"all"}, Mandatory: true},
+			Audience{Include: []string{"all"}, Mandatory: true},
+		},
+	}
+	for _, tt := range tests {
+		if got := MailingAudience(tt.mailing); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MailingAudience(%+v) = %+v, want %+v", tt.mailing, got, tt.want)
+		}
+	}
+}
+
+// fakeSegments - сегменты в памяти вместо коллекции segments
+type fakeSegments map[string]*models.Segment
+
+func (f fakeSegments) GetByName(ctx context.Context, name string) (*models.Segment, error) {
+	return f[name], nil
+}
+
+func newTestSegmenter(segments ...*models.Segment) *Segmenter {
+	found := make(fakeSegments)
+	for _, segment := range segments {
+		found[segment.Name] = segment
+	}
+	return &Segmenter{segments: found, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+}
+
+func TestAudienceFilter(t *testing.T) {
+	s := newTestSegmenter(
+		&models.Segment{Name: "clients"},
+		&models.Segment{Name: "contractors"},
+		&models.Segment{Name: "it", Rule: &models.SegmentRule{Field: "attributes.department", Op: models.RuleEq, Value: "IT"}},
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		audience Audience
+		want     bson.M
+	}{
+		{
+			"отписавшиеся исключаются",
+			Audience{Include: []string{"clients"}},
+			bson.M{"$or": bson.A{bson.M{"segments": "clients"}}, "opted_out": bson.M{"$ne": true}},
+		},
+		{
+			"исключённые сегменты, в том числе динамические",
+			Audience{Include: []string{"clients", "all"}, Exclude: []string{"contractors", "it"}},
+			bson.M{
+				"$or":       bson.A{bson.M{"segments": "clients"}, bson.M{"segments": "all"}},
+				"$nor":      bson.A{bson.M{"segments": "contractors"}, bson.M{"attributes.department": "IT"}},
+				"opted_out": bson.M{"$ne": true},
+			},
+		},
+		{
+			"обязательная рассылка приходит и отписавшимся",
+			Audience{Include: []string{"clients"}, Mandatory: true},
+			bson.M{"$or": bson.A{bson.M{"segments": "clients"}}},
+		},
+		{
+			"у пользователей без часового пояса время московское",
+			Audience{Include: []string{"clients"}, Mandatory: true, Timezones: []string{"Asia/Omsk", utils.DefaultTimezone}},
+			bson.M{
+				"$or":      bson.A{bson.M{"segments": "clients"}},
+				"timezone": bson.M{"$in": bson.A{"Asia/Omsk", utils.DefaultTimezone, "", nil}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.AudienceFilter(ctx, tt.audience)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AudienceFilter(%+v) = %v, want %v", tt.audience, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := s.AudienceFilter(ctx, Audience{Exclude: []string{"clients"}}); err == nil {
+		t.Error("AudienceFilter without included segments returned no error")
+	}
+}
+
+func TestCheckSegments(t *testing.T) {
+	s := newTestSegmenter(&models.Segment{Name: "clients"})
+	ctx := context.Background()
+
+	if err := s.CheckSegments(ctx, "all", "clients"); err != nil {
+		t.Errorf("CheckSegments(all, clients) = %v", err)
+	}
+	// раньше несуществующий сегмент проходил проверку: GetByName возвращает nil без ошибки
+	if err := s.CheckSegments(ctx, "clients", "unknown"); !errors.Is(err, ErrUnknownSegment) {
+		t.Errorf("CheckSegments(unknown) = %v, want ErrUnknownSegment", err)
+	}
+}
@@ -0,0 +1,272 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// слова, обозначающие текущий момент
+var nowWords = map[string]bool{"сейчас": true, "немедленно": true, "now": true}
+
+// смещение в днях для «сегодня», «завтра», «послезавтра»
+var dayOffsets = map[string]int{
+	"сегодня":     0,
+	"today":       0,
+	"завтра":      1,
+	"tomorrow":    1,
+	"послезавтра": 2,
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"пн": time.Monday, "пон": time.Monday, "понедельник": time.Monday, "mon": time.Monday, "monday": time.Monday,
+	"вт": time.Tuesday, "вто": time.Tuesday, "вторник": time.Tuesday, "tue": time.Tuesday, "tuesday": time.Tuesday,
+	"ср": time.Wednesday, "сре": time.Wednesday, "среда": time.Wednesday, "среду": time.Wednesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"чт": time.Thursday, "чет": time.Thursday, "четверг": time.Thursday, "thu": time.Thursday, "thursday": time.Thursday,
+	"пт": time.Friday, "пят": time.Friday, "пятница": time.Friday, "пятницу": time.Friday,
+	"fri": time.Friday, "friday": time.Friday,
+	"сб": time.Saturday, "суб": time.Saturday, "суббота": time.Saturday, "субботу": time.Saturday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+	"вс": time.Sunday, "вос": time.Sunday, "воскресенье": time.Sunday, "sun": time.Sunday, "sunday": time.Sunday,
+}
+
+// предлоги и уточнения, которые не влияют на дату: «в пн», «во вторник», «next monday», «at 10»
+var fillerWords = map[string]bool{
+	"в": true, "во": true, "на": true, "at": true, "on": true, "next": true,
+	"следующий": true, "следующую": true, "следующее": true, "следующая": true,
+}
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+
+	// maxOffset - наибольшее относительное смещение; большие числа переполнили бы time.Duration
+	maxOffset = 100 * 365 * day
+)
+
+// единицы смещений вида +30m
+var shortOffsetUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": day, "w": week}
+
+var (
+	shortOffsetRe = regexp.MustCompile(`^\+((?:\d+[smhdw])+)$`)
+	offsetPartRe  = regexp.MustCompile(`(\d+)([smhdw])`)
+	clockRe       = regexp.MustCompile(`^(\d{1,2})(?:[:.](\d{2}))?(am|pm)?$`)
+)
+
+// ParseNaturalTime разбирает относительную дату на русском или английском относительно now:
+// «сейчас», «через 2 часа», «in 30 minutes», «+1h30m», «завтра в 10», «tomorrow 9:30»,
+// «пн 9:00», «в пятницу в 18:00», «в 15:00». Результат в часовом поясе now
+func ParseNaturalTime(input string, now time.Time) (time.Time, bool) {
+	text := strings.ToLower(strings.Join(strings.Fields(input), " "))
+	text = strings.ReplaceAll(text, "day after tomorrow", "послезавтра")
+	if text == "" {
+		return time.Time{}, false
+	}
+
+	if nowWords[text] {
+		return now, true
+	}
+	if t, ok := parseShortOffset(text, now); ok {
+		return t, true
+	}
+	if t, ok := parseOffsetWords(text, now); ok {
+		return t, true
+	}
+	return parseDayAndClock(strings.Fields(text), now)
+}
+
+// parseShortOffset разбирает смещения вида +30m, +2h, +1d, +1w, +1h30m
+func parseShortOffset(text string, now time.Time) (time.Time, bool) {
+	match := shortOffsetRe.FindStringSubmatch(strings.ReplaceAll(text, " ", ""))
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	t, ok := now, true
+	for _, part := range offsetPartRe.FindAllStringSubmatch(match[1], -1) {
+		n, err := strconv.Atoi(part[1])
+		if err != nil {
+			return time.Time{}, false
+		}
+		if t, ok = addOffset(t, n, shortOffsetUnits[part[2]]); !ok {
+			return time.Time{}, false
+		}
+	}
+	return t, true
+}
+
+// addOffset прибавляет к t n единиц unit; дни и недели отсчитываются по календарю,
+// чтобы переход на летнее время не сдвигал время суток
+func addOffset(t time.Time, n int, unit time.Duration) (time.Time, bool) {
+	if n < 0 || int64(n) > int64(maxOffset/unit) {
+		return time.Time{}, false
+	}
+	switch unit {
+	case day:
+		return t.AddDate(0, 0, n), true
+	case week:
+		return t.AddDate(0, 0, 7*n), true
+	}
+	return t.Add(time.Duration(n) * unit), true
+}
+
+// parseOffsetWords разбирает «через 2 часа», «через час», «in 30 minutes», «in a day»
+func parseOffsetWords(text string, now time.Time) (time.Time, bool) {
+	words := strings.Fields(text)
+	if len(words) < 2 || words[0] != "через" && words[0] != "in" {
+		return time.Time{}, false
+	}
+
+	n := 1
+	words = words[1:]
+	if len(words) == 2 {
+		switch words[0] {
+		case "a", "an", "one", "один", "одну", "одна":
+		default:
+			var err error
+			if n, err = strconv.Atoi(words[0]); err != nil || n < 0 {
+				return time.Time{}, false
+			}
+		}
+		words = words[1:]
+	}
+	if len(words) != 1 {
+		return time.Time{}, false
+	}
+
+	unit := words[0]
+	switch {
+	case unit == "м" || strings.HasPrefix(unit, "мин") || unit == "m" || strings.HasPrefix(unit, "min"):
+		return addOffset(now, n, time.Minute)
+	case unit == "ч" || strings.HasPrefix(unit, "час") || unit == "h" || strings.HasPrefix(unit, "hour"):
+		return addOffset(now, n, time.Hour)
+	case unit == "д" || strings.HasPrefix(unit, "ден") || strings.HasPrefix(unit, "дн") ||
+		strings.HasPrefix(unit, "сут") || unit == "d" || strings.HasPrefix(unit, "day"):
+		return addOffset(now, n, day)
+	case strings.HasPrefix(unit, "нед") || unit == "w" || strings.HasPrefix(unit, "week"):
+		return addOffset(now, n, week)
+	}
+	return time.Time{}, false
+}
+
+// parseDayAndClock разбирает день («завтра», «пн») и/или время («в 10», «9:30», «9am»).
+// Без дня берётся ближайшее такое время, без времени - текущее время суток
+func parseDayAndClock(words []string, now time.Time) (time.Time, bool) {
+	dayOffset, weekday := -1, time.Weekday(-1)
+	hour, minute, hasClock := now.Hour(), now.Minute(), false
+
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if fillerWords[word] {
+			continue
+		}
+		if offset, ok := dayOffsets[word]; ok && dayOffset < 0 && weekday < 0 {
+			dayOffset = offset
+			continue
+		}
+		if wd, ok := weekdayNames[word]; ok && dayOffset < 0 && weekday < 0 {
+			weekday = wd
+			continue
+		}
+		if hasClock {
+			return time.Time{}, false
+		}
+
+		// «10 am», «9 утра», «7 вечера»
+		meridiem := ""
+		if i+1 < len(words) {
+			switch words[i+1] {
+			case "am", "pm", "утра", "дня", "вечера", "ночи":
+				meridiem = words[i+1]
+				i++
+			}
+		}
+		h, m, ok := parseClock(word, meridiem)
+		if !ok {
+			return time.Time{}, false
+		}
+		hour, minute, hasClock = h, m, true
+	}
+
+	if dayOffset < 0 && weekday < 0 && !hasClock {
+		return time.Time{}, false
+	}
+
+	at := func(days int) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day()+days, hour, minute, 0, 0, now.Location())
+	}
+	switch {
+	case dayOffset >= 0:
+		return at(dayOffset), true
+	case weekday >= 0:
+		days := (int(weekday) - int(now.Weekday()) + 7) % 7
+		if t := at(days); t.After(now) {
+			return t, true
+		}
+		return at(days + 7), true
+	default:
+		if t := at(0); t.After(now) {
+			return t, true
+		}
+		return at(1), true
+	}
+}
+
+// parseClock разбирает время суток: «10», «10:30», «10.30», «9am», «9 pm», «7 вечера»
+func parseClock(word, meridiem string) (int, int, bool) {
+	match := clockRe.FindStringSubmatch(word)
+	if match == nil {
+		return 0, 0, false
+	}
+	if match[3] != "" {
+		if meridiem != "" {
+			return 0, 0, false
+		}
+		meridiem = match[3]
+	}
+
+	hour, _ := strconv.Atoi(match[1])
+	minute := 0
+	if match[2] != "" {
+		minute, _ = strconv.Atoi(match[2])
+	}
+	if minute > 59 {
+		return 0, 0, false
+	}
+
+	switch meridiem {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		hour %= 12
+		if meridiem == "pm" {
+			hour += 12
+		}
+	case "утра", "ночи":
+		if hour > 12 {
+			return 0, 0, false
+		}
+		hour %= 12
+	case "дня", "вечера":
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		if hour < 12 {
+			hour += 12
+		}
+	}
+	if hour > 23 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+var weekdayShortNames = [...]string{"вс", "пн", "вт", "ср", "чт", "пт", "сб"}
+
+// FormatDateTime возвращает дату с днём недели, например «пн, 05.01.2026 10:00»
+func FormatDateTime(t time.Time) string {
+	return weekdayShortNames[t.Weekday()] + ", " + t.Format("02.01.2006 15:04")
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNaturalTime(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+	// среда, 7 января 2026, 12:00
+	now := time.Date(2026, 1, 7, 12, 0, 0, 0, msk)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 1, day, hour, minute, 0, 0, msk)
+	}
+
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		{"сейчас", now},
+		{"  NOW ", now},
+		{"через час", at(7, 13, 0)},
+		{"через 2 часа", at(7, 14, 0)},
+		{"in 30 minutes", at(7, 12, 30)},
+		{"in a day", at(8, 12, 0)},
+		{"через 2 недели", at(21, 12, 0)},
+		{"+1h30m", at(7, 13, 30)},
+		{"+ 45m", at(7, 12, 45)},
+		{"+1d", at(8, 12, 0)},
+		{"+1w", at(14, 12, 0)},
+		{"завтра в 10", at(8, 10, 0)},
+		{"tomorrow 9:30", at(8, 9, 30)},
+		{"послезавтра", at(9, 12, 0)},
+		{"day after tomorrow 8am", at(9, 8, 0)},
+		{"пн 9:00", at(12, 9, 0)},
+		{"в пятницу в 18:00", at(9, 18, 0)},
+		{"next monday at 10.15", at(12, 10, 15)},
+		// в среду в 12:00 уже наступило, поэтому следующая среда
+		{"ср 12:00", at(14, 12, 0)},
+		{"в 15:00", at(7, 15, 0)},
+		// 10 часов сегодня уже прошли
+		{"в 10", at(8, 10, 0)},
+		{"7 вечера", at(7, 19, 0)},
+		{"9am", at(8, 9, 0)},
+		{"12 pm", at(8, 12, 0)},
+		{"12 ночи", at(8, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := ParseNaturalTime(tt.input, now)
+			if !ok {
+				t.Fatalf("ParseNaturalTime(%q) failed", tt.input)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseNaturalTime(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNaturalTimeInvalid(t *testing.T) {
+	now := time.Date(2026, 1, 7, 12, 0, 0, 0, time.UTC)
+
+	tests := []string{
+		"",
+		"когда-нибудь",
+		"завтра завтра",
+		"в 25:00",
+		"в 10:60",
+		"13 pm",
+		"9am утра",
+		"в 10 в 11",
+		"через",
+		"через -1 час",
+		"через 2 года",
+		"+1y",
+		// переполнение time.Duration
+		"через 99999999999 часов",
+		"in 99999999999 minutes",
+		"+99999999999h",
+		"+1h99999999999m",
+		"через 100000000000000000000 часов",
+		// слишком далёкие даты
+		"через 99999999 дней",
+		"+9999999w",
+	}
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			if got, ok := ParseNaturalTime(input, now); ok {
+				t.Errorf("ParseNaturalTime(%q) = %v, want failure", input, got)
+			}
+		})
+	}
+}
+
+func TestFormatDateTime(t *testing.T) {
+	got := FormatDateTime(time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC))
+	if want := "пн, 05.01.2026 10:00"; got != want {
+		t.Errorf("FormatDateTime() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// простые правила повторения: "каждый день в 10:00", "every week at 09:30", "ежемесячно"
+var recurrenceRule = regexp.MustCompile(`^(каждый день|ежедневно|every day|daily|каждую неделю|еженедельно|every week|weekly|каждый месяц|ежемесячно|every month|monthly)(?:\s+(?:в|at)\s+(\d{1,2}):(\d{2}))?$`)
+
+// ParseRecurrence преобразует правило повторения в cron-выражение в часовом поясе loc.
+// Если время или день в правиле не указаны, они берутся из первой даты отправки start.
+func ParseRecurrence(input string, start time.Time, loc *time.Location) (string, error) {
+	start = start.In(loc)
+
+	input = strings.Join(strings.Fields(input), " ")
+	if match := recurrenceRule.FindStringSubmatch(strings.ToLower(input)); match != nil {
+		hour, minute := start.Hour(), start.Minute()
+		if match[2] != "" {
+			hour, _ = strconv.Atoi(match[2])
+			minute, _ = strconv.Atoi(match[3])
+			if hour > 23 || minute > 59 {
+				return "", fmt.Errorf("invalid time in recurrence: %s", input)
+			}
+		}
+
+		var spec string
+		switch match[1] {
+		case "каждый день", "ежедневно", "every day", "daily":
+			spec = fmt.Sprintf("%d %d * * *", minute, hour)
+		case "каждую неделю", "еженедельно", "every week", "weekly":
+			spec = fmt.Sprintf("%d %d * * %d", minute, hour, start.Weekday())
+		default:
+			spec = fmt.Sprintf("%d %d %d * *", minute, hour, start.Day())
+		}
+		return "CRON_TZ=" + loc.String() + " " + spec, nil
+	}
+
+	// иначе считаем, что это cron-выражение
+	spec := input
+	if !strings.HasPrefix(spec, "CRON_TZ=") && !strings.HasPrefix(spec, "TZ=") {
+		spec = "CRON_TZ=" + loc.String() + " " + spec
+	}
+	if _, err := cron.ParseStandard(spec); err != nil {
+		return "", fmt.Errorf("unable to parse recurrence: %w", err)
+	}
+	return spec, nil
+}
+
+// NextOccurrence возвращает ближайшее время срабатывания cron-выражения после after
+func NextOccurrence(spec string, after time.Time) (time.Time, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.Next(after).UTC(), nil
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRecurrence(t *testing.T) {
+	vladivostok, err := time.LoadLocation("Asia/Vladivostok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// четверг, 15 января 2026, 09:30 по Владивостоку
+	start := time.Date(2026, 1, 15, 9, 30, 0, 0, vladivostok)
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"каждый день", "CRON_TZ=Asia/Vladivostok 30 9 * * *"},
+		{"ежедневно в 18:05", "CRON_TZ=Asia/Vladivostok 5 18 * * *"},
+		{"Every  Day at 7:00", "CRON_TZ=Asia/Vladivostok 0 7 * * *"},
+		{"каждую неделю", "CRON_TZ=Asia/Vladivostok 30 9 * * 4"},
+		{"weekly at 10:00", "CRON_TZ=Asia/Vladivostok 0 10 * * 4"},
+		{"ежемесячно", "CRON_TZ=Asia/Vladivostok 30 9 15 * *"},
+		{"monthly at 23:59", "CRON_TZ=Asia/Vladivostok 59 23 15 * *"},
+		{"0 12 * * 1-5", "CRON_TZ=Asia/Vladivostok 0 12 * * 1-5"},
+		{"CRON_TZ=UTC 0 12 * * *", "CRON_TZ=UTC 0 12 * * *"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseRecurrence(tt.input, start, vladivostok)
+			if err != nil {
+				t.Fatalf("ParseRecurrence(%q) error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRecurrence(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRecurrenceUsesStartInLocation(t *testing.T) {
+	// 23:30 UTC в среду - это 08:30 четверга в Токио
+	start := time.Date(2026, 1, 14, 23, 30, 0, 0, time.UTC)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseRecurrence("каждую неделю", start, tokyo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "CRON_TZ=Asia/Tokyo 30 8 * * 4"; got != want {
+		t.Errorf("ParseRecurrence() = %q, want %q", got, want)
+	}
+}
+
+func TestParseRecurrenceInvalid(t *testing.T) {
+	start := time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC)
+
+	for _, input := range []string{
+		"",
+		"иногда",
+		"каждый день в 24:00",
+		"every day at 10:60",
+		"61 * * * *",
+		"* * *",
+	} {
+		t.Run(input, func(t *testing.T) {
+			if got, err := ParseRecurrence(input, start, time.UTC); err == nil {
+				t.Errorf("ParseRecurrence(%q) = %q, want error", input, got)
+			}
+		})
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	tests := []struct {
+		spec  string
+		after time.Time
+		want  time.Time
+	}{
+		{
+			spec:  "CRON_TZ=UTC 0 10 * * *",
+			after: time.Date(2026, 1, 15, 9, 0, 0, 0, time.UTC),
+			want:  time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			spec:  "CRON_TZ=UTC 0 10 * * *",
+			after: time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC),
+			want:  time.Date(2026, 1, 16, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			// 10:00 по Москве - 07:00 UTC
+			spec:  "CRON_TZ=Europe/Moscow 0 10 * * 1",
+			after: time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC),
+			want:  time.Date(2026, 1, 19, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			spec:  "CRON_TZ=UTC 30 9 31 * *",
+			after: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+			want:  time.Date(2026, 3, 31, 9, 30, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := NextOccurrence(tt.spec, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("NextOccurrence(%q, %v) = %v, want %v", tt.spec, tt.after, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NextOccurrence("not a cron", time.Now()); err == nil {
+		t.Error("NextOccurrence with invalid spec: want error")
+	}
+}
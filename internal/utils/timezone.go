@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimezone - часовой пояс пользователей, которые не указали свой
+const DefaultTimezone = "Europe/Moscow"
+
+// города, которые можно указать в /timezone вместо названия часового пояса
+var timezoneAliases = map[string]string{
+	"калининград":     "Europe/Kaliningrad",
+	"москва":          "Europe/Moscow",
+	"мск":             "Europe/Moscow",
+	"санкт-петербург": "Europe/Moscow",
+	"спб":             "Europe/Moscow",
+	"самара":          "Europe/Samara",
+	"екатеринбург":    "Asia/Yekaterinburg",
+	"омск":            "Asia/Omsk",
+	"новосибирск":     "Asia/Novosibirsk",
+	"красноярск":      "Asia/Krasnoyarsk",
+	"иркутск":         "Asia/Irkutsk",
+	"якутск":          "Asia/Yakutsk",
+	"владивосток":     "Asia/Vladivostok",
+	"магадан":         "Asia/Magadan",
+	"камчатка":        "Asia/Kamchatka",
+	"петропавловск-камчатский": "Asia/Kamchatka",
+}
+
+// смещение от UTC или от Москвы: "+3", "UTC+10", "GMT-5", "МСК+7"
+var timezoneOffset = regexp.MustCompile(`^(utc|gmt|мск|msk)?\s*([+-]\d{1,2})$`)
+
+// LoadTimezone возвращает часовой пояс по названию, для пустого или неизвестного - московский
+func LoadTimezone(name string) *time.Location {
+	if name == "" {
+		name = DefaultTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc, _ = time.LoadLocation(DefaultTimezone)
+	}
+	return loc
+}
+
+// ParseTimezone разбирает часовой пояс, который ввёл пользователь: название IANA (Asia/Vladivostok),
+// город (Владивосток) или смещение (UTC+10, МСК+7). Возвращает название IANA
+func ParseTimezone(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if name, ok := timezoneAliases[strings.ToLower(input)]; ok {
+		return name, nil
+	}
+
+	if match := timezoneOffset.FindStringSubmatch(strings.ToLower(input)); match != nil {
+		offset, _ := strconv.Atoi(match[2])
+		if match[1] == "мск" || match[1] == "msk" {
+			offset += 3
+		}
+		if offset < -12 || offset > 14 {
+			return "", fmt.Errorf("invalid UTC offset: %s", input)
+		}
+		if offset == 0 {
+			return "UTC", nil
+		}
+		// в базе IANA у зон Etc/GMT знак смещения обратный: Etc/GMT-10 это UTC+10
+		return fmt.Sprintf("Etc/GMT%+d", -offset), nil
+	}
+
+	if input == "" || strings.EqualFold(input, "local") {
+		return "", fmt.Errorf("unknown timezone: %q", input)
+	}
+	if _, err := time.LoadLocation(input); err != nil {
+		return "", fmt.Errorf("unknown timezone: %s", input)
+	}
+	return input, nil
+}
+
+// DescribeTimezone возвращает часовой пояс с текущим смещением от UTC, например "Asia/Vladivostok (UTC+10)"
+func DescribeTimezone(name string) string {
+	loc := LoadTimezone(name)
+	_, offset := time.Now().In(loc).Zone()
+	hours, minutes := offset/3600, (offset%3600)/60
+	if minutes < 0 {
+		minutes = -minutes
+	}
+	if minutes != 0 {
+		return fmt.Sprintf("%s (UTC%+d:%02d)", loc, hours, minutes)
+	}
+	return fmt.Sprintf("%s (UTC%+d)", loc, hours)
+}
+
+// EarliestTimezone - часовой пояс, в котором раньше всех наступает любое время суток (UTC+14)
+const EarliestTimezone = "Etc/GMT-14"
+
+// SameWallClock возвращает момент, когда в часовом поясе loc на часах будут те же дата и время, что у t
+func SameWallClock(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimezone(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Asia/Vladivostok", "Asia/Vladivostok"},
+		{"  Europe/Kaliningrad ", "Europe/Kaliningrad"},
+		{"UTC", "UTC"},
+		{"Владивосток", "Asia/Vladivostok"},
+		{"СПБ", "Europe/Moscow"},
+		{"Петропавловск-Камчатский", "Asia/Kamchatka"},
+		{"+3", "Etc/GMT-3"},
+		{"UTC+10", "Etc/GMT-10"},
+		{"utc +5", "Etc/GMT-5"},
+		{"GMT-5", "Etc/GMT+5"},
+		{"UTC+0", "UTC"},
+		{"UTC+14", "Etc/GMT-14"},
+		{"UTC-12", "Etc/GMT+12"},
+		{"МСК+7", "Etc/GMT-10"},
+		{"msk-1", "Etc/GMT-2"},
+		{"МСК-3", "UTC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseTimezone(tt.input)
+			if err != nil {
+				t.Fatalf("ParseTimezone(%q) error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTimezone(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if _, err := time.LoadLocation(got); err != nil {
+				t.Errorf("ParseTimezone(%q) = %q, which cannot be loaded: %v", tt.input, got, err)
+			}
+		})
+	}
+}
+
+func TestParseTimezoneInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"   ",
+		"Local",
+		"Mars/Olympus",
+		"UTC+15",
+		"UTC-13",
+		"МСК+12",
+		"+100",
+	} {
+		t.Run(input, func(t *testing.T) {
+			if got, err := ParseTimezone(input); err == nil {
+				t.Errorf("ParseTimezone(%q) = %q, want error", input, got)
+			}
+		})
+	}
+}
+
+func TestLoadTimezone(t *testing.T) {
+	if got := LoadTimezone("").String(); got != DefaultTimezone {
+		t.Errorf("LoadTimezone(\"\") = %q, want %q", got, DefaultTimezone)
+	}
+	if got := LoadTimezone("Mars/Olympus").String(); got != DefaultTimezone {
+		t.Errorf("LoadTimezone(unknown) = %q, want %q", got, DefaultTimezone)
+	}
+	if got := LoadTimezone("Asia/Omsk").String(); got != "Asia/Omsk" {
+		t.Errorf("LoadTimezone(\"Asia/Omsk\") = %q", got)
+	}
+}
+
+func TestSameWallClock(t *testing.T) {
+	omsk := LoadTimezone("Asia/Omsk")
+	got := SameWallClock(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC), omsk)
+	// 09:00 по Омску - 03:00 UTC
+	if want := time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("SameWallClock() = %v, want %v", got, want)
+	}
+}
@@ -2,12 +2,18 @@ package utils
 
 import (
 	"fmt"
-	"log"
 	"strings"
 	"time"
 )
 
+// ParseTime разбирает дату и время по московскому времени
 func ParseTime(input string) (time.Time, error) {
+	return ParseTimeIn(input, LoadTimezone(DefaultTimezone))
+}
+
+// ParseTimeIn разбирает дату и время в часовом поясе loc.
+// Кроме точных форматов понимает относительные даты, см. ParseNaturalTime
+func ParseTimeIn(input string, loc *time.Location) (time.Time, error) {
 	// Время в разных форматах
 	formats := []string{
 		"02.01.2006 15:04",
@@ -18,20 +24,15 @@ func ParseTime(input string) (time.Time, error) {
 		"15:04 02.01.2006",
 	}
 
-	// Указываем московский часовой пояс
-	loc, err := time.LoadLocation("Europe/Moscow")
-	if err != nil {
-		return time.Time{}, err
-	}
-
 	for _, format := range formats {
-		// Парсим с учётом MSK
 		t, err := time.ParseInLocation(format, input, loc)
 		if err == nil {
-			log.Println("Time: t", t)
-			return t, nil // Возвращаем время в MSK
+			return t, nil
 		}
 	}
+	if t, ok := ParseNaturalTime(input, time.Now().In(loc)); ok {
+		return t, nil
+	}
 	return time.Time{}, fmt.Errorf("unable to parse time: %s", input)
 }
 
@@ -45,6 +46,5 @@ func ParseCommand(text string) (string, []string) {
 	if len(parts) > 1 {
 		return command, parts[1:]
 	}
-	log.Print(command)
 	return command, nil
 }
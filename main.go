@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,11 +11,17 @@ import (
 
 	"github.com/g0shi4ek/VK_bot/config"
 	"github.com/g0shi4ek/VK_bot/database"
+	"github.com/g0shi4ek/VK_bot/internal/api"
 	"github.com/g0shi4ek/VK_bot/internal/bot"
+	"github.com/g0shi4ek/VK_bot/internal/health"
+	"github.com/g0shi4ek/VK_bot/internal/logging"
+	"github.com/g0shi4ek/VK_bot/internal/metrics"
 	"github.com/g0shi4ek/VK_bot/internal/notifier"
 	"github.com/g0shi4ek/VK_bot/internal/scheduler"
 	"github.com/g0shi4ek/VK_bot/internal/segmenter"
+	"github.com/g0shi4ek/VK_bot/models"
 	botgolang "github.com/mail-ru-im/bot-golang"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func main() {
@@ -24,59 +31,207 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// журнал передаётся всем сервисам; стандартный log тоже пишет в него
+	logger, err := logging.New(os.Stdout, logging.Options{
+		Level:  cfg.LogLevel,
+		Format: cfg.LogFormat,
+		Redact: cfg.LogRedact,
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure logging: %v", err)
+	}
+	slog.SetDefault(logger)
+
 	// Mongodb клиент
 	dbClient, err := database.Connect(context.Background(), cfg.DatabaseURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal(logger, "Failed to connect to database", err)
 	}
 	defer dbClient.Disconnect(context.Background())
 
-	// инициализация бота
-	vkBot, _ := botgolang.NewBot(cfg.BotToken, botgolang.BotDebug(cfg.Debug))
+	// инициализация бота, без токена бот не получает обновления
+	var vkBot *botgolang.Bot
+	if cfg.BotToken != "" {
+		vkBot, err = botgolang.NewBot(cfg.BotToken, botgolang.BotDebug(cfg.Debug))
+		if err != nil {
+			fatal(logger, "Failed to create bot", err)
+		}
+	}
+
+	// выбор канала доставки сообщений
+	var transport notifier.Transport
+	switch cfg.Transport {
+	case config.TransportMemory:
+		logger.Warn("Using in-memory transport, messages will not be delivered")
+		transport = notifier.NewMemoryTransport()
+	default:
+		transport = notifier.NewBotTransport(vkBot)
+	}
 
 	// инициализация сервисов
-	segmenterService := segmenter.NewSegmenter(dbClient)
-	notifierService := notifier.NewNotifier(vkBot, dbClient)
-	schedulerService := scheduler.NewScheduler(dbClient, notifierService, segmenterService)
+	segmenterService := segmenter.NewSegmenter(dbClient, logger)
+	notifierService := notifier.NewNotifier(transport, dbClient, segmenterService, notifier.Options{
+		Workers:   cfg.NotifierWorkers,
+		Rate:      cfg.NotifierRate,
+		Burst:     cfg.NotifierBurst,
+		ChatRate:  cfg.NotifierChatRate,
+		ChatBurst: cfg.NotifierChatBurst,
+		Retry: notifier.RetryPolicy{
+			MaxAttempts: cfg.NotifierMaxAttempts,
+			BaseDelay:   cfg.NotifierRetryBaseDelay,
+			MaxDelay:    cfg.NotifierRetryMaxDelay,
+		},
+	}, logger)
+	schedulerService := scheduler.NewScheduler(dbClient, notifierService, segmenterService, cfg.SchedulerLease, logger)
 	// заполнение базовых сегментов
 	baseSegments := []string{"all", "clients", "workers"}
 	for _, name := range baseSegments {
 		err := segmenterService.CreateSegmentIfNotExists(context.Background(), name)
 		if err != nil {
-			log.Printf("Ошибка инициализации сегмента %s: %v", name, err)
+			logger.Error("Failed to create base segment", "segment", name, "error", err)
+		}
+	}
+
+	// перевод старых рассылок с is_sent на состояния
+	mailingRepo := database.NewMailingRepository(dbClient)
+	migrated, err := mailingRepo.MigrateIsSent(context.Background())
+	if err != nil {
+		logger.Error("Failed to migrate mailings", "error", err)
+	} else if migrated > 0 {
+		logger.Info("Mailings migrated", "count", migrated)
+	}
+	// без индексов планировщик и журнал доставки работают медленно, а уникальные индексы
+	// защищают от повторных записей, поэтому без них бот не запускается
+	if err := mailingRepo.EnsureIndexes(context.Background()); err != nil {
+		fatal(logger, "Failed to create mailing indexes", err)
+	}
+	if err := database.NewUserStateRepository(dbClient).EnsureIndexes(context.Background()); err != nil {
+		fatal(logger, "Failed to create user state indexes", err)
+	}
+	if err := database.NewButtonClickRepository(dbClient).EnsureIndexes(context.Background()); err != nil {
+		fatal(logger, "Failed to create button click indexes", err)
+	}
+	if err := database.NewDeliveryRepository(dbClient).EnsureIndexes(context.Background()); err != nil {
+		fatal(logger, "Failed to create delivery indexes", err)
+	}
+
+	// назначение администраторов из конфигурации
+	userRepo := database.NewUserRepository(dbClient)
+	for _, chatID := range cfg.AdminChatIDs {
+		err := userRepo.SetRole(context.Background(), chatID, models.RoleAdmin)
+		if err != nil && err != mongo.ErrNoDocuments {
+			logger.Error("Failed to grant admin role", "chat_id", chatID, "error", err)
 		}
 	}
 
 	// подключение хендлеров
-	botHandler := bot.NewHandler(vkBot, dbClient, notifierService, segmenterService, schedulerService)
+	botHandler := bot.NewHandler(vkBot, dbClient, notifierService, segmenterService, schedulerService,
+		cfg.AdminChatIDs, cfg.ApproverChatIDs, cfg.ProtectedSegments, logger)
 
 	// отложенные
 	schedulerService.Start()
 
-	// запуск бота
-	go func() {
-		if err := botHandler.Start(); err != nil {
-			log.Fatalf("Failed to start bot: %v", err)
+	// HTTP API для внутренних сервисов
+	var apiServer *api.Server
+	if cfg.APIAddr != "" {
+		apiServer = api.NewServer(cfg.APIAddr, cfg.APITokens, botHandler, dbClient, segmenterService, logger)
+		go func() {
+			if err := apiServer.Start(); err != nil {
+				fatal(logger, "Failed to start API server", err)
+			}
+		}()
+	}
+
+	// проверки работоспособности для оркестратора работают всегда:
+	// /healthz - бот читает обновления и планировщик работает, /readyz - дополнительно доступна база
+	liveness := health.NewChecker(logger)
+	readiness := health.NewChecker(logger)
+	readiness.Add("mongodb", dbClient.CheckHealth)
+	if vkBot != nil {
+		liveness.Add("updates", botHandler.CheckUpdates)
+		readiness.Add("updates", botHandler.CheckUpdates)
+	}
+	liveness.Add("scheduler", schedulerService.CheckHealth)
+	readiness.Add("scheduler", schedulerService.CheckHealth)
+
+	// метрики Prometheus; если адрес совпадает с HEALTH_ADDR, проверки отдаются тем же сервером
+	var metricsServer *metrics.Server
+	if cfg.MetricsAddr != "" {
+		metricsServer = metrics.NewServer(cfg.MetricsAddr, dbClient, logger)
+		if cfg.MetricsAddr == cfg.HealthAddr {
+			metricsServer.Handle("GET /healthz", liveness.Handler())
+			metricsServer.Handle("GET /readyz", readiness.Handler())
 		}
-	}()
+		go func() {
+			if err := metricsServer.Start(); err != nil {
+				fatal(logger, "Failed to start metrics server", err)
+			}
+		}()
+	}
+
+	var healthServer *health.Server
+	if cfg.HealthAddr != cfg.MetricsAddr {
+		healthServer = health.NewServer(cfg.HealthAddr, liveness, readiness, logger)
+		go func() {
+			if err := healthServer.Start(); err != nil {
+				fatal(logger, "Failed to start health server", err)
+			}
+		}()
+	}
+
+	// запуск бота
+	if vkBot != nil {
+		go func() {
+			if err := botHandler.Start(); err != nil {
+				fatal(logger, "Failed to start bot", err)
+			}
+		}()
+	}
 
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
+	logger.Info("Shutting down server")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// остановка API
+	if apiServer != nil {
+		if err := apiServer.Shutdown(ctx); err != nil {
+			logger.Error("Failed to shut down API server", "error", err)
+		}
+	}
+
+	// остановка метрик
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			logger.Error("Failed to shut down metrics server", "error", err)
+		}
+	}
+
+	// остановка проверок работоспособности
+	if healthServer != nil {
+		if err := healthServer.Shutdown(ctx); err != nil {
+			logger.Error("Failed to shut down health server", "error", err)
+		}
+	}
+
 	// остановка отложенных
 	schedulerService.Stop()
 
 	// отключение от бд
 	if err := dbClient.Disconnect(ctx); err != nil {
-		log.Fatalf("Failed to disconnect from database: %v", err)
+		fatal(logger, "Failed to disconnect from database", err)
 	}
 
-	log.Println("Server exited properly")
+	logger.Info("Server exited properly")
+}
+
+// fatal пишет ошибку в журнал и завершает процесс
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }
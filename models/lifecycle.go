@@ -0,0 +1,85 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+type MailingStatus string
+
+const (
+	MailingDraft           MailingStatus = "draft"
+	MailingPendingApproval MailingStatus = "pending_approval"
+	MailingScheduled       MailingStatus = "scheduled"
+	MailingSending         MailingStatus = "sending"
+	MailingSent            MailingStatus = "sent"
+	MailingPartiallyFailed MailingStatus = "partially_failed"
+	MailingFailed          MailingStatus = "failed"
+	MailingCancelled       MailingStatus = "cancelled"
+)
+
+// StatusChange - переход рассылки между состояниями
+type StatusChange struct {
+	From MailingStatus `bson:"from"`
+	To   MailingStatus `bson:"to"`
+	At   time.Time     `bson:"at"`
+}
+
+// допустимые переходы между состояниями рассылки
+var mailingTransitions = map[MailingStatus][]MailingStatus{
+	MailingDraft: {MailingScheduled, MailingPendingApproval, MailingCancelled},
+	// рассылка на защищённый сегмент ждёт подтверждения второго человека;
+	// после отказа или если подтверждение не успели дать до даты отправки, возвращается в черновик
+	MailingPendingApproval: {MailingScheduled, MailingDraft, MailingCancelled},
+	MailingScheduled:       {MailingDraft, MailingPendingApproval, MailingSending, MailingCancelled},
+	// повторяющаяся рассылка после отправки снова ждёт следующего срабатывания
+	MailingSending:         {MailingSent, MailingPartiallyFailed, MailingFailed, MailingScheduled},
+	MailingSent:            {},
+	MailingPartiallyFailed: {},
+	MailingFailed:          {MailingScheduled},
+	MailingCancelled:       {},
+}
+
+// Valid сообщает, что такое состояние рассылки существует
+func (s MailingStatus) Valid() bool {
+	_, ok := mailingTransitions[s]
+	return ok
+}
+
+func (s MailingStatus) CanTransitionTo(to MailingStatus) bool {
+	for _, allowed := range mailingTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition переводит рассылку в состояние to и запоминает время перехода
+func (m *Mailing) Transition(to MailingStatus, at time.Time) error {
+	from := m.Status
+	if from == "" {
+		from = MailingDraft
+	}
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("invalid mailing status transition %s -> %s", from, to)
+	}
+
+	m.Status = to
+	m.StatusHistory = append(m.StatusHistory, StatusChange{
+		From: from,
+		To:   to,
+		At:   at.UTC(),
+	})
+	return nil
+}
+
+// StatusChangedAt возвращает время последнего перехода в состояние status
+func (m *Mailing) StatusChangedAt(status MailingStatus) (time.Time, bool) {
+	for i := len(m.StatusHistory) - 1; i >= 0; i-- {
+		if m.StatusHistory[i].To == status {
+			return m.StatusHistory[i].At, true
+		}
+	}
+	return time.Time{}, false
+}
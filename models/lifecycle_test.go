@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMailingTransition(t *testing.T) {
+	at := time.Date(2026, 1, 15, 10, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	mailing := &Mailing{}
+
+	// рассылка без состояния считается черновиком
+	for _, to := range []MailingStatus{MailingScheduled, MailingSending, MailingScheduled, MailingSending, MailingSent} {
+		if err := mailing.Transition(to, at); err != nil {
+			t.Fatalf("Transition(%s): %v", to, err)
+		}
+	}
+	if mailing.Status != MailingSent || len(mailing.StatusHistory) != 5 {
+		t.Fatalf("mailing = %+v, want sent with 5 changes", mailing)
+	}
+	if first := mailing.StatusHistory[0]; first.From != MailingDraft || first.To != MailingScheduled || first.At.Location() != time.UTC {
+		t.Errorf("first change = %+v, want draft -> scheduled in UTC", first)
+	}
+	if changedAt, ok := mailing.StatusChangedAt(MailingSending); !ok || !changedAt.Equal(at) {
+		t.Errorf("StatusChangedAt(sending) = %v, %v", changedAt, ok)
+	}
+	if _, ok := mailing.StatusChangedAt(MailingCancelled); ok {
+		t.Error("StatusChangedAt(cancelled) found a change that did not happen")
+	}
+
+	// из итоговых состояний выйти нельзя
+	if err := mailing.Transition(MailingScheduled, at); err == nil {
+		t.Error("sent mailing was rescheduled")
+	}
+	if mailing.Status != MailingSent || len(mailing.StatusHistory) != 5 {
+		t.Errorf("failed transition changed mailing: %+v", mailing)
+	}
+}
+
+func TestCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to MailingStatus
+		want     bool
+	}{
+		{MailingDraft, MailingScheduled, true},
+		{MailingDraft, MailingSending, false},
+		{MailingDraft, MailingSent, false},
+		{MailingScheduled, MailingSent, false},
+		{MailingScheduled, MailingCancelled, true},
+		{MailingSending, MailingCancelled, false},
+		{MailingSending, MailingScheduled, true},
+		{MailingFailed, MailingScheduled, true},
+		{MailingPartiallyFailed, MailingScheduled, false},
+		{MailingCancelled, MailingScheduled, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+			t.Errorf("%s -> %s allowed = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
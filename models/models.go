@@ -6,30 +6,163 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+type Role string
+
+const (
+	RoleAdmin      Role = "admin"
+	RoleEditor     Role = "editor"
+	RoleSubscriber Role = "subscriber"
+)
+
 type User struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty"`
-	ChatID    string             `bson:"chat_id"`
-	FirstName string             `bson:"first_name"`
-	LastName  string             `bson:"last_name"`
-	Segments  []string           `bson:"segments"`
-	CreatedAt time.Time          `bson:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at"`
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	ChatID     string             `bson:"chat_id"`
+	FirstName  string             `bson:"first_name"`
+	LastName   string             `bson:"last_name"`
+	Segments   []string           `bson:"segments"`
+	Role       Role               `bson:"role"`
+	Attributes map[string]string  `bson:"attributes,omitempty"` // произвольные поля, например department
+	OptedOut   bool               `bson:"opted_out"`            // отписался от рассылок, получает только обязательные
+	Timezone   string             `bson:"timezone,omitempty"`   // название IANA, пустое - московское время
+	CreatedAt  time.Time          `bson:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at"`
 }
 
 type Mailing struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty"`
-	Name        string             `bson:"name"`
-	Message     string             `bson:"message"`
-	Segment     string             `bson:"segment"`
-	ScheduledAt time.Time          `bson:"scheduled_at"`
-	IsSent      bool               `bson:"is_sent"`
-	CreatedAt   time.Time          `bson:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at"`
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	Name            string             `bson:"name"`
+	Message         string             `bson:"message"`
+	Segment         string             `bson:"segment"` // единственный сегмент рассылок, созданных до появления Segments
+	Segments        []string           `bson:"segments,omitempty"`
+	ExcludeSegments []string           `bson:"exclude_segments"` // участники этих сегментов не получат рассылку
+	FileID          string             `bson:"file_id"`          // вложение, ранее загруженное в VK Teams; без omitempty, чтобы его можно было убрать
+	FileType        string             `bson:"file_type"`
+	Buttons         []Button           `bson:"buttons,omitempty"`
+	ScheduledAt     time.Time          `bson:"scheduled_at"`
+	Timezone        string             `bson:"timezone,omitempty"` // часовой пояс автора, в нём указаны дата и повтор
+	LocalTime       bool               `bson:"local_time"`         // отправлять в указанное время по часовому поясу каждого получателя
+	OccurrenceAt    *time.Time         `bson:"occurrence_at"`      // дата текущей отправки LocalTime-рассылки, ScheduledAt у неё - ближайшая волна
+	SentZones       []string           `bson:"sent_zones"`         // часовые пояса, в которые текущая отправка LocalTime-рассылки уже ушла
+	Status          MailingStatus      `bson:"status"`
+	StatusHistory   []StatusChange     `bson:"status_history,omitempty"`
+	LeaseOwner      string             `bson:"lease_owner,omitempty"` // экземпляр бота, который сейчас отправляет рассылку
+	LeaseExpiresAt  *time.Time         `bson:"lease_expires_at,omitempty"`
+	Recurrence      string             `bson:"recurrence,omitempty"` // cron-выражение, пустое для разовых рассылок
+	RecurrenceEnd   *time.Time         `bson:"recurrence_end,omitempty"`
+	MaxOccurrences  int                `bson:"max_occurrences,omitempty"`
+	Occurrences     int                `bson:"occurrences"`
+	SendRetries     int                `bson:"send_retries,omitempty"` // сколько раз подряд отправка не началась из-за ошибки
+	Mandatory       bool               `bson:"mandatory"`              // обязательная рассылка приходит и отписавшимся
+	AuthorChatID    string             `bson:"author_chat_id,omitempty"`
+	Approval        *Approval          `bson:"approval"` // без omitempty, чтобы при повторной отправке на подтверждение решение стиралось
+	CreatedAt       time.Time          `bson:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at"`
+}
+
+// IncludedSegments возвращает сегменты, участникам которых отправляется рассылка
+func (m *Mailing) IncludedSegments() []string {
+	if len(m.Segments) > 0 {
+		return m.Segments
+	}
+	if m.Segment != "" {
+		return []string{m.Segment}
+	}
+	return nil
+}
+
+// Approval - решение по рассылке на защищённый сегмент
+type Approval struct {
+	ChatID   string    `bson:"chat_id"`
+	Approved bool      `bson:"approved"`
+	At       time.Time `bson:"at"`
+}
+
+// Button - кнопка под сообщением рассылки: ссылка, если задан URL, иначе кнопка-отклик
+type Button struct {
+	Text string `bson:"text"`
+	URL  string `bson:"url,omitempty"`
 }
 
 type Segment struct {
+	ID   primitive.ObjectID `bson:"_id,omitempty"`
+	Name string             `bson:"name"`
+	// правило динамического сегмента; пустое у обычных сегментов, в которые пользователи добавляются сами
+	Rule      *SegmentRule `bson:"rule,omitempty"`
+	CreatedAt time.Time    `bson:"created_at"`
+	UpdatedAt time.Time    `bson:"updated_at"`
+}
+
+// SegmentRule - условие на пользователя. Составное условие задаётся через All, Any или Not,
+// простое - через Field, Op и Value
+type SegmentRule struct {
+	All   []SegmentRule `bson:"all,omitempty"`
+	Any   []SegmentRule `bson:"any,omitempty"`
+	Not   *SegmentRule  `bson:"not,omitempty"`
+	Field string        `bson:"field,omitempty"` // segment, registered, role или attributes.<поле>
+	Op    RuleOp        `bson:"op,omitempty"`
+	Value string        `bson:"value,omitempty"`
+}
+
+type RuleOp string
+
+const (
+	RuleEq     RuleOp = "eq"
+	RuleNe     RuleOp = "ne"
+	RuleBefore RuleOp = "before"
+	RuleAfter  RuleOp = "after"
+)
+
+type UserState struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty"`
+	ChatID    string                 `bson:"chat_id"`
+	Status    string                 `bson:"status"`
+	Data      map[string]interface{} `bson:"data"`
+	UpdatedAt time.Time              `bson:"updated_at"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliverySent   DeliveryStatus = "sent"
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// Delivery - попытка отправки рассылки одному получателю
+type Delivery struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	MailingID  primitive.ObjectID `bson:"mailing_id"`
+	UserID     primitive.ObjectID `bson:"user_id"`
+	ChatID     string             `bson:"chat_id"`
+	Occurrence int                `bson:"occurrence"` // номер отправки повторяющейся рассылки
+	Status     DeliveryStatus     `bson:"status"`
+	Error      string             `bson:"error,omitempty"`
+	Permanent  bool               `bson:"permanent,omitempty"` // ошибка, которую бесполезно повторять
+	Attempts   int                `bson:"attempts"`
+	MessageID  string             `bson:"message_id,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at"`
+}
+
+// DeadLetter - получатель, которому не удалось доставить рассылку после всех повторов
+type DeadLetter struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	MailingID  primitive.ObjectID `bson:"mailing_id"`
+	Occurrence int                `bson:"occurrence"`
+	UserID     primitive.ObjectID `bson:"user_id"`
+	ChatID     string             `bson:"chat_id"`
+	Text       string             `bson:"text"`
+	FileID     string             `bson:"file_id,omitempty"`
+	Error      string             `bson:"error"`
+	Attempts   int                `bson:"attempts"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at"`
+}
+
+// ButtonClick - нажатие пользователем кнопки-отклика в рассылке
+type ButtonClick struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty"`
-	Name        string             `bson:"name"`
+	MailingID   primitive.ObjectID `bson:"mailing_id"`
+	ButtonIndex int                `bson:"button_index"`
+	ButtonText  string             `bson:"button_text"`
+	ChatID      string             `bson:"chat_id"`
 	CreatedAt   time.Time          `bson:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at"`
-}
\ No newline at end of file
+}